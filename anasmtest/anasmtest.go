@@ -0,0 +1,190 @@
+package anasmtest
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/avm-collection/agen"
+
+	"github.com/avm-collection/anasm/internal/compiler"
+	"github.com/avm-collection/anasm/internal/config"
+	"github.com/avm-collection/anasm/internal/disasm"
+	"github.com/avm-collection/anasm/internal/executable"
+)
+
+// Helpers for the tests of tools built on anasm, which need to assemble snippets and look at the
+// instructions and memory they produce. Binaries are decoded by the same code the disassembler
+// uses.
+//
+// Every assembly has its own diagnostics, so a failed one does not fail the ones after it, and
+// assemblies can run in parallel tests.
+
+type Inst struct {
+	Name    string // Lower case instruction name
+	Op      byte
+	Operand uint64 // 0 if the instruction takes none
+}
+
+func (i Inst) String() string {
+	return fmt.Sprintf("%v %v", i.Name, i.Operand)
+}
+
+type Binary struct {
+	Version    [3]byte // Major, minor, patch
+	EntryPoint uint64
+	MemorySize uint64
+
+	Memory []byte
+	Insts  []Inst
+}
+
+// Decodes an AVM executable
+func Decode(data []byte) (*Binary, error) {
+	exe, err := executable.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	bin := &Binary{
+		Version: exe.Version, EntryPoint: uint64(exe.EntryPoint),
+		MemorySize: uint64(exe.MemorySize), Memory: exe.Memory,
+	}
+
+	for i := agen.Word(0); i < exe.InstCount(); i ++ {
+		inst := exe.Inst(i)
+
+		name, _, err := disasm.InstFromOp(inst.Op)
+		if err != nil {
+			return nil, fmt.Errorf("Instruction %v: %v", i, err)
+		}
+
+		bin.Insts = append(bin.Insts, Inst{Name: name, Op: inst.Op, Operand: uint64(inst.Arg)})
+	}
+
+	return bin, nil
+}
+
+// Assembles the source with the default options into an executable. Includes resolve from the
+// current directory and the library directory.
+func AssembleBytes(src string) ([]byte, error) {
+	c := compiler.New(src, "<test>", compiler.Options{IncludeDirs: config.LibDirs()})
+	if ok := c.Compile(); !ok {
+		return nil, fmt.Errorf("Assembling failed, see the diagnostics")
+	}
+
+	return c.Exec(false)
+}
+
+// Like AssembleBytes, but decodes the result
+func Assemble(src string) (*Binary, error) {
+	data, err := AssembleBytes(src)
+	if err != nil {
+		return nil, err
+	}
+
+	return Decode(data)
+}
+
+// Assembles the source, disassembles the executable and assembles the disassembly again, erroring
+// if the two executables differ. Returns the first executable. Names of the source are lost in
+// the disassembly, so only the bytes are compared.
+func RoundTrip(src string) ([]byte, error) {
+	first, err := AssembleBytes(src)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", config.AppName)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "out.anasm")
+	if ok := disasm.New(first, "<test>").Disassemble(path); !ok {
+		return nil, fmt.Errorf("Disassembling failed, see the diagnostics")
+	}
+
+	disassembly, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	second, err := AssembleBytes(string(disassembly))
+	if err != nil {
+		return nil, fmt.Errorf("Disassembly does not assemble: %v\n%s", err, disassembly)
+	}
+
+	if !bytes.Equal(first, second) {
+		i := 0
+		for i < len(first) && i < len(second) && first[i] == second[i] {
+			i ++
+		}
+
+		return nil, fmt.Errorf("Round trip differs at byte %v (%v and %v bytes long)\n%s",
+		                       i, len(first), len(second), disassembly)
+	}
+
+	return first, nil
+}
+
+// Like AssembleBytes, but fails the test on errors
+func MustAssembleBytes(t testing.TB, src string) []byte {
+	t.Helper()
+
+	data, err := AssembleBytes(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+// Like Assemble, but fails the test on errors
+func MustAssemble(t testing.TB, src string) *Binary {
+	t.Helper()
+
+	bin, err := Assemble(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bin
+}
+
+// Like RoundTrip, but fails the test on errors and decodes the executable
+func MustRoundTrip(t testing.TB, src string) *Binary {
+	t.Helper()
+
+	data, err := RoundTrip(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bin, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bin
+}
+
+// Reports every instruction that differs from the wanted one by name or operand, and a different
+// instruction count. Op of the wanted instructions is ignored, so they can be written by name.
+func AssertInsts(t testing.TB, bin *Binary, want []Inst) {
+	t.Helper()
+
+	for i := 0; i < len(bin.Insts) && i < len(want); i ++ {
+		got := bin.Insts[i]
+		if got.Name != want[i].Name || got.Operand != want[i].Operand {
+			t.Errorf("Instruction %v is '%v', expected '%v'", i, got, want[i])
+		}
+	}
+
+	if len(bin.Insts) != len(want) {
+		t.Errorf("Got %v instructions, expected %v", len(bin.Insts), len(want))
+	}
+}
@@ -0,0 +1,47 @@
+package anasmtest
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/avm-collection/anasm/internal/compiler"
+)
+
+// Every program of testdata/roundtrip has to assemble to the same bytes after a disassembly, and
+// together they have to use every instruction, so new instructions are tested both ways
+func TestRoundTrip(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "roundtrip", "*.anasm"))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(paths) == 0 {
+		t.Fatal("No programs in testdata/roundtrip")
+	}
+
+	used := make(map[string]bool)
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			src, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, inst := range MustRoundTrip(t, string(src)).Insts {
+				used[inst.Name] = true
+			}
+		})
+	}
+
+	missing := []string{}
+	for name := range compiler.Insts {
+		if !used[name] {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		t.Errorf("Instructions not round tripped by testdata/roundtrip: %v", missing)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/avm-collection/anasm/internal/compiler"
+	"github.com/avm-collection/anasm/internal/diag"
+)
+
+// Batch builds (-build). The files are assembled in parallel with compiler.CompileAll, each with
+// the options of the build. Their diagnostics are printed file by file in the order of the files,
+// so the output is the same however the builds were scheduled. Paths in the files resolve the
+// same as when they are assembled one by one from the current directory.
+
+// Arguments are source files, directories (their .anasm files) or directories ending with '/...'
+// (their .anasm files and those of all the directories below)
+func findSources(args []string) ([]string, error) {
+	sources := []string{}
+	for _, arg := range args {
+		dir, recursive := strings.TrimSuffix(arg, "/..."), strings.HasSuffix(arg, "/...")
+
+		info, err := os.Stat(dir)
+		if err != nil {
+			return nil, fmt.Errorf("Could not open '%v'", dir)
+		} else if !info.IsDir() {
+			sources = append(sources, arg)
+			continue
+		}
+
+		err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			} else if info.IsDir() && path != dir && !recursive {
+				return filepath.SkipDir
+			} else if !info.IsDir() && filepath.Ext(path) == ".anasm" {
+				sources = append(sources, path)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Could not read directory '%v'", dir)
+		}
+	}
+
+	sort.Strings(sources)
+	return sources, nil
+}
+
+func build() bool {
+	sources, err := findSources(args)
+	if err != nil {
+		printError(err.Error())
+
+		return false
+	} else if len(sources) == 0 {
+		printError("No source files found")
+
+		return false
+	}
+
+	if len(*cfg) > 0 || len(*syms) > 0 || len(*mp) > 0 {
+		printError("-cfg, -map and -symbols write one file, they can not be used with -build")
+
+		return false
+	} else if len(*cache) > 0 || *xref || *szL || *szF || *dMem {
+		printError("-cache, -xref, -size-per-label, -size-per-file and -dump-memory can not be " +
+		           "used with -build")
+
+		return false
+	}
+
+	dir := *out
+	if len(dir) == 0 {
+		dir = "."
+	} else if err := os.MkdirAll(dir, 0755); err != nil {
+		printError("Could not create directory '%v'", dir)
+
+		return false
+	}
+
+	outputs := make(map[string]string)
+	for _, source := range sources {
+		output := filepath.Join(dir, defaultOut(source))
+		if prev, ok := outputs[output]; ok {
+			printError("'%v' and '%v' would both be written to '%v'", prev, source, output)
+
+			return false
+		}
+
+		outputs[output] = source
+	}
+
+	inputs := []compiler.Input{}
+	for _, source := range sources {
+		data, err := os.ReadFile(source)
+		if err != nil {
+			printError("Could not open file '%v'", source)
+
+			return false
+		}
+
+		inputs = append(inputs, compiler.Input{Source: string(data), Path: source, Exec: *e,
+		                                       Stamp: stampOf(source)})
+	}
+
+	// The options of the build apply to every file, only the stamps differ
+	failed := 0
+	for i, result := range compiler.CompileAll(context.Background(), inputs, options("")) {
+		diag.Print(result.Diags)
+		if !writeResult(sources[i], filepath.Join(dir, defaultOut(sources[i])), result) {
+			failed ++
+		}
+	}
+
+	if failed > 0 {
+		printError("%v of %v files failed to build", failed, len(sources))
+
+		return false
+	}
+
+	return true
+}
+
+// Writes the executable of a file of the build, returns false if the file failed
+func writeResult(source, output string, result compiler.Result) bool {
+	if *tm && result.OK() {
+		fmt.Fprintf(os.Stderr, "%v:\n", source)
+		printStats(result.Stats)
+	}
+
+	if result.Err != nil {
+		printError("'%v': %v", source, result.Err.Error())
+
+		return false
+	} else if !result.OK() {
+		return false
+	} else if *chk {
+		return true
+	} else if sameFile(output, source) && !*force {
+		printError("Output file '%v' is the input file, use -force to overwrite it", output)
+
+		return false
+	} else if !outputNotRead(output, result.Files) {
+		return false
+	}
+
+	if err := os.WriteFile(output, result.Exe, result.Mode); err != nil {
+		printError("Could not write file '%v'", output)
+
+		return false
+	}
+
+	return true
+}
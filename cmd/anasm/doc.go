@@ -0,0 +1,144 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"sort"
+	"encoding/json"
+
+	"github.com/avm-collection/anasm/internal/compiler"
+)
+
+// Instruction documentation (-doc [MNEMONIC], -json for editor plugins)
+
+type instDoc struct {
+	Name     string `json:"name"`
+	Op       byte   `json:"opcode"`
+	Operand  string `json:"operand,omitempty"` // Empty if the instruction takes no argument
+	Width    int    `json:"width,omitempty"`   // Bits of the argument used, 0 for all
+	Since    string `json:"since,omitempty"`
+	Category string `json:"category"`
+	Desc     string `json:"desc"`
+	Arg      string `json:"arg,omitempty"`
+	Example  string `json:"example"`
+}
+
+func newInstDoc(name string, inst compiler.Inst) instDoc {
+	doc := instDoc{
+		Name: name, Op: inst.Op, Category: inst.Category, Desc: inst.Desc, Arg: inst.Arg,
+		Example: inst.Example,
+	}
+
+	if inst.HasArg {
+		doc.Operand = inst.Operand.String()
+		doc.Width   = inst.Width
+	}
+
+	if inst.MinMajor != 0 || inst.MinMinor != 0 {
+		doc.Since = fmt.Sprintf("%v.%v", inst.MinMajor, inst.MinMinor)
+	}
+
+	if len(doc.Example) == 0 && !inst.HasArg {
+		doc.Example = name
+	}
+
+	return doc
+}
+
+// All instructions, by category and then by opcode
+func instDocs() []instDoc {
+	order := make(map[string]int)
+	for i, category := range compiler.Categories {
+		order[category] = i
+	}
+
+	docs := []instDoc{}
+	for name, inst := range compiler.Insts {
+		docs = append(docs, newInstDoc(name, inst))
+	}
+
+	sort.Slice(docs, func(a, b int) bool {
+		if docs[a].Category != docs[b].Category {
+			return order[docs[a].Category] < order[docs[b].Category]
+		}
+
+		return docs[a].Op < docs[b].Op
+	})
+
+	return docs
+}
+
+func printJSON(v interface{}) {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		printError(err.Error())
+
+		os.Exit(1)
+	}
+
+	fmt.Println(string(data))
+}
+
+func doc() {
+	if len(args) > 1 {
+		printError("Unexpected argument '%v'", args[1])
+		printTry("-h")
+
+		os.Exit(1)
+	}
+
+	if len(args) == 0 {
+		docs := instDocs()
+		if *js {
+			printJSON(docs)
+
+			return
+		}
+
+		for i, doc := range docs {
+			if i == 0 || docs[i - 1].Category != doc.Category {
+				if i > 0 {
+					fmt.Println()
+				}
+
+				fmt.Printf("%v:\n", doc.Category)
+			}
+
+			fmt.Printf("  %-4v 0x%02X  %v\n", doc.Name, doc.Op, doc.Desc)
+		}
+
+		return
+	}
+
+	inst, ok := compiler.Insts[args[0]]
+	if !ok {
+		printError("Unknown instruction '%v'", args[0])
+
+		os.Exit(1)
+	}
+
+	doc := newInstDoc(args[0], inst)
+	if *js {
+		printJSON(doc)
+
+		return
+	}
+
+	fmt.Printf("%v (opcode 0x%02X, %v)\n", doc.Name, doc.Op, doc.Category)
+	fmt.Printf("  %v\n", doc.Desc)
+
+	if len(doc.Operand) > 0 {
+		fmt.Printf("  Argument: %v (%v)\n", doc.Arg, doc.Operand)
+		if doc.Width != 0 {
+			fmt.Printf("  Uses the low %v bits of the argument\n", doc.Width)
+		}
+	} else {
+		fmt.Println("  No argument")
+	}
+
+	if len(doc.Since) > 0 {
+		fmt.Printf("  Needs AVM %v\n", doc.Since)
+	}
+
+	fmt.Printf("  Example:  %v\n", doc.Example)
+}
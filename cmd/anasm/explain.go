@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/avm-collection/agen"
+
+	"github.com/avm-collection/anasm/internal/compiler"
+	"github.com/avm-collection/anasm/internal/disasm"
+	"github.com/avm-collection/anasm/internal/lexer"
+	"github.com/avm-collection/anasm/internal/token"
+)
+
+// Annotated source (-explain). The input is assembled and printed back with a comment after
+// every line that emitted something: the index, opcode and operand of its instructions, the
+// address range of its variables and the index of its labels. The variables of included files
+// are listed on the include directive, and the range of their instructions. Nothing is written.
+// The padding of -pad-end is listed after the source.
+
+type explained struct {
+	notes    map[int][]string     // Row of the input -> annotations
+	included map[int][2]agen.Word // Row of an include directive -> first and last instruction
+	padding  []agen.Word          // Indexes of the padding instructions
+	padName  string
+}
+
+// Row of the input a position is in, or of the include directive that led to it
+func inputRow(where token.Where) int {
+	for where.IncludedFrom != nil {
+		where = *where.IncludedFrom
+	}
+
+	return where.Row
+}
+
+func (e *explained) onInst(index agen.Word, op byte, operand agen.Word, where token.Where) {
+	if where.Row == 0 {
+		e.padding       = append(e.padding, index)
+		e.padName, _, _ = disasm.InstFromOp(op)
+		return
+	}
+
+	row := inputRow(where)
+	if where.IncludedFrom != nil {
+		span, ok := e.included[row]
+		if !ok {
+			span[0] = index
+		}
+
+		span[1]         = index
+		e.included[row] = span
+		return
+	}
+
+	text := fmt.Sprintf("0x%02X ???", op)
+	if name, hasArg, err := disasm.InstFromOp(op); err == nil && hasArg {
+		text = fmt.Sprintf("0x%02X %v 0x%X", op, name, operand)
+	} else if err == nil {
+		text = fmt.Sprintf("0x%02X %v", op, name)
+	}
+
+	e.notes[row] = append(e.notes[row], fmt.Sprintf("%v: %v", index, text))
+}
+
+// Variables of included files are listed on the include directive too
+func (e *explained) onData(name string, addr, size agen.Word, where token.Where) {
+	row         := inputRow(where)
+	e.notes[row] = append(e.notes[row], fmt.Sprintf("%v at 0x%X..0x%X (%v bytes)", name, addr,
+	                                                addr + size, size))
+}
+
+func explain(input, path string) bool {
+	e := &explained{notes: make(map[int][]string), included: make(map[int][2]agen.Word)}
+
+	opts       := options(path)
+	opts.OnInst = e.onInst
+	opts.OnData = e.onData
+
+	c := compiler.New(input, path, opts)
+	if ok := c.Compile(); !ok {
+		return false
+	}
+
+	// Labels come first on their line, in the order they are written, the code after them starts
+	// at their index. Symbols are sorted by position.
+	labels := make(map[int][]string)
+	for _, symbol := range c.Symbols() {
+		if symbol.Kind == "label" && symbol.Def.Path == path && symbol.Def.IncludedFrom == nil {
+			row        := symbol.Def.Row
+			labels[row] = append(labels[row], fmt.Sprintf("%v at %v", symbol.Name, symbol.Value))
+		}
+	}
+
+	for row, names := range labels {
+		e.notes[row] = append(names, e.notes[row]...)
+	}
+
+	for row, span := range e.included {
+		e.notes[row] = append(e.notes[row], fmt.Sprintf("included %v..%v", span[0], span[1]))
+	}
+
+	comment := lexer.DefaultComments[0]
+	if len(comments) > 0 {
+		comment = comments[0]
+	}
+
+	lines := strings.Split(strings.TrimSuffix(input, "\n"), "\n")
+	for i, line := range lines {
+		line = strings.TrimSuffix(line, "\r")
+		if notes := e.notes[i + 1]; len(notes) > 0 {
+			fmt.Printf("%v  %v %v\n", line, comment, strings.Join(notes, ", "))
+		} else {
+			fmt.Println(line)
+		}
+	}
+
+	if len(e.padding) > 0 {
+		fmt.Printf("%v padding %v..%v ('%v')\n", comment, e.padding[0],
+		           e.padding[len(e.padding) - 1] + 1, e.padName)
+	}
+
+	return true
+}
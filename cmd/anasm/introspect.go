@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"unicode"
+
+	"github.com/avm-collection/anasm/internal/config"
+	"github.com/avm-collection/anasm/internal/token"
+	"github.com/avm-collection/anasm/internal/lexer"
+	"github.com/avm-collection/anasm/internal/node"
+	"github.com/avm-collection/anasm/internal/parser"
+	"github.com/avm-collection/anasm/internal/compiler"
+)
+
+// Machine readable description of the language for editor plugins (-introspect), generated from
+// the instruction table and the lexer keywords so it can not drift from the implementation
+
+// Bumped on incompatible changes of the output
+const introspectSchema = 1
+
+type typeDoc struct {
+	Name string `json:"name"`
+	Size int    `json:"size"`
+}
+
+type introspection struct {
+	Schema  int               `json:"schema"`
+	Version string            `json:"version"`
+	Fields  map[string]string `json:"fields"` // Documentation of the other fields
+
+	Insts      []instDoc `json:"instructions"`
+	Directives []string  `json:"directives"`
+	Sections   []string  `json:"sections"`
+	Functions  []string  `json:"functions"`
+	Operators  []string  `json:"operators"`
+	Types      []typeDoc `json:"types"`
+	Qualifiers []string  `json:"qualifiers"`
+	Comments   []string  `json:"comments"`
+	Aliases    []string  `json:"aliases"`
+	Names      string    `json:"names"`
+}
+
+func introspect() {
+	info := introspection{
+		Schema:  introspectSchema,
+		Version: fmt.Sprintf("%v.%v.%v", config.VersionMajor, config.VersionMinor,
+		                     config.VersionPatch),
+		Fields: map[string]string{
+			"schema":       "Version of this format, bumped on incompatible changes",
+			"version":      "Version of anasm",
+			"instructions": "Like -doc -json, 'operand' is empty for instructions without an " +
+			                "argument, 'since' is the minimum AVM version",
+			"directives":   "Statement keywords",
+			"sections":     "Section directives, written like labels",
+			"functions":    "Functions usable in constant expressions, as (name args...)",
+			"operators":    "Operators usable in constant expressions, as (op args...)",
+			"types":        "Element types of let and dat lists with their size in bytes",
+			"qualifiers":   "Words before integer element types that set their range, they " +
+			                "are not keywords",
+			"comments":     "Default line comment introducers",
+			"aliases":      "Alternative names of instructions",
+			"names":        "Regular expression of the names of labels, variables, macros " +
+			                "and namespaces",
+		},
+
+		Insts:      instDocs(),
+		Sections:   []string{".data", ".text"},
+		Comments:   lexer.DefaultComments,
+		Qualifiers: []string{node.Signed, node.Unsigned},
+		Aliases:    []string{},
+		Names:      lexer.NamePattern,
+	}
+
+	for keyword, type_ := range lexer.Keywords {
+		switch {
+		case type_.IsType():
+			agenType, _ := parser.TypeOf(type_)
+			size        := compiler.SizeOfType(agenType)
+
+			info.Types = append(info.Types, typeDoc{Name: keyword, Size: int(size)})
+
+		case type_ == token.SizeOf || type_ == token.Bits || type_ == token.Pad ||
+		     type_ == token.StrLen || type_ == token.StrCat || type_ == token.Defined:
+			info.Functions = append(info.Functions, keyword)
+
+		case !unicode.IsLetter(rune(keyword[0])):
+			info.Operators = append(info.Operators, keyword)
+
+		default: info.Directives = append(info.Directives, keyword)
+		}
+	}
+
+	sort.Strings(info.Directives)
+	sort.Strings(info.Functions)
+	sort.Strings(info.Operators)
+	sort.Slice(info.Types, func(a, b int) bool {
+		return info.Types[a].Name < info.Types[b].Name
+	})
+
+	printJSON(info)
+}
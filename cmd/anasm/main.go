@@ -4,26 +4,134 @@ import (
 	"os"
 	"fmt"
 	"flag"
+	"bytes"
+	"io"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/avm-collection/goerror"
+	"github.com/avm-collection/agen"
 
 	"github.com/avm-collection/anasm/internal/config"
 	"github.com/avm-collection/anasm/internal/token"
 	"github.com/avm-collection/anasm/internal/compiler"
 	"github.com/avm-collection/anasm/internal/disasm"
+	"github.com/avm-collection/anasm/internal/executable"
+	"github.com/avm-collection/anasm/internal/lsp"
+	"github.com/avm-collection/anasm/internal/lexer"
+	"github.com/avm-collection/anasm/internal/parser"
 )
 
 var (
-	out  = flag.String("o",        "",      "Path of the output binary")
-	v    = flag.Bool("version",    false,   "Show the version")
-	e    = flag.Bool("executable", true,    "Make the output file executable")
-	d    = flag.Bool("disasm",     false,   "Run the disassembler")
-	noW  = flag.Bool("noW",        false,   "Dont show warnings")
-	maxE = flag.Int("maxE",        8,       "Max compiler errors count")
-
-	args []string
+	out   = flag.String("o",              "",      "Path of the output binary (default: next to " +
+	                                               "the input, without the extension)")
+	v     = flag.Bool("version",          false,   "Show the version")
+	e     = flag.Bool("executable",       true,    "Make the output file executable")
+	force = flag.Bool("force",            false,   "Write the output even over an input file")
+	chk   = flag.Bool("check",            false,   "Only assemble, without writing the executable")
+	dump  = flag.Bool("dump",             false,   "Print an annotated hexdump of an executable")
+	strs  = flag.Bool("strings",          false,   "Print the printable texts in an executable, " +
+	                                               "with the variables from -import")
+	strM  = flag.Int("strings-min",       4,       "Min length of texts found by -strings")
+	strP  = flag.Bool("strings-program",  false,   "Also search the instruction arguments with " +
+	                                               "-strings")
+	diff  = flag.Bool("diff",             false,   "Compare two executables")
+	sum   = flag.Bool("summary",          false,   "Only print the count of differences with -diff")
+	ls    = flag.Bool("lsp",              false,   "Run the language server on stdin and stdout")
+	rpl   = flag.Bool("repl",             false,   "Start an interactive session showing the " +
+	                                               "bytes every typed line encodes to")
+	d     = flag.Bool("disasm",           false,   "Run the disassembler")
+	noW   = flag.Bool("noW",              false,   "Dont show warnings")
+	wErr  = flag.Bool("Werror",           false,   "Turn compiler warnings into errors")
+	wAll  = flag.Bool("Wall",             false,   "Enable all optional warnings (-stack-check, " +
+	                                               "-Wmixed-data, -Wfallthrough)")
+	wFall = flag.Bool("Wfallthrough",     false,   "Warn about code running off the end of a " +
+	                                               "label into the next one")
+	wMix  = flag.Bool("Wmixed-data",      false,   "Warn about strings among numbers in lets of " +
+	                                               "wider elements")
+	strct = flag.Bool("strict",           false,   "Enable every check and turn warnings into " +
+	                                               "errors (-explicit-types, -Wall, -Werror)")
+	maxE  = flag.Int("maxE",              8,       "Max compiler errors count")
+	gc    = flag.Bool("gc-code",          false,   "Remove unreachable code and unused data")
+	dMem  = flag.Bool("dump-memory",      false,   "Print a hexdump of the memory with the " +
+	                                               "variables after assembling")
+	dMemA = flag.Bool("dump-memory-all",  false,   "Print all of the memory with -dump-memory, " +
+	                                               "not only the start")
+	cfg   = flag.String("cfg",            "",      "Write the control flow graph in the DOT format")
+	mp    = flag.String("map",            "",      "Write the addresses of variables and labels " +
+	                                               "in a memory map file")
+	pre   = flag.Bool("E",                false,   "Print the program with includes expanded")
+	expl  = flag.Bool("explain",          false,   "Print the program with the instructions, " +
+	                                               "addresses and labels of every line")
+	xref  = flag.Bool("xref",             false,   "Print where symbols are defined and used")
+	szL   = flag.Bool("size-per-label",   false,   "Print the bytes of code and variables after " +
+	                                               "each label")
+	szF   = flag.Bool("size-per-file",    false,   "Print the bytes of code and variables in " +
+	                                               "each file")
+	merge = flag.Bool("merge-strings",    false,   "Let identical variables share memory (only " +
+	                                               "if they are never written to)")
+	syms  = flag.String("symbols",        "",      "Write the addresses of labels and variables " +
+	                                               "to a file")
+	imp   = flag.String("import",         "",      "Use the symbols of another program from a " +
+	                                               "file written with -symbols")
+	defs  = flag.String("defsym",         "",      "Comma separated NAME=VALUE symbols, like " +
+	                                               "addresses that differ per target")
+	lf    = flag.Bool("incstr-lf",        false,   "Convert CRLF line endings of incstr files")
+	stk   = flag.Bool("stack-check",      false,   "Warn about possible stack underflows")
+	incD  = flag.Int("include-depth",     64,      "Max nesting depth of included files")
+	maxM  = flag.Uint64("max-memory",     1 << 24, "Max memory size in bytes")
+	entry = flag.String("entry",          "",      "Label of the entry point (default " +
+	                                               "\"entry\")")
+	entA  = flag.String("entry-addr",     "",      "Instruction index of the entry point, " +
+	                                               "instead of a label")
+	noEnt = flag.Bool("no-entry",         false,   "Assemble a fragment without an entry point")
+	bld   = flag.Bool("build",            false,   "Assemble every file argument, and the files " +
+	                                               "in directory arguments (DIR/... for the " +
+	                                               "ones below too), into the -o directory")
+	tm    = flag.Bool("time",             false,   "Print the time and memory spent in each phase")
+	dOnly = flag.Bool("data-only",        false,   "Allow programs without instructions")
+	instN = flag.Bool("allow-inst-names", false,   "Allow names of instructions as identifiers")
+	uni   = flag.Bool("unicode-names",    false,   "Allow Unicode letters and digits in names")
+	expT  = flag.Bool("explicit-types",   false,   "Require the element type of let instead of " +
+	                                               "inferring it")
+	argsL = flag.Bool("multiline-args",   false,   "Take instruction arguments from the next " +
+	                                               "lines too (old behavior)")
+	mt    = flag.Bool("meta",             false,   "Write metadata into the executable")
+	stamp = flag.Bool("stamp",            false,   "Write the anasm version and the options " +
+	                                               "into the executable")
+	stmID = flag.String("stamp-id",       "",      "Build ID to write with -stamp")
+	stmT  = flag.String("stamp-time",     "",      "Build time to write with -stamp (none by " +
+	                                               "default, so builds are reproducible)")
+	align = flag.Int("align-program",     0,       "Align the program section to N bytes (power " +
+	                                               "of two), the executable then only loads with " +
+	                                               "loaders reading its ALGN section")
+	padE  = flag.Int("pad-end",           0,       "Append N -pad-inst instructions after the " +
+	                                               "program, so execution stops at its end")
+	padI  = flag.String("pad-inst",       "",      "Instruction of -pad-end, taking no " +
+	                                               "argument (default \"hlt\")")
+	strip = flag.Bool("strip",            false,   "Remove optional sections from an executable")
+	cmts  = flag.String("comments",       "",      "Comma separated line comment introducers " +
+	                                               "(default \"#,;\")")
+	dc    = flag.Bool("doc",              false,   "Show the documentation of an instruction, or " +
+	                                               "list all of them")
+	js    = flag.Bool("json",             false,   "Print -doc and the size reports as JSON")
+	intro = flag.Bool("introspect",       false,   "Print a JSON description of the language for " +
+	                                               "editor plugins")
+	schm  = flag.Bool("schema",           false,   "Print a JSON description of the executable " +
+	                                               "format and the opcodes for the VM")
+	ins   = flag.String("insts",          "",      "JSON file with additional instructions")
+	cache = flag.String("cache",          "",      "Reuse the executables of unchanged builds " +
+	                                               "from a cache directory")
+	rt    = flag.Bool("roundtrip",        false,   "Assemble, disassemble and assemble again and " +
+	                                               "compare the binaries (for development)")
+
+	args     []string
+	comments  []string          // Parsed -comments
+	imports   []compiler.Import // Read from -import
+	defsyms   []compiler.Defsym // Parsed -defsym
+	entryAddr *agen.Word        // Parsed -entry-addr
 )
 
 func printError(format string, args... interface{}) {
@@ -37,6 +145,7 @@ func printTry(arg string) {
 func usage() {
 	fmt.Printf("Github: %v\n", config.GithubLink)
 	fmt.Printf("Usage: %v [FILE] [OPTIONS]\n", os.Args[0])
+	fmt.Println("FILE can be '-' to read the standard input")
 	fmt.Println("Options:")
 
 	flag.PrintDefaults()
@@ -49,6 +158,9 @@ func version() {
 
 func init() {
 	token.AllTokensCoveredTest()
+	if err := compiler.ValidateInstTable(); err != nil {
+		panic(err)
+	}
 
 	flag.Usage = usage
 
@@ -65,7 +177,8 @@ func init() {
 			continue
 		}
 
-		if flag.Args()[i][0] != '-' {
+		// A lone '-' is the standard input
+		if flag.Args()[i][0] != '-' || flag.Args()[i] == "-" {
 			continue
 		}
 
@@ -74,55 +187,546 @@ func init() {
 
 		break
 	}
+
+	// -Wall turns on the optional warnings
+	if *wAll {
+		*stk   = true
+		*wMix  = true
+		*wFall = true
+	}
+
+	if len(*stmID) > 0 || len(*stmT) > 0 {
+		*stamp = true
+	}
+}
+
+// Name of the input read from the standard input, given as '-'
+const stdinPath = "<stdin>"
+
+// Bytes printed by -dump-memory without -dump-memory-all
+const dumpMemoryMax = 4096
+
+// Output file name for when there is no -o, 'foo.anasm' becomes 'foo', or 'foo.avm' if the output
+// is not made executable
+func defaultOut(path string) string {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if !*e {
+		return name + ".avm"
+	} else if len(filepath.Ext(path)) == 0 {
+		return name + ".out"
+	}
+
+	return name
+}
+
+func sameFile(a, b string) bool {
+	aInfo, aErr := os.Stat(a)
+	bInfo, bErr := os.Stat(b)
+	return aErr == nil && bErr == nil && os.SameFile(aInfo, bInfo)
 }
 
-func assemble(input, path string) {
+// Sets the output path if there is none, returns false if it is not usable
+func outputPath(path string) bool {
 	if len(*out) == 0 {
-		if len(filepath.Ext(path)) == 0 {
-			*out = path + ".out"
-		} else {
-			*out = strings.TrimSuffix(path, filepath.Ext(path))
+		if path == stdinPath {
+			printError("No output file for the standard input, use -o")
+
+			return false
 		}
 
-		*out = filepath.Base(*out)
+		*out = filepath.Join(filepath.Dir(path), defaultOut(path))
+	} else if sameFile(*out, path) && !*force {
+		printError("Output file '%v' is the input file, use -force to overwrite it", *out)
+
+		return false
 	}
 
-	c := compiler.New(input, path)
-	if ok := c.Compile(); ok {
-		if err := c.CreateExec(*out, *e); err != nil {
-			printError(err.Error())
+	return true
+}
+
+func assemble(input, path string) bool {
+	if *chk {
+		return check(input, path)
+	} else if !outputPath(path) {
+		return false
+	} else if len(*cache) > 0 {
+		return assembleCached(input, path, options(path))
+	}
+
+	c := compiler.New(input, path, options(path))
+	if *tm {
+		defer func() {printStats(c.Stats())}()
+	}
+
+	if ok := c.Compile(); !ok || !outputNotRead(*out, c.Files()) {
+		return false
+	}
+
+	if err := c.CreateExec(*out, *e); err != nil {
+		printError(err.Error())
+
+		return false
+	}
+
+	if *dMem {
+		dumpMemory(c)
+	}
+
+	if *xref {
+		printXRef(c)
+	}
+
+	if *szL {
+		printSizes(c.SizePerLabel(), "label")
+	}
+
+	if *szF {
+		printSizes(c.SizePerFile(), "file")
+	}
+
+	if len(*syms) > 0 {
+		writeFile(*syms, c.WriteSymbols)
+	}
+
+	if len(*cfg) > 0 {
+		writeFile(*cfg, c.WriteCFG)
+	}
+
+	if len(*mp) > 0 {
+		writeFile(*mp, c.WriteMap)
+	}
+
+	return true
+}
+
+// Assembles without writing the executable (-check)
+func check(input, path string) bool {
+	c := compiler.New(input, path, options(path))
+	if *tm {
+		defer func() {printStats(c.Stats())}()
+	}
+
+	if ok := c.Compile(); !ok {
+		return false
+	}
+
+	if *dMem {
+		dumpMemory(c)
+	}
+
+	return true
+}
+
+// The memory is read back from an executable, the compiler does not keep it
+func dumpMemory(c *compiler.Compiler) {
+	data, err := c.Exec(false)
+	if err != nil {
+		printError(err.Error())
+
+		return
+	}
+
+	exe, err := executable.Parse(data)
+	if err != nil {
+		printError(err.Error())
+
+		return
+	}
+
+	max := dumpMemoryMax
+	if *dMemA {
+		max = 0
+	}
+
+	disasm.DumpMemory(os.Stderr, exe.Memory, c.Regions(), max)
+}
+
+// Options of the compiler from the command line
+func options(path string) compiler.Options {
+	opts := compiler.Options{
+		GCCode: *gc, DataOnly: *dOnly, AllowInstNames: *instN, Metadata: *mt,
+		AlignProgram: *align, WarningsAsErrors: *wErr, IncludeDirs: config.LibDirs(),
+		Comments: comments, StackCheck: *stk, MergeStrings: *merge, Imports: imports,
+		NormalizeNewlines: *lf, MaxIncludeDepth: *incD, Time: *tm, Entry: *entry,
+		EntryAddr: entryAddr, NoEntry: *noEnt, MaxMemory: agen.Word(*maxM), UnicodeNames: *uni,
+		ExplicitTypes: *expT, MixedData: *wMix, ArgsAcrossLines: *argsL, Stamp: stampOf(path),
+		Defsyms: defsyms, Fallthrough: *wFall, PadEnd: *padE, PadInst: *padI,
+	}
+
+	if *strct {
+		return opts.Strict()
+	}
+
+	return opts
+}
+
+// Builds with -cache only write the executable, nothing else needs the compiled program
+func assembleCached(input, path string, opts compiler.Options) bool {
+	build, ok := compiler.CompileCached(*cache, input, path, *e, opts)
+	if *tm {
+		defer func() {
+			if build != nil && !build.Hit {
+				printStats(build.Stats)
+			}
+
+			counts := compiler.CacheCounts()
+			fmt.Fprintf(os.Stderr, "cache    %v hits, %v misses\n", counts.Hits, counts.Misses)
+		}()
+	}
+
+	if !ok || !outputNotRead(*out, build.Files) {
+		return false
+	}
+
+	if err := os.WriteFile(*out, build.Exe, build.Mode); err != nil {
+		printError("Could not write file '%v'", *out)
+
+		return false
+	}
+
+	return true
+}
+
+// Included files, and the files of options, only become known after compiling
+func outputNotRead(out string, files []string) bool {
+	for _, file := range append(files, *imp, *ins) {
+		if len(file) > 0 && sameFile(out, file) && !*force {
+			printError("Output file '%v' is '%v', which was read for this build, use -force " +
+			           "to overwrite it", out, file)
+
+			return false
+		}
+	}
+
+	return true
+}
+
+// Build information for -stamp, only from the invocation, so identical ones give identical
+// executables
+func stampOf(path string) []executable.Meta {
+	if !*stamp {
+		return nil
+	}
+
+	options := []string{}
+	flag.Visit(func(f *flag.Flag) {
+		options = append(options, fmt.Sprintf("-%v=%v", f.Name, f.Value))
+	})
+
+	entries := []executable.Meta{
+		{Key: "tool", Value: fmt.Sprintf("%v %v.%v.%v", config.AppName, config.VersionMajor,
+		                                 config.VersionMinor, config.VersionPatch)},
+		{Key: "input",   Value: path},
+		{Key: "options", Value: strings.Join(options, " ")},
+	}
+
+	if len(*stmID) > 0 {
+		entries = append(entries, executable.Meta{Key: "id", Value: *stmID})
+	}
+
+	if len(*stmT) > 0 {
+		entries = append(entries, executable.Meta{Key: "time", Value: *stmT})
+	}
+
+	return entries
+}
+
+// Symbols of -defsym, with their position in the option for diagnostics. Values are integer or
+// character literals of any base, a ',' character has to be written as its number.
+func parseDefsyms(list string) ([]compiler.Defsym, error) {
+	defsyms := []compiler.Defsym{}
+	col     := 1
+	for _, def := range strings.Split(list, ",") {
+		name, value, ok := strings.Cut(def, "=")
+		if !ok {
+			return nil, fmt.Errorf("Expected NAME=VALUE in -defsym, got '%v'", def)
+		}
+
+		at        := col + strings.Index(def, strings.TrimSpace(name))
+		name, err := lexer.NormalizeName(name, *uni)
+		if err != nil {
+			return nil, err
+		}
+
+		parsed, err := parser.ParseInt(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("Value of symbol '%v': %v", name, err.Error())
+		}
+
+		defsyms = append(defsyms, compiler.Defsym{
+			Name: name, Value: agen.Word(parsed),
+			Where: token.Where{Row: 1, Col: at, Len: len(name), Path: "-defsym", Line: list},
+		})
+
+		col += len(def) + 1
+	}
+
+	return defsyms, nil
+}
+
+func writeFile(path string, write func(io.Writer) error) {
+	f, err := os.Create(path)
+	if err != nil {
+		printError("Could not write file '%v'", path)
+
+		return
+	}
+	defer f.Close()
+
+	if err := write(f); err != nil {
+		printError(err.Error())
+	}
+}
+
+func printStats(stats compiler.Stats) {
+	phases := []struct {
+		name  string
+		phase compiler.Phase
+	}{
+		{"parse", stats.Parse}, {"compile", stats.Compile}, {"write", stats.Write},
+		{"total", stats.Total()},
+	}
+
+	fmt.Fprintf(os.Stderr, "%-8v %12v %14v\n", "phase", "time", "allocated")
+	for _, p := range phases {
+		fmt.Fprintf(os.Stderr, "%-8v %12v %10.1f KiB\n", p.name,
+		            p.phase.Time.Round(time.Microsecond), float64(p.phase.Alloc) / 1024)
+	}
+}
+
+type sizeJSON struct {
+	Name    string    `json:"name"`
+	Program agen.Word `json:"program"`
+	Memory  agen.Word `json:"memory"`
+	Total   agen.Word `json:"total"`
+}
+
+func printSizes(sizes []compiler.Size, by string) {
+	if *js {
+		list := []sizeJSON{}
+		for _, size := range sizes {
+			list = append(list, sizeJSON{Name: size.Name, Program: size.Program,
+			                             Memory: size.Memory, Total: size.Total()})
+		}
+
+		printJSON(list)
+		return
+	}
+
+	fmt.Printf("%10v %10v %10v  %v\n", "program", "memory", "total", by)
+	for _, size := range sizes {
+		fmt.Printf("%10v %10v %10v  %v\n", size.Program, size.Memory, size.Total(), size.Name)
+	}
+}
+
+func printXRef(c *compiler.Compiler) {
+	for _, symbol := range c.Symbols() {
+		fmt.Printf("%v %v, defined at %v\n", symbol.Kind, symbol.Name, symbol.Def)
+		if len(symbol.Refs) == 0 {
+			fmt.Println("    not referenced")
+		}
+
+		for _, ref := range symbol.Refs {
+			fmt.Printf("    %v\n", ref)
+		}
+	}
+}
+
+func assembleBytes(input, path string) []byte {
+	c := compiler.New(input, path, compiler.Options{
+		Metadata: *mt, AlignProgram: *align, IncludeDirs: config.LibDirs(),
+		Comments: comments, NoEntry: *noEnt, Defsyms: defsyms,
+	})
+	if ok := c.Compile(); !ok {
+		os.Exit(1)
+	}
+
+	data, err := c.Exec(false)
+	if err != nil {
+		printError(err.Error())
+		os.Exit(1)
+	}
+
+	return data
+}
+
+func roundtrip(input, path string) {
+	dir, err := os.MkdirTemp("", config.AppName)
+	if err != nil {
+		printError(err.Error())
+		os.Exit(1)
+	}
+	defer os.RemoveAll(dir)
+
+	first := assembleBytes(input, path)
+
+	src := filepath.Join(dir, "first.anasm")
+	if ok := disasm.New(first, path).Disassemble(src); !ok {
+		os.Exit(1)
+	}
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		printError("Could not open file '%v'", src)
+		os.Exit(1)
+	}
+
+	second := assembleBytes(string(data), src)
+	if !bytes.Equal(first, second) {
+		i := 0
+		for i < len(first) && i < len(second) && first[i] == second[i] {
+			i ++
 		}
+
+		printError("Round trip of '%v' differs at byte %v (%v and %v bytes long)",
+		           path, i, len(first), len(second))
+		os.Exit(1)
 	}
+
+	fmt.Printf("Round trip of '%v' OK (%v bytes)\n", path, len(first))
 }
 
 func disassemble(input []byte, path string) {
-	if len(*out) == 0 {
+	if len(*out) == 0 && path == stdinPath {
+		printError("No output file for the standard input, use -o")
+
+		os.Exit(1)
+	} else if len(*out) == 0 {
 		if filepath.Ext(path) == ".anasm" {
 			*out = path + ".out"
 		} else {
 			*out = path + ".anasm"
 		}
-
-		*out = filepath.Base(*out)
 	}
 
 	d := disasm.New(input, path)
 	d.Disassemble(*out)
 }
 
+func stripExec(input []byte, path string) {
+	if len(*out) == 0 {
+		*out = path
+	}
+
+	stripped, err := executable.Strip(input)
+	if err != nil {
+		printError("'%v': %v", path, err.Error())
+
+		os.Exit(1)
+	}
+
+	removed := len(input) - len(stripped)
+	if removed == 0 && *out == path {
+		fmt.Printf("'%v' has no sections to remove\n", path)
+
+		return
+	}
+
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode()
+	}
+
+	// Write into a temporary file next to the output and rename it, so the output is never left
+	// half written
+	f, err := os.CreateTemp(filepath.Dir(*out), filepath.Base(*out) + ".*")
+	if err != nil {
+		printError(err.Error())
+
+		os.Exit(1)
+	}
+
+	_, err = f.Write(stripped)
+	if err == nil {
+		err = f.Chmod(mode)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), *out)
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+		printError(err.Error())
+
+		os.Exit(1)
+	}
+
+	fmt.Printf("Removed %v bytes from '%v'\n", removed, path)
+}
+
+func diffExecs() {
+	if len(args) != 2 {
+		printError("Expected 2 executables to compare")
+		printTry("-h")
+
+		os.Exit(1)
+	}
+
+	var inputs [2][]byte
+	for i, path := range args {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			printError("Could not open file '%v'", path)
+
+			os.Exit(1)
+		}
+
+		inputs[i] = data
+	}
+
+	differ, err := disasm.Diff(os.Stdout, inputs[0], inputs[1], args[0], args[1], *sum)
+	if err != nil {
+		printError(err.Error())
+
+		os.Exit(1)
+	} else if differ {
+		os.Exit(1)
+	}
+}
+
 func main() {
 	if *v {
 		version()
 
 		return
+	} else if *ls {
+		os.Exit(lsp.New(os.Stdin, os.Stdout).Serve())
 	}
 
-	if len(args) == 0 {
+	if *dc || *intro || *schm {
+		// Instructions loaded from a file are documented too
+		if len(*ins) > 0 {
+			if err := compiler.LoadInsts(*ins); err != nil {
+				printError(err.Error())
+
+				os.Exit(1)
+			}
+		}
+
+		if *intro {
+			introspect()
+		} else if *schm {
+			schema()
+		} else {
+			doc()
+		}
+
+		return
+	}
+
+	if len(args) == 0 && !*rpl {
 		printError("No input file")
 		printTry("-h")
 
 		os.Exit(1)
-	} else if len(args) > 1 {
+	} else if *diff {
+		diffExecs()
+
+		return
+	} else if len(args) > 1 && !*bld {
 		printError("Unexpected argument '%v'", args[1])
 		printTry("-h")
 
@@ -131,8 +735,152 @@ func main() {
 
 	goerror.NoWarnings(!*noW)
 
-	path      := args[0]
-	data, err := os.ReadFile(path)
+	if len(*ins) > 0 {
+		if err := compiler.LoadInsts(*ins); err != nil {
+			printError(err.Error())
+
+			os.Exit(1)
+		}
+	}
+
+	if len(*imp) > 0 {
+		var err error
+		if imports, err = compiler.ReadSymbols(*imp); err != nil {
+			printError(err.Error())
+
+			os.Exit(1)
+		}
+	}
+
+	if len(*cmts) > 0 {
+		for _, comment := range strings.Split(*cmts, ",") {
+			if err := lexer.CheckComment(comment); err != nil {
+				printError(err.Error())
+
+				os.Exit(1)
+			}
+
+			comments = append(comments, comment)
+		}
+	}
+
+	if len(*defs) > 0 {
+		var err error
+		if defsyms, err = parseDefsyms(*defs); err != nil {
+			printError(err.Error())
+
+			os.Exit(1)
+		}
+	}
+
+	if len(*cache) > 0 && (*xref || *szL || *szF || len(*syms) > 0 || len(*cfg) > 0 ||
+	                       len(*mp) > 0 || *dMem) {
+		printError("-xref, -size-per-label, -size-per-file, -symbols, -cfg, -map and " +
+		           "-dump-memory need the compiled program, they can not be used with -cache")
+		printTry("-h")
+
+		os.Exit(1)
+	}
+
+	if *strct && (*argsL || *instN) {
+		printError("-strict can not be used with -multiline-args or -allow-inst-names")
+		printTry("-h")
+
+		os.Exit(1)
+	}
+
+	if *noEnt && (len(*entry) > 0 || len(*entA) > 0) {
+		printError("-no-entry can not be used with -entry or -entry-addr")
+		printTry("-h")
+
+		os.Exit(1)
+	}
+
+	if len(*entry) > 0 {
+		name, err := lexer.NormalizeName(*entry, *uni)
+		if err != nil {
+			printError("Entry point: %v", err.Error())
+
+			os.Exit(1)
+		}
+
+		*entry = name
+	}
+
+	if len(*entA) > 0 {
+		if len(*entry) > 0 {
+			printError("-entry and -entry-addr can not be used together")
+			printTry("-h")
+
+			os.Exit(1)
+		}
+
+		addr, err := strconv.ParseUint(*entA, 0, 64)
+		if err != nil {
+			printError("Invalid entry point address '%v'", *entA)
+
+			os.Exit(1)
+		}
+
+		entryAddr  = new(agen.Word)
+		*entryAddr = agen.Word(addr)
+	}
+
+	if *strM < 1 {
+		printError("Min length of texts must be at least 1")
+
+		os.Exit(1)
+	}
+
+	if *maxM < 1 {
+		printError("Max memory size must be at least 1")
+
+		os.Exit(1)
+	}
+
+	if *incD < 1 {
+		printError("Max include depth must be at least 1")
+
+		os.Exit(1)
+	}
+
+	if *padE < 0 {
+		printError("Padding can not be negative")
+
+		os.Exit(1)
+	}
+
+	if *align != 0 {
+		if err := executable.CheckAlign(uint64(*align)); err != nil {
+			printError(err.Error())
+
+			os.Exit(1)
+		}
+	}
+
+	if *bld {
+		if !build() {
+			os.Exit(1)
+		}
+
+		return
+	} else if *rpl {
+		runREPL()
+
+		return
+	}
+
+	path := args[0]
+
+	var data []byte
+	var err  error
+	if path == "-" {
+		path      = stdinPath
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+
 	if err != nil {
 		printError("Could not open file '%v'", path)
 		printTry("-h")
@@ -140,9 +888,32 @@ func main() {
 		os.Exit(1)
 	}
 
-	if *d {
+	if *dump {
+		if err := disasm.Dump(os.Stdout, data, path); err != nil {
+			printError(err.Error())
+
+			os.Exit(1)
+		}
+	} else if *strs {
+		opts := disasm.StringsOptions{MinLen: *strM, Program: *strP, Symbols: imports}
+		if err := disasm.Strings(os.Stdout, data, opts); err != nil {
+			printError(err.Error())
+
+			os.Exit(1)
+		}
+	} else if *strip {
+		stripExec(data, path)
+	} else if *d {
 		disassemble(data, path)
-	} else {
-		assemble(string(data), path)
+	} else if *rt {
+		roundtrip(string(data), path)
+	} else if *pre {
+		preprocess(string(data), path)
+	} else if *expl {
+		if !explain(string(data), path) {
+			os.Exit(1)
+		}
+	} else if !assemble(string(data), path) {
+		os.Exit(1)
 	}
 }
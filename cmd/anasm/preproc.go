@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/avm-collection/anasm/internal/config"
+	"github.com/avm-collection/anasm/internal/lexer"
+	"github.com/avm-collection/anasm/internal/node"
+	"github.com/avm-collection/anasm/internal/parser"
+)
+
+// Preprocessor output (-E). The program is only parsed, with includes expanded, and printed back
+// as source. Line markers are comments, so the output can be assembled again, and they point to
+// the original file and line whenever the output stops following it.
+
+func preprocess(input, path string) {
+	p := parser.New(input, path)
+	p.AllowInstNames = *instN
+	p.IncludeDirs    = config.LibDirs()
+	p.Comments       = comments
+	p.UnicodeNames   = *uni
+	p.ExplicitTypes  = *expT || *strct
+
+	p.ArgsAcrossLines = *argsL
+
+	p.NormalizeNewlines = *lf
+	p.MaxIncludeDepth   = *incD
+
+	program := p.Parse()
+	p.Diags.Flush()
+
+	comment := lexer.DefaultComments[0]
+	if len(comments) > 0 {
+		comment = comments[0]
+	}
+
+	// Statements sharing a line stay on one, so labels stay with the instruction after them
+	file, row := "", 0
+	open      := false // If a line was started
+	for _, s := range program.List {
+		// Already reported by the parser
+		if node.IsNil(s) {
+			continue
+		}
+
+		where := s.GetToken().Where
+		if open && where.Path == file && where.Row == row {
+			fmt.Printf(" %v", node.Source(s))
+			continue
+		} else if open {
+			fmt.Println()
+		}
+
+		if where.Path != file || where.Row != row + 1 {
+			fmt.Printf("%v line %v %v\n", comment, where.Row, node.Quote(where.Path))
+		}
+
+		file, row = where.Path, where.Row
+
+		switch s.(type) {
+		case *node.Inst, *node.Data: fmt.Printf("\t%v", node.Source(s))
+
+		default: fmt.Print(node.Source(s))
+		}
+
+		open = true
+	}
+
+	if open {
+		fmt.Println()
+	}
+
+	if p.Diags.Happened() {
+		os.Exit(1)
+	}
+}
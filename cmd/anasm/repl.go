@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/avm-collection/agen"
+
+	"github.com/avm-collection/anasm/internal/compiler"
+	"github.com/avm-collection/anasm/internal/diag"
+	"github.com/avm-collection/anasm/internal/disasm"
+	"github.com/avm-collection/anasm/internal/executable"
+)
+
+// Interactive session (-repl), to see what lines encode to. Every line typed is added to the
+// program so far, which is assembled again by the same compiler, and the instructions and memory
+// bytes the line added are shown. Lines that fail to assemble are dropped. Warnings are not
+// shown, they would be repeated for every line after them.
+
+const replHelp = `Lines are added to the program and their bytes shown, lines with errors are
+dropped
+:symbols     List the labels, variables and macros defined so far
+:save FILE   Write the lines so far into a file
+:reset       Start over with an empty program
+:help        Show this
+:quit        End the session (or end the input)`
+
+const replBytesPerLine = 16
+
+type repl struct {
+	c *compiler.Compiler // Reset for every assembly
+
+	lines []string
+	exe   *executable.Executable // Of the lines so far, nil if there are none
+}
+
+func runREPL() {
+	// The lines are a fragment, without an entry point or padding
+	opts := options(stdinPath)
+	opts.Entry, opts.EntryAddr, opts.NoEntry = "", nil, true
+	opts.DataOnly, opts.PadEnd               = true, 0
+
+	r := &repl{c: compiler.New("", stdinPath, opts)}
+
+	fmt.Println("Type ':help' for the commands")
+
+	in := bufio.NewScanner(os.Stdin)
+	for fmt.Print("> "); in.Scan(); fmt.Print("> ") {
+		line := strings.TrimSpace(in.Text())
+		if len(line) == 0 {
+			continue
+		} else if !strings.HasPrefix(line, ":") {
+			r.add(in.Text())
+		} else if !r.command(line) {
+			break
+		}
+	}
+
+	fmt.Println()
+}
+
+// Runs a command, returns false if the session ends
+func (r *repl) command(line string) bool {
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case ":quit": return false
+	case ":help": fmt.Println(replHelp)
+
+	case ":reset":
+		r.lines = nil
+		r.exe   = nil
+
+	case ":symbols":
+		// Only the definitions, without the references
+		if r.assemble(r.lines) {
+			for _, symbol := range r.c.Symbols() {
+				fmt.Printf("%v %v, defined at %v\n", symbol.Kind, symbol.Name, symbol.Def)
+			}
+		}
+
+	case ":save":
+		if len(fields) != 2 {
+			printError("Expected ':save FILE'")
+			break
+		}
+
+		src := strings.Join(r.lines, "\n") + "\n"
+		if err := os.WriteFile(fields[1], []byte(src), 0644); err != nil {
+			printError("Could not write file '%v'", fields[1])
+		}
+
+	default: printError("Unknown command '%v', see ':help'", fields[0])
+	}
+
+	return true
+}
+
+func (r *repl) add(line string) {
+	lines := append(append([]string{}, r.lines...), line)
+	if !r.assemble(lines) {
+		return
+	}
+
+	data, err := r.c.Exec(false)
+	if err != nil {
+		printError(err.Error())
+		return
+	}
+
+	exe, err := executable.Parse(data)
+	if err != nil {
+		printError(err.Error())
+		return
+	}
+
+	var memory, insts agen.Word
+	if r.exe != nil {
+		memory, insts = agen.Word(len(r.exe.Memory)), r.exe.InstCount()
+	}
+
+	if memory == agen.Word(len(exe.Memory)) && insts == exe.InstCount() {
+		fmt.Println("  (no bytes)")
+	}
+
+	for i := memory; i < agen.Word(len(exe.Memory)); i += replBytesPerLine {
+		end := i + replBytesPerLine
+		if end > agen.Word(len(exe.Memory)) {
+			end = agen.Word(len(exe.Memory))
+		}
+
+		fmt.Printf("  memory 0x%08X  % X\n", i, exe.Memory[i:end])
+	}
+
+	for i := insts; i < exe.InstCount(); i ++ {
+		inst    := exe.Inst(i)
+		encoded := exe.Program[int(i) * agen.InstSize:int(i + 1) * agen.InstSize]
+
+		name, hasArg, err := disasm.InstFromOp(inst.Op)
+		if err != nil {
+			name = "???"
+		} else if hasArg {
+			name = fmt.Sprintf("%v 0x%X", name, inst.Arg)
+		}
+
+		fmt.Printf("  inst %6v      % X  %v\n", i, encoded, name)
+	}
+
+	r.lines = lines
+	r.exe   = exe
+}
+
+// Assembles the lines, printing the errors
+func (r *repl) assemble(lines []string) bool {
+	r.c.Reset(strings.Join(lines, "\n") + "\n", stdinPath)
+
+	errors := []diag.Diagnostic{}
+	for _, d := range r.c.Check() {
+		if !d.Warning {
+			errors = append(errors, d)
+		}
+	}
+
+	diag.Print(errors)
+	return len(errors) == 0
+}
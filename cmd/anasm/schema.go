@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/avm-collection/agen"
+
+	"github.com/avm-collection/anasm/internal/config"
+	"github.com/avm-collection/anasm/internal/compiler"
+	"github.com/avm-collection/anasm/internal/executable"
+)
+
+// Encoding of AVM executables as JSON (-schema), for the VM to test its loader and opcode table
+// against. Like -introspect it is generated from the executable package and the instruction
+// table, so it can not drift from what anasm writes.
+
+// Bumped on incompatible changes of the output
+const encodingSchema = 1
+
+// Part of a fixed size record, offsets are from the start of the record
+type fieldDoc struct {
+	Name   string `json:"name"`
+	Offset int    `json:"offset"`
+	Size   int    `json:"size"`
+	Desc   string `json:"desc"`
+}
+
+type opcodeDoc struct {
+	Name    string `json:"name"`
+	Op      byte   `json:"opcode"`
+	Operand string `json:"operand,omitempty"` // Empty if the instruction takes no argument
+	Width   int    `json:"width,omitempty"`
+	Pops    int    `json:"pops"`
+	Pushes  int    `json:"pushes"`
+	Since   string `json:"since,omitempty"`
+}
+
+type sectionDoc struct {
+	Tag  string `json:"tag"`
+	Desc string `json:"desc"`
+}
+
+type encoding struct {
+	Schema  int               `json:"schema"`
+	Version string            `json:"version"`
+	AVM     string            `json:"avm"`
+	Fields  map[string]string `json:"fields"`
+
+	Magic     string `json:"magic"`
+	ByteOrder string `json:"byte_order"`
+	WordSize  int    `json:"word_size"`
+	NoEntry   string `json:"no_entry"`
+
+	Layout     []string   `json:"layout"`
+	Header     []fieldDoc `json:"header"`
+	HeaderSize int        `json:"header_size"`
+	Inst       []fieldDoc `json:"instruction"`
+	InstSize   int        `json:"instruction_size"`
+
+	SectionsMagic string       `json:"sections_magic"`
+	SectionTag    int          `json:"section_tag_size"`
+	SectionsFoot  []fieldDoc   `json:"sections_footer"`
+	Sections      []sectionDoc `json:"sections"`
+
+	Opcodes []opcodeDoc `json:"opcodes"`
+}
+
+// Lays out fields of the given sizes one after another
+func layout(fields []fieldDoc) []fieldDoc {
+	offset := 0
+	for i := range fields {
+		fields[i].Offset = offset
+		offset          += fields[i].Size
+	}
+
+	return fields
+}
+
+func schema() {
+	info := encoding{
+		Schema:  encodingSchema,
+		Version: fmt.Sprintf("%v.%v.%v", config.VersionMajor, config.VersionMinor,
+		                     config.VersionPatch),
+		AVM:     fmt.Sprintf("%v.%v", agen.VersionMajor, agen.VersionMinor),
+		Fields: map[string]string{
+			"schema":          "Version of this format, bumped on incompatible changes",
+			"version":         "Version of anasm",
+			"avm":             "AVM version written into executables, the VM runs executables " +
+			                   "of its major version and an equal or lower minor version",
+			"byte_order":      "Of the words in the header, the arguments and the sections",
+			"no_entry":        "Entry point of fragments, which the VM must refuse to run",
+			"layout":          "Parts of an executable in the order they are in the file",
+			"header":          "Fields of the header, offsets are from the magic",
+			"instruction":     "Fields of an instruction, every instruction has them whether it " +
+			                   "takes an argument or not. Relative code addresses count from " +
+			                   "the next instruction.",
+			"sections_footer": "Fields after the sections, offsets are from the end of the last " +
+			                   "section",
+			"sections":        "Tags of the known sections, loaders skip the ones they do not " +
+			                   "know",
+			"opcodes":         "Instructions by opcode. 'operand' is empty for instructions " +
+			                   "without an argument, 'width' is the bits of the argument used " +
+			                   "(0 for all), 'pops' is -1 if it is not known, 'since' is the " +
+			                   "minimum AVM version",
+		},
+
+		Magic:     executable.Magic,
+		ByteOrder: "big endian",
+		WordSize:  agen.WordSize,
+		NoEntry:   fmt.Sprintf("0x%X", executable.NoEntry),
+
+		Layout: []string{
+			"An optional shebang line, starting with '#' and ending with a new line",
+			"The header",
+			"The memory, 'memory_size' bytes",
+			"Zero bytes up to the alignment of the ALGN section, if there is one",
+			"The program, 'program_size' instructions",
+			"Zero bytes up to the alignment of the ALGN section, if there is one",
+			"Optional sections, each a tag, the size of its data as a word and the data, " +
+			"followed by the sections footer",
+		},
+
+		Header: layout([]fieldDoc{
+			{Name: "magic",        Size: len(executable.Magic), Desc: "The magic bytes"},
+			{Name: "version",      Size: 3,                     Desc: "AVM major, minor and patch"},
+			{Name: "program_size", Size: agen.WordSize,         Desc: "Count of instructions"},
+			{Name: "memory_size",  Size: agen.WordSize,         Desc: "Bytes of the memory"},
+			{Name: "entry_point",  Size: agen.WordSize,         Desc: "Index of the first to run"},
+		}),
+		HeaderSize: executable.HeaderSize,
+
+		Inst: layout([]fieldDoc{
+			{Name: "opcode",   Size: 1,             Desc: "See 'opcodes'"},
+			{Name: "argument", Size: agen.WordSize, Desc: "Zero for instructions without one"},
+		}),
+		InstSize: agen.InstSize,
+
+		SectionsMagic: executable.SectionsMagic,
+		SectionTag:    executable.SectionTagSize,
+		SectionsFoot:  layout([]fieldDoc{
+			{Name: "size",  Size: agen.WordSize,                 Desc: "Bytes of the sections"},
+			{Name: "magic", Size: len(executable.SectionsMagic), Desc: "The sections magic"},
+		}),
+		Sections: []sectionDoc{
+			{Tag: executable.MetaTag,  Desc: "Metadata of 'meta' statements, key/value entries"},
+			{Tag: executable.StampTag, Desc: "Build information, entries like the metadata"},
+			{Tag: executable.AlignTag, Desc: "Alignment of the program section as a word"},
+		},
+	}
+
+	for name, inst := range compiler.Insts {
+		doc := opcodeDoc{Name: name, Op: inst.Op, Pops: inst.Pops, Pushes: inst.Pushes}
+		if inst.HasArg {
+			doc.Operand = inst.Operand.String()
+			doc.Width   = inst.Width
+		}
+
+		if inst.MinMajor != 0 || inst.MinMinor != 0 {
+			doc.Since = fmt.Sprintf("%v.%v", inst.MinMajor, inst.MinMinor)
+		}
+
+		info.Opcodes = append(info.Opcodes, doc)
+	}
+
+	sort.Slice(info.Opcodes, func(a, b int) bool {
+		return info.Opcodes[a].Op < info.Opcodes[b].Op
+	})
+
+	printJSON(info)
+}
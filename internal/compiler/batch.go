@@ -0,0 +1,90 @@
+package compiler
+
+import (
+	"os"
+	"sync"
+	"context"
+	"runtime"
+
+	"github.com/avm-collection/anasm/internal/diag"
+	"github.com/avm-collection/anasm/internal/executable"
+)
+
+// Batch builds, assembling many independent programs in one process. Every program has its own
+// compiler and so its own diagnostics, which are returned instead of printed, so the programs
+// build on all processors at once and their diagnostics can still be shown file by file.
+
+type Input struct {
+	Source, Path string
+
+	Exec  bool              // Write the executable with a shebang line, see Compiler.Exec
+	Stamp []executable.Meta // Used instead of Options.Stamp if not nil
+}
+
+type Result struct {
+	Exe   []byte // Nil if the input failed to assemble or was not assembled
+	Mode  os.FileMode
+	Files []string          // See Compiler.Files
+	Diags []diag.Diagnostic // In the order they print, see Compiler.Check
+	Stats Stats             // See Options.Time
+
+	// The error of the context if it was cancelled before the input was assembled, or why the
+	// executable could not be encoded
+	Err error
+}
+
+// If the input was assembled into an executable
+func (r Result) OK() bool {
+	return r.Exe != nil
+}
+
+// Assembles every input with the options on GOMAXPROCS goroutines, the results are in the order
+// of the inputs. Inputs are not assembled anymore once the context is cancelled, ones already
+// being assembled finish. The hooks of the options are called from several goroutines at once.
+func CompileAll(ctx context.Context, inputs []Input, opts Options) []Result {
+	results := make([]Result, len(inputs))
+	work    := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.GOMAXPROCS(0); i ++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range work {
+				if err := ctx.Err(); err != nil {
+					results[i].Err = err
+					continue
+				}
+
+				results[i] = compileInput(inputs[i], opts)
+			}
+		}()
+	}
+
+	for i := range inputs {
+		work <- i
+	}
+
+	close(work)
+	wg.Wait()
+
+	return results
+}
+
+func compileInput(input Input, opts Options) Result {
+	if input.Stamp != nil {
+		opts.Stamp = input.Stamp
+	}
+
+	c      := New(input.Source, input.Path, opts)
+	result := Result{Diags: c.Check()}
+	if c.diags.Happened() {
+		return result
+	}
+
+	result.Exe, result.Mode, result.Err = c.encode(input.Exec)
+	result.Files = c.Files()
+	result.Stats = c.Stats()
+	return result
+}
@@ -0,0 +1,146 @@
+package compiler_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/avm-collection/anasm/anasmtest"
+)
+
+// Comma separated list of the value repeated count times
+func repeat(value string, count int) string {
+	return strings.TrimSuffix(strings.Repeat(value + ", ", count), ", ")
+}
+
+// Comma separated list of the bytes
+func byteList(data []byte) string {
+	list := make([]string, len(data))
+	for i, b := range data {
+		list[i] = fmt.Sprint(b)
+	}
+
+	return strings.Join(list, ", ")
+}
+
+// Writes a file of the given size with every byte value, returning its path
+func dataFile(t testing.TB, size int) string {
+	t.Helper()
+
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+
+	path := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// Data written to the memory in bulk assembles to the same bytes as the same data written element
+// by element
+func TestBulkMemory(t *testing.T) {
+	path := dataFile(t, 3000)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		type_, bulk, list string
+	}{
+		{"byte", "0 .. 4097",                   repeat("0", 4097)},
+		{"i32",  "-7 .. 1025",                  repeat("-7", 1025)},
+		{"i64",  "1, 2 .. 3, 0 .. 0, 4 .. 129", "1, 2, 2, 2, " + repeat("4", 129)},
+		{"char", "\"Hello\", 0 .. 3",           "72, 101, 108, 108, 111, 0, 0, 0"},
+	}
+
+	for _, tt := range tests {
+		bulk := anasmtest.MustAssembleBytes(t, fmt.Sprintf("let x %v = %v\n.entry\n\thlt\n",
+		                                                   tt.type_, tt.bulk))
+		list := anasmtest.MustAssembleBytes(t, fmt.Sprintf("let x %v = %v\n.entry\n\thlt\n",
+		                                                   tt.type_, tt.list))
+		if !bytes.Equal(bulk, list) {
+			t.Errorf("%v = %v: assembled differently from the element list", tt.type_, tt.bulk)
+		}
+	}
+
+	files := []struct {
+		name, bulk string
+	}{
+		{"emb",    fmt.Sprintf("emb x %q", path)},
+		{"incstr", fmt.Sprintf("let x byte = incstr %q", path)},
+	}
+
+	list := anasmtest.MustAssembleBytes(t, fmt.Sprintf("let x byte = %v\n.entry\n\thlt\n",
+	                                                   byteList(data)))
+	for _, tt := range files {
+		bulk := anasmtest.MustAssembleBytes(t, tt.bulk + "\n.entry\n\thlt\n")
+		if !bytes.Equal(bulk, list) {
+			t.Errorf("%v: assembled differently from the element list", tt.name)
+		}
+	}
+
+	pad  := anasmtest.MustAssembleBytes(t, "let x byte = 1\norg 4000\nlet y byte = 2\n" +
+	                                       ".entry\n\thlt\n")
+	list  = anasmtest.MustAssembleBytes(t, fmt.Sprintf("let x byte = 1, %v, 2\n.entry\n\thlt\n",
+	                                                   repeat("0", 3998)))
+	if !bytes.Equal(pad, list) {
+		t.Errorf("org: assembled differently from the element list")
+	}
+}
+
+func benchmarkAssemble(b *testing.B, src string, size int) {
+	b.ReportAllocs()
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i ++ {
+		anasmtest.MustAssembleBytes(b, src)
+	}
+}
+
+// Large fills are copied in doubling runs instead of appended element by element
+func BenchmarkFill(b *testing.B) {
+	b.Run("byte", func(b *testing.B) {
+		benchmarkAssemble(b, "let x byte = 0 .. 8388608\n.entry\n\thlt\n", 8388608)
+	})
+
+	b.Run("i32", func(b *testing.B) {
+		benchmarkAssemble(b, "let x i32 = 5 .. 2097152\n.entry\n\thlt\n", 4 * 2097152)
+	})
+}
+
+// Embedding a file should come close to copying it
+func BenchmarkEmbed(b *testing.B) {
+	const size = 8 << 20
+	path := dataFile(b, size)
+
+	b.Run("copy", func(b *testing.B) {
+		b.SetBytes(size)
+		for i := 0; i < b.N; i ++ {
+			f, err := os.Open(path)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			io.Copy(io.Discard, f)
+			f.Close()
+		}
+	})
+
+	b.Run("emb", func(b *testing.B) {
+		benchmarkAssemble(b, fmt.Sprintf("emb x %q\n.entry\n\thlt\n", path), size)
+	})
+
+	b.Run("incstr", func(b *testing.B) {
+		benchmarkAssemble(b, fmt.Sprintf("let x byte = incstr %q\n.entry\n\thlt\n", path), size)
+	})
+}
@@ -0,0 +1,89 @@
+package compiler
+
+import (
+	"github.com/avm-collection/agen"
+
+	"github.com/avm-collection/anasm/internal/node"
+)
+
+// Bounds check of operands. An operand adding constants to the address of a variable, like
+// '(+ buf 20)', is anchored to the variable, and an offset past its end lands in whatever is
+// after it in the memory, so it is reported. The address right after the end is fine, it is
+// commonly used as the end of a buffer. Imported variables have no known size and are skipped.
+
+// Variable the expression is an address in, nil if it is not anchored to exactly one
+func (c *Compiler) anchor(e node.Expr) *node.Id {
+	switch n := e.(type) {
+	case *node.Id:
+		if _, ok := c.vars[n.Value]; ok {
+			return n
+		}
+
+	case *node.BinOp:
+		if n.Op != "+" && n.Op != "-" {
+			return nil
+		}
+
+		// Only the first argument of a subtraction can be the address
+		var anchor *node.Id
+		for i, arg := range n.Args {
+			if c.pure(arg) {
+				continue
+			} else if id := c.anchor(arg); id != nil && anchor == nil && (n.Op == "+" || i == 0) {
+				anchor = id
+			} else {
+				return nil
+			}
+		}
+
+		return anchor
+	}
+
+	return nil
+}
+
+// If the expression is a plain number, without any addresses
+func (c *Compiler) pure(e node.Expr) bool {
+	switch n := e.(type) {
+	case *node.Int, *node.SizeOf, *node.StrLen, *node.Defined: return true
+
+	case *node.Id:    return c.isDefsym(n.Value) || c.macros[n.Value].kind == intKind
+	case *node.Bits:  return c.pure(n.Value)
+	case *node.BinOp:
+		for _, arg := range n.Args {
+			if !c.pure(arg) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+func (c *Compiler) checkBounds(e node.Expr, value agen.Word) {
+	if _, ok := e.(*node.Id); ok {
+		return
+	}
+
+	id := c.anchor(e)
+	if id == nil {
+		return
+	}
+
+	var_ := c.vars[id.Value]
+	if var_.Imported {
+		return
+	}
+
+	offset := int64(value - var_.Addr)
+	if offset >= 0 && offset <= int64(var_.Size) {
+		return
+	}
+
+	if c.warn("bounds", e.GetToken().Where, "Address at offset %v of '%v' is outside of it " +
+	          "(%v bytes)", offset, id.Value, var_.Size) {
+		c.diags.Note(var_.Token.Where, "'%v' defined here", id.Value)
+	}
+}
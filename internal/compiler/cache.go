@@ -0,0 +1,210 @@
+package compiler
+
+import (
+	"os"
+	"fmt"
+	"path/filepath"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/avm-collection/anasm/internal/config"
+	"github.com/avm-collection/anasm/internal/diag"
+)
+
+// Build cache, for build servers assembling many files that mostly did not change. Entries are
+// keyed on a hash of the anasm version, the instruction table, the options and the input, and
+// hold the executable, the warnings of the build and a hash of every other file it read. They
+// are only used if all of those files are unchanged, and no file was created where an included
+// file was searched for before it was found. An entry that can not be read, is corrupt or is from
+// another version is a miss, the build then runs again and replaces it. Failed builds are not
+// cached, and warnings without a position are not shown again on hits.
+
+type Build struct {
+	Exe   []byte
+	Mode  os.FileMode
+	Files []string // See Compiler.Files
+	Hit   bool     // Reused from the cache
+
+	Stats Stats // Zero on hits, see Options.Time
+}
+
+type CacheStats struct {
+	Hits, Misses int
+}
+
+// Of the CompileCached calls so far
+func CacheCounts() CacheStats {
+	return cacheStats
+}
+
+var cacheStats CacheStats
+
+type cacheEntry struct {
+	Version string
+	Files   []cacheFile
+	Missing []string // See parser.Parser.Missing
+	Mode    os.FileMode
+	Exe     []byte
+	Diags   json.RawMessage // See diag.Log.StopRecording
+}
+
+type cacheFile struct {
+	Path, Hash string
+}
+
+// Assembles the input like Compile followed by CreateExec, reusing an earlier identical build from
+// the cache directory if there is one. Builds with the tooling hooks of the options set are never
+// cached, the hooks have to see the program.
+func CompileCached(cacheDir, input, path string, exec bool, opts Options) (*Build, bool) {
+	c := New(input, path, opts)
+	if opts.OnInst != nil || opts.OnData != nil {
+		return c.build(exec)
+	}
+
+	key, err := cacheKey(input, path, exec, opts)
+	if err != nil {
+		return c.build(exec)
+	}
+
+	entryPath := filepath.Join(cacheDir, key + ".json")
+	if build, ok := readCache(entryPath, path); ok {
+		cacheStats.Hits ++
+		return build, true
+	}
+
+	cacheStats.Misses ++
+
+	c.diags.StartRecording()
+	build, ok  := c.build(exec)
+	diags, err := c.diags.StopRecording()
+	if ok && err == nil {
+		writeCache(entryPath, build, c.missing, diags) // Only slower next time if it fails
+	}
+
+	return build, ok
+}
+
+func (c *Compiler) build(exec bool) (*Build, bool) {
+	defer c.diags.Flush()
+
+	if ok := c.Compile(); !ok {
+		return nil, false
+	}
+
+	exe, mode, err := c.encode(exec)
+	if err != nil {
+		c.diags.SimpleError("%v", err)
+		return nil, false
+	}
+
+	build := &Build{Exe: exe, Mode: mode, Files: c.Files(), Stats: c.Stats()}
+	return build, true
+}
+
+func cacheKey(input, path string, exec bool, opts Options) (string, error) {
+	opts.Time = false // Does not change the output
+
+	options, err := json.Marshal(opts)
+	if err != nil {
+		return "", err
+	}
+
+	insts, err := json.Marshal(Insts)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	for _, part := range []string{
+		cacheVersion(), string(insts), string(options), fmt.Sprint(exec), path, input,
+	} {
+		fmt.Fprintf(hash, "%v:%v;", len(part), part)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func cacheVersion() string {
+	return fmt.Sprintf("%v.%v.%v", config.VersionMajor, config.VersionMinor, config.VersionPatch)
+}
+
+func hashFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func readCache(entryPath, path string) (*Build, bool) {
+	data, err := os.ReadFile(entryPath)
+	if err != nil {
+		return nil, false
+	}
+
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Version != cacheVersion() {
+		return nil, false
+	}
+
+	build := &Build{Exe: entry.Exe, Mode: entry.Mode, Files: []string{path}, Hit: true}
+	for _, file := range entry.Files {
+		if hash, err := hashFile(file.Path); err != nil || hash != file.Hash {
+			return nil, false
+		}
+
+		build.Files = append(build.Files, file.Path)
+	}
+
+	// A file there would be included instead of the one the build read
+	for _, path := range entry.Missing {
+		if _, err := os.Stat(path); err == nil {
+			return nil, false
+		}
+	}
+
+	diags := &diag.Log{}
+	if err := diags.Replay(entry.Diags); err != nil {
+		return nil, false
+	}
+
+	diags.Flush()
+	return build, true
+}
+
+func writeCache(entryPath string, build *Build, missing []string, diags []byte) {
+	entry := cacheEntry{Version: cacheVersion(), Missing: missing, Mode: build.Mode,
+	                    Exe: build.Exe, Diags: diags}
+
+	// The input is part of the key
+	for _, path := range build.Files[1:] {
+		hash, err := hashFile(path)
+		if err != nil {
+			return
+		}
+
+		entry.Files = append(entry.Files, cacheFile{Path: path, Hash: hash})
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil || os.MkdirAll(filepath.Dir(entryPath), 0755) != nil {
+		return
+	}
+
+	// Renamed into place, so builds running at the same time never read half an entry
+	tmp, err := os.CreateTemp(filepath.Dir(entryPath), "*.tmp")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err != nil || closeErr != nil {
+		return
+	}
+
+	os.Rename(tmp.Name(), entryPath)
+}
@@ -0,0 +1,130 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Searched for in the include directories, there is none in the current directory
+const cacheLib = "cache_test_lib.anasm"
+
+func writeTestFile(t *testing.T, path, data string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	} else if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Rewrites every entry of the cache directory
+func changeEntries(t *testing.T, cacheDir string, change func([]byte) []byte) {
+	t.Helper()
+
+	paths, err := filepath.Glob(filepath.Join(cacheDir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(paths) == 0 {
+		t.Fatal("No entries in the cache")
+	}
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		writeTestFile(t, path, string(change(data)))
+	}
+}
+
+// Builds main.anasm of the directory, which includes the library from the 'a' or 'b' directory
+func cacheBuild(t *testing.T, dir string) *Build {
+	t.Helper()
+
+	path := filepath.Join(dir, "main.anasm")
+	opts := Options{IncludeDirs: []string{filepath.Join(dir, "a"), filepath.Join(dir, "b")}}
+
+	input, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	build, ok := CompileCached(filepath.Join(dir, "cache"), string(input), path, false, opts)
+	if !ok {
+		t.Fatal("Failed to assemble")
+	}
+
+	return build
+}
+
+// A build is reused while nothing it read changed, and otherwise runs again and replaces the entry
+func TestCache(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(t *testing.T, dir string) // Between the builds
+		hit    bool
+	}{
+		{"unchanged", func(*testing.T, string) {}, true},
+		{"included file changed", func(t *testing.T, dir string) {
+			writeTestFile(t, filepath.Join(dir, "b", cacheLib), "mac N = 2\n")
+		}, false},
+		// Found before the file the build read
+		{"included file shadowed", func(t *testing.T, dir string) {
+			writeTestFile(t, filepath.Join(dir, "a", cacheLib), "mac N = 3\n")
+		}, false},
+		{"corrupt entry", func(t *testing.T, dir string) {
+			changeEntries(t, filepath.Join(dir, "cache"), func(data []byte) []byte {
+				return data[:len(data) / 2]
+			})
+		}, false},
+		{"version mismatch", func(t *testing.T, dir string) {
+			changeEntries(t, filepath.Join(dir, "cache"), func(data []byte) []byte {
+				var entry cacheEntry
+				if err := json.Unmarshal(data, &entry); err != nil {
+					t.Fatal(err)
+				}
+
+				entry.Version = "0.0.0"
+				data, err := json.Marshal(entry)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				return data
+			})
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTestFile(t, filepath.Join(dir, "main.anasm"),
+			              "include \"" + cacheLib + "\"\n.entry\n\tpsh N\n\thlt\n")
+			writeTestFile(t, filepath.Join(dir, "b", cacheLib), "mac N = 1\n")
+
+			if first := cacheBuild(t, dir); first.Hit {
+				t.Fatal("First build was reused from an empty cache")
+			}
+
+			tt.change(t, dir)
+
+			second := cacheBuild(t, dir)
+			if second.Hit != tt.hit {
+				t.Fatalf("Second build reused: %v, expected %v", second.Hit, tt.hit)
+			}
+
+			// The build that ran again replaced the entry
+			third := cacheBuild(t, dir)
+			if !third.Hit {
+				t.Errorf("Third build was not reused")
+			} else if !bytes.Equal(third.Exe, second.Exe) {
+				t.Errorf("Reused executable differs from the one built")
+			}
+		})
+	}
+}
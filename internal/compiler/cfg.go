@@ -0,0 +1,213 @@
+package compiler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/avm-collection/agen"
+
+	"github.com/avm-collection/anasm/internal/node"
+	"github.com/avm-collection/anasm/internal/token"
+)
+
+// Control flow graph export. The code is split into basic blocks at labels and after jumps, calls,
+// returns and halts, and written as a Graphviz graph. Blocks not reachable from the entry point
+// (or from a label whose address is taken by data) are grayed out.
+
+type block struct {
+	labels     []string
+	start, end agen.Word // Instruction address range, end is exclusive
+
+	first, last token.Where
+	empty       bool
+
+	exit  *node.Inst // Last instruction of the block, nil if there is none
+	succs []edge
+
+	reachable bool
+}
+
+type edge struct {
+	to    int // Block index, -1 for a computed address
+	style string
+}
+
+func (c *Compiler) blocks() (blocks []*block, labelBlocks map[string]int) {
+	labelBlocks = make(map[string]int)
+
+	var addr agen.Word
+	var cur  *block
+	newBlock := func() {
+		cur = &block{start: addr, end: addr, empty: true}
+		blocks = append(blocks, cur)
+	}
+
+	for _, s := range c.program.List {
+		var size agen.Word
+		switch n := s.(type) {
+		case *node.Label:
+			if cur == nil || !cur.empty {
+				newBlock()
+			}
+
+			cur.labels = append(cur.labels, n.Name.Value)
+			labelBlocks[n.Name.Value] = len(blocks) - 1
+			continue
+
+		case *node.Inst: size = 1
+		case *node.Data: size = c.slots[n]
+
+		default: continue
+		}
+
+		if cur == nil || cur.exit != nil {
+			newBlock()
+		}
+
+		addr += size
+
+		if cur.empty {
+			cur.first = s.GetToken().Where
+			cur.empty = false
+		}
+
+		cur.last = s.GetToken().Where
+		cur.end  = addr
+
+		if n, ok := s.(*node.Inst); ok && (isJump(n.Name) || endsFlow(n.Name)) {
+			cur.exit = n
+		}
+	}
+
+	return
+}
+
+// Writes the control flow graph of the compiled program in the Graphviz DOT format
+func (c *Compiler) WriteCFG(w io.Writer) error {
+	blocks, labelBlocks := c.blocks()
+
+	indirect := false
+	for i, b := range blocks {
+		if b.exit == nil || !endsFlow(b.exit.Name) {
+			if i + 1 < len(blocks) {
+				b.succs = append(b.succs, edge{to: i + 1})
+			}
+		}
+
+		if b.exit == nil || !isJump(b.exit.Name) {
+			continue
+		}
+
+		style := ""
+		if b.exit.Name == "cal" {
+			style = "dashed"
+		}
+
+		to := -1
+		if id, ok := b.exit.Arg.(*node.Id); ok {
+			if i, ok := labelBlocks[id.Value]; ok {
+				to = i
+			}
+		}
+
+		if to == -1 {
+			indirect = true
+		}
+
+		b.succs = append(b.succs, edge{to: to, style: style})
+	}
+
+	work := []int{}
+	if addr := c.opts.EntryAddr; addr != nil {
+		for i, b := range blocks {
+			if !b.empty && *addr >= b.start && *addr < b.end {
+				work = append(work, i)
+			}
+		}
+	} else if i, ok := labelBlocks[c.entry()]; ok {
+		work = append(work, i)
+	}
+
+	for _, i := range c.addressTaken(c.labelIndices()) {
+		if n, ok := c.program.List[i].(*node.Label); ok {
+			work = append(work, labelBlocks[n.Name.Value])
+		}
+	}
+
+	for len(work) > 0 {
+		i   := work[len(work) - 1]
+		work = work[:len(work) - 1]
+
+		if blocks[i].reachable {
+			continue
+		}
+
+		blocks[i].reachable = true
+		for _, e := range blocks[i].succs {
+			if e.to != -1 {
+				work = append(work, e.to)
+			}
+		}
+	}
+
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "digraph %v {\n", dotQuote(c.path))
+	fmt.Fprintf(out, "\tnode [shape=box, fontname=monospace];\n")
+
+	for i, b := range blocks {
+		label := ""
+		for _, name := range b.labels {
+			label += name + ":\\l"
+		}
+
+		if b.empty {
+			label += "(empty)\\l"
+		} else {
+			label += fmt.Sprintf("instructions %v-%v\\l", b.start, b.end - 1)
+			if b.first.Path == b.last.Path && b.first.Row == b.last.Row {
+				label += fmt.Sprintf("%v:%v\\l", b.first.Path, b.first.Row)
+			} else if b.first.Path == b.last.Path {
+				label += fmt.Sprintf("%v:%v-%v\\l", b.first.Path, b.first.Row, b.last.Row)
+			} else {
+				label += fmt.Sprintf("%v - %v\\l", b.first, b.last)
+			}
+		}
+
+		// A jump to a computed address can reach anything
+		attrs := ""
+		if !b.reachable && !indirect {
+			attrs = ", color=gray, fontcolor=gray"
+		}
+
+		fmt.Fprintf(out, "\tb%v [label=%v%v];\n", i, dotQuote(label), attrs)
+	}
+
+	if indirect {
+		fmt.Fprintf(out, "\tindirect [label=\"computed address\", shape=ellipse];\n")
+	}
+
+	for i, b := range blocks {
+		for _, e := range b.succs {
+			to := "indirect"
+			if e.to != -1 {
+				to = fmt.Sprintf("b%v", e.to)
+			}
+
+			if len(e.style) > 0 {
+				fmt.Fprintf(out, "\tb%v -> %v [style=%v];\n", i, to, e.style)
+			} else {
+				fmt.Fprintf(out, "\tb%v -> %v;\n", i, to)
+			}
+		}
+	}
+
+	fmt.Fprintf(out, "}\n")
+	return out.Flush()
+}
+
+// Label escapes like \l are kept, only quotes are escaped
+func dotQuote(s string) string {
+	return "\"" + strings.ReplaceAll(s, "\"", "\\\"") + "\""
+}
@@ -2,81 +2,579 @@ package compiler
 
 import (
 	"os"
+	"fmt"
 	"math"
+	"strings"
+	"unicode/utf8"
+	"unicode/utf16"
+	"path/filepath"
+	"encoding/binary"
 
-	"github.com/avm-collection/goerror"
 	"github.com/avm-collection/agen"
 
+	"github.com/avm-collection/anasm/internal/config"
+	"github.com/avm-collection/anasm/internal/diag"
 	"github.com/avm-collection/anasm/internal/token"
 	"github.com/avm-collection/anasm/internal/parser"
 	"github.com/avm-collection/anasm/internal/node"
+	"github.com/avm-collection/anasm/internal/executable"
 )
 
 const EntryLabel = "entry"
 
+const DefaultPadInst = "hlt"
+
 type Label struct {
 	Token token.Token
 	Addr  agen.Word
+
+	Imported bool // From a symbol file, the address is in another program
 }
 
 type Var struct {
 	Token token.Token
 	Size  agen.Word
 	Addr  agen.Word
+	Elem  agen.Word // Size of the elements, 1 for embedded files and 0 for imported variables
+	Sign  string    // node.Signed or node.Unsigned if the elements are qualified
+
+	Imported bool
 }
 
 type Macro struct {
 	Token token.Token
 	Value agen.Word
+	Text  string // Value of string macros
+
+	kind valueKind
+}
+
+// Kind of a constant expression value, to catch floats used as integers and the other way around
+type valueKind int
+const (
+	unknownKind = valueKind(iota) // Mixed or not known
+	intKind
+	floatKind
+	stringKind
+)
+
+type Options struct {
+	GCCode   bool // Remove code unreachable from the entry point and data that is never used
+	DataOnly bool // Allow programs without instructions, only warning about them
+
+	AllowInstNames bool // Allow labels, variables and macros named like instructions
+	UnicodeNames   bool // Allow Unicode letters and digits in names, see lexer.CheckName
+	ExplicitTypes  bool // Require the element type of lets instead of inferring it
+	Metadata       bool // Write 'meta' entries into a trailing section of the executable
+	AlignProgram   int  // Align the start and end of the program section (power of two, 0 is off)
+
+	WarningsAsErrors bool
+	StackCheck       bool // Warn about possible stack underflows and unbalanced paths
+	MixedData        bool // Warn about strings among numbers in lets of elements wider than bytes
+	Fallthrough      bool // Warn about code running off the end of a label into the next one
+
+	// Variables with the same element size and data share the memory of the first one. Only
+	// safe if none of them is written to at runtime.
+	MergeStrings bool
+
+	IncludeDirs []string // Searched for included files not found in the current directory
+	Comments    []string // Line comment introducers, '#' and ';' if nil
+	Imports     []Import // Symbols of other programs, see ReadSymbols
+	Defsyms     []Defsym // Symbols given by the build, like addresses that differ per target
+
+	Entry     string     // Label of the entry point, EntryLabel if empty
+	EntryAddr *agen.Word // Instruction index of the entry point, used instead of a label if set
+	NoEntry   bool       // Assemble a fragment without an entry point, see executable.NoEntry
+
+	NormalizeNewlines bool // Convert CRLF line endings of incstr files to LF
+	ArgsAcrossLines   bool // Take instruction arguments from the next lines too
+	MaxIncludeDepth   int  // parser.DefaultMaxIncludeDepth if 0
+
+	MaxMemory agen.Word // Max size of the memory in bytes, DefaultMaxMemory if 0
+
+	// Instructions appended after the program, so execution running off its end stops. They
+	// count into the program size, so code addresses can point at them.
+	PadEnd  int
+	PadInst string // Instruction of the padding, taking no argument, DefaultPadInst if empty
+
+	// Build information written into a stamp section if not empty. Nothing is added to it, so
+	// builds stay reproducible.
+	Stamp []executable.Meta
+
+	Time bool // Measure the phases of the build, see Stats
+
+	// Optional hooks for tooling, called for every emitted instruction (including inline data
+	// slots) and for every variable written to the memory. Builds using them are not cached.
+	OnInst func(index agen.Word, op byte, operand agen.Word, where token.Where) `json:"-"`
+	OnData func(name string, addr, size agen.Word, where token.Where)           `json:"-"`
+}
+
+// The options with every check on (-strict), for new programs. The set only changes in a new
+// major version, so programs passing it keep passing:
+//
+//   - ExplicitTypes, lets need their element type
+//   - StackCheck, MixedData and Fallthrough, all the optional warnings
+//   - WarningsAsErrors, so truncated values and the other warnings are errors
+//   - No ArgsAcrossLines, instruction arguments end with their line
+//   - No AllowInstNames, names can not be the names of instructions
+//
+// Names of builtin constants are rejected with or without it. The other options are kept, so
+// it can be applied to options and then adjusted.
+func (o Options) Strict() Options {
+	o.ExplicitTypes    = true
+	o.StackCheck       = true
+	o.MixedData        = true
+	o.Fallthrough      = true
+	o.WarningsAsErrors = true
+	o.ArgsAcrossLines  = false
+	o.AllowInstNames   = false
+
+	return o
 }
 
 type Compiler struct {
 	a       *agen.AGEN
 	program *node.Statements
+	opts    Options
 
 	labels map[string]Label
 	vars   map[string]Var
 	macros map[string]Macro
 
+	defsyms map[string]Defsym
+
+	programSize agen.Word
+	instCount   agen.Word // Instructions emitted so far
+
+	meta       []executable.Meta
+	metaTokens []token.Token
+
+	asserts []*node.Assert
+
+	refs   map[string][]token.Where // Symbol name -> references, see XRef
+	merged map[string]Var           // Variable data -> first variable with it, see MergeStrings
+	early  map[*node.Macro]bool     // Macros already defined by preproc
+	named  map[string]bool          // Names of all macros of the program, see evalDefined
+	slots  map[*node.Data]agen.Word // Program slots of inline data, counted by preproc
+
+	files   []string // Files read besides the input, see Files
+	missing []string // Searched for included files and not there, see parser.Parser.Missing
+
+	suppressed []parser.Suppression // By the warning pragmas of the parsed files
+
+	self *letAddrs // Of the let being written, nil outside of lets
+
+	diags *diag.Log
+	stats Stats
+
 	input, path string
 }
 
-func New(input, path string) *Compiler {
+// Compilers do not share any state with each other and can be used concurrently, each reports
+// into its own diagnostics log. Creating a compiler seals the instruction table (see LoadInsts).
+func New(input, path string, opts Options) *Compiler {
+	sealInsts()
+
 	return &Compiler{
-		a: agen.New(), input: input, path: path,
+		a: agen.New(), input: input, path: path, opts: opts, diags: &diag.Log{},
 		labels: make(map[string]Label),
 		vars:   make(map[string]Var),
 		macros: make(map[string]Macro),
+
+		defsyms: make(map[string]Defsym),
+
+		refs:   make(map[string][]token.Where),
+		merged: make(map[string]Var),
+		early:  make(map[*node.Macro]bool),
+		named:  make(map[string]bool),
+		slots:  make(map[*node.Data]agen.Word),
+	}
+}
+
+// Prepares the compiler for another input, keeping the options and the allocated maps
+func (c *Compiler) Reset(input, path string) {
+	c.a       = agen.New()
+	c.program = nil
+	c.input   = input
+	c.path    = path
+
+	c.programSize = 0
+	c.instCount   = 0
+
+	c.meta       = nil
+	c.metaTokens = nil
+
+	c.asserts = nil
+	c.files   = nil
+	c.missing = nil
+	c.stats   = Stats{}
+
+	c.suppressed = nil
+	c.self       = nil
+	c.diags      = &diag.Log{}
+
+	for name := range c.labels {
+		delete(c.labels, name)
+	}
+
+	for name := range c.vars {
+		delete(c.vars, name)
+	}
+
+	for name := range c.macros {
+		delete(c.macros, name)
+	}
+
+	for name := range c.defsyms {
+		delete(c.defsyms, name)
+	}
+
+	for name := range c.refs {
+		delete(c.refs, name)
+	}
+
+	for data := range c.merged {
+		delete(c.merged, data)
+	}
+
+	for n := range c.early {
+		delete(c.early, n)
+	}
+
+	for name := range c.named {
+		delete(c.named, name)
+	}
+
+	for n := range c.slots {
+		delete(c.slots, n)
 	}
 }
 
+// Parses and emits the input, same as Parse followed by Emit
 func (c *Compiler) Compile() bool {
+	defer c.diags.Flush()
+
+	program, ok := c.Parse()
+	if !ok {
+		return false
+	}
+
+	return c.Emit(program)
+}
+
+// Parses and emits the input like Compile, but returns the diagnostics instead of printing them,
+// for editors checking programs as they are written
+func (c *Compiler) Check() []diag.Diagnostic {
+	c.diags.Silent = true
+	defer func() { c.diags.Silent = false }()
+
+	c.Compile()
+	return c.diags.Take()
+}
+
+// Parses the input into the statements that Emit encodes, for tools that transform programs
+// before they are assembled. Diagnostics are held back until Compile or Emit.
+func (c *Compiler) Parse() (*node.Statements, bool) {
 	p := parser.New(c.input, c.path)
-	if c.program = p.Parse(); goerror.Happened() {
+	p.Diags          = c.diags
+	p.AllowInstNames = c.opts.AllowInstNames
+	p.IncludeDirs    = c.opts.IncludeDirs
+	p.Comments       = c.opts.Comments
+	p.UnicodeNames   = c.opts.UnicodeNames
+	p.ExplicitTypes  = c.opts.ExplicitTypes
+
+	p.ArgsAcrossLines = c.opts.ArgsAcrossLines
+
+	p.NormalizeNewlines = c.opts.NormalizeNewlines
+	p.MaxIncludeDepth   = c.opts.MaxIncludeDepth
+
+	start     := c.startPhase()
+	program   := p.Parse()
+	c.files   = append(c.files, p.Files()...)
+	c.missing = append(c.missing, p.Missing()...)
+	c.endPhase(start, &c.stats.Parse)
+
+	c.suppressed = p.Suppressions()
+
+	return program, !c.diags.Happened()
+}
+
+// Encodes parsed statements, which may come from Parse or be built or changed by a tool. The
+// statements belong to the compiler afterwards, macros are expanded in place. A compiler emits
+// once, use Reset before emitting another program.
+func (c *Compiler) Emit(program *node.Statements) bool {
+	defer c.diags.Flush()
+
+	if c.diags.Happened() {
+		return false
+	}
+
+	c.program = program
+
+	defer c.endPhase(c.startPhase(), &c.stats.Compile)
+
+	if c.opts.GCCode {
+		c.gcCode()
+	}
+
+	if !c.checkPadding() {
+		return false
+	}
+
+	if c.preproc(); c.diags.Happened() {
+		return false
+	}
+
+	if c.compile(); c.diags.Happened() {
+		return false
+	}
+
+	if len(c.meta) > 0 {
+		if !c.opts.Metadata {
+			c.warn("meta", c.metaTokens[0].Where, "Metadata is not written into the executable " +
+			       "without -meta")
+		} else if _, err := executable.EncodeMeta(c.meta); err != nil {
+			c.diags.Error(c.metaTokens[0].Where, err.Error())
+			return false
+		}
+	}
+
+	if c.programSize == agen.Word(c.opts.PadEnd) {
+		if c.opts.DataOnly {
+			c.simpleWarn("Program contains no instructions")
+			return !c.diags.Happened()
+		}
+
+		c.diags.SimpleError("Program contains no instructions")
 		return false
 	}
 
-	if c.preproc(); goerror.Happened() {
+	if c.opts.NoEntry {
+		c.a.SetEntry(executable.NoEntry)
+	} else if addr := c.opts.EntryAddr; addr != nil {
+		if *addr >= c.programSize {
+			c.diags.SimpleError("Program entry point %v is after the last instruction (program " +
+			                    "size is %v)", *addr, c.programSize)
+			return false
+		}
+
+		c.a.SetEntry(*addr)
+	} else if entry, ok := c.labels[c.entry()]; !ok {
+		c.diags.SimpleError("Program entry point label '%v' not found", c.entry())
 		return false
+	} else if !c.checkEntry(entry) {
+		return false
+	}
+
+	if c.opts.StackCheck {
+		c.checkStack()
+	}
+
+	if c.opts.Fallthrough {
+		c.checkFallthrough()
+	}
+
+	return !c.diags.Happened() // Warnings turned into errors
+}
+
+// Every file the input was assembled from, the input first, then the included, text and embedded
+// files in the order they were read
+func (c *Compiler) Files() []string {
+	return append([]string{c.path}, c.files...)
+}
+
+func (c *Compiler) padInst() string {
+	if len(c.opts.PadInst) > 0 {
+		return c.opts.PadInst
+	}
+
+	return DefaultPadInst
+}
+
+// Looked up by name, so the padding follows the instruction table
+func (c *Compiler) checkPadding() bool {
+	if c.opts.PadEnd == 0 {
+		return true
 	}
 
-	if c.compile(); goerror.Happened() {
+	if inst, ok := Insts[c.padInst()]; !ok {
+		c.diags.SimpleError("Unknown padding instruction '%v'", c.padInst())
 		return false
+	} else if inst.HasArg {
+		c.diags.SimpleError("Padding instruction '%v' takes an argument", c.padInst())
+		return false
+	}
+
+	return true
+}
+
+func (c *Compiler) writePadding() {
+	for i := 0; i < c.opts.PadEnd; i ++ {
+		c.writeInst(c.padInst(), 0, false, token.Where{})
+	}
+}
+
+func (c *Compiler) entry() string {
+	if len(c.opts.Entry) > 0 {
+		return c.opts.Entry
+	}
+
+	return EntryLabel
+}
+
+// Statement index of the entry label, not found if the entry point is set by its address or there
+// is none
+func (c *Compiler) entryIndex(labels map[string]int) (int, bool) {
+	if c.opts.EntryAddr != nil || c.opts.NoEntry {
+		return 0, false
+	}
+
+	i, ok := labels[c.entry()]
+	return i, ok
+}
+
+// The entry point has to be on an instruction. Only instructions and inline data take space in
+// the program, variables and everything else in between are skipped.
+func (c *Compiler) checkEntry(entry Label) bool {
+	for _, s := range c.program.List[c.labelIndices()[c.entry()] + 1:] {
+		switch s.(type) {
+		case *node.Inst: return true
+
+		case *node.Data:
+			c.diags.Error(entry.Token.Where, "Program entry point label '%v' is on inline data",
+			              c.entry())
+			c.diags.Note(s.GetToken().Where, "Data here")
+			return false
+		}
 	}
 
-	if _, ok := c.labels[EntryLabel]; !ok {
-		goerror.SimpleError("Program entry point label '%v' not found", EntryLabel)
+	c.diags.Error(entry.Token.Where, "Program entry point label '%v' is after the last instruction",
+	              c.entry())
+	return false
+}
+
+// Reports a warning, or an error with WarningsAsErrors, unless a pragma suppresses it where it is
+// (see parser.Warnings). Returns false if it was suppressed, so notes are only added to reported
+// warnings.
+func (c *Compiler) warn(warning string, where token.Where, format string,
+                        args... interface{}) bool {
+	if parser.Suppressed(c.suppressed, warning, where.Path, where.Row) {
 		return false
 	}
 
+	if c.opts.WarningsAsErrors {
+		c.diags.Error(where, format, args...)
+	} else {
+		c.diags.Warning(where, format, args...)
+	}
+
 	return true
 }
 
-func (c *Compiler) CreateExec(path string, executable bool) error {
-	return c.a.CreateExecAVM(path, executable)
+func (c *Compiler) simpleWarn(format string, args... interface{}) {
+	if c.opts.WarningsAsErrors {
+		c.diags.SimpleError(format, args...)
+	} else {
+		c.diags.SimpleWarning(format, args...)
+	}
+}
+
+// Encodes the executable, with a shebang line if exec is set
+func (c *Compiler) Exec(exec bool) ([]byte, error) {
+	data, _, err := c.encode(exec)
+	return data, err
+}
+
+// Writes the executable to the path, runnable if exec is set
+func (c *Compiler) CreateExec(path string, exec bool) error {
+	data, mode, err := c.encode(exec)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, mode)
+}
+
+// agen only writes executables into files, so the executable is written into a temporary one and
+// read back with the mode agen gave it
+func (c *Compiler) encode(exec bool) ([]byte, os.FileMode, error) {
+	defer c.endPhase(c.startPhase(), &c.stats.Write)
+
+	sections, err := c.sections()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	dir, err := os.MkdirTemp("", config.AppName)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "out")
+	if err := c.a.CreateExecAVM(path, exec); err != nil {
+		return nil, 0, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil || len(sections) == 0 {
+		return data, info.Mode(), err
+	}
+
+	// Lay the executable out again with the sections
+	exe, err := executable.Parse(data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	exe.Align    = c.opts.AlignProgram
+	exe.Sections = sections
+	return exe.Bytes(), info.Mode(), nil
+}
+
+// Optional sections to write after the program
+func (c *Compiler) sections() (sections []executable.Section, err error) {
+	if c.opts.Metadata && len(c.meta) > 0 {
+		data, _ := executable.EncodeMeta(c.meta) // Checked after compiling
+		sections = append(sections, executable.Section{Tag: executable.MetaTag, Data: data})
+	}
+
+	if len(c.opts.Stamp) > 0 {
+		data, err := executable.EncodeMeta(c.opts.Stamp)
+		if err != nil {
+			return nil, fmt.Errorf("Stamp: %v", err.Error())
+		}
+
+		sections = append(sections, executable.Section{Tag: executable.StampTag, Data: data})
+	}
+
+	if c.opts.AlignProgram != 0 {
+		sections = append(sections, executable.Section{
+			Tag: executable.AlignTag, Data: executable.EncodeAlign(c.opts.AlignProgram),
+		})
+	}
+
+	return
 }
 
+// Gives the labels their addresses before anything is emitted. Operands can be any expression of
+// labels, and so can data, macros and asserts, so forward references are not patched in later.
+// This pass only walks the parsed statements counting program slots, it does not lex or evaluate
+// anything.
 func (c *Compiler) preproc() {
+	c.defineImports()
+
+	for _, s := range c.program.List {
+		if n, ok := s.(*node.Macro); ok {
+			c.named[n.Name.Value] = true
+		}
+	}
+
 	var addr agen.Word
 	for _, s := range c.program.List {
 		switch n := s.(type) {
@@ -86,14 +584,40 @@ func (c *Compiler) preproc() {
 			}
 
 			c.labels[n.Name.Value] = Label{Token: n.Token, Addr: addr}
-			if n.Name.Value == EntryLabel {
+			if n.Name.Value == c.entry() && c.opts.EntryAddr == nil && !c.opts.NoEntry {
 				c.a.SetEntry(addr)
 			}
 
+		// Macros of only literals and earlier macros are defined right away, so the sizes of
+		// inline data can use them
+		case *node.Macro:
+			if c.constant(n.Value) {
+				c.compileMacro(n)
+				c.early[n] = true
+			}
+
 		case *node.Inst: addr ++
+		case *node.Data:
+			c.slots[n] = c.dataSlots(n)
+			addr      += c.slots[n]
+
 		default:
 		}
 	}
+
+	c.programSize = addr + agen.Word(c.opts.PadEnd)
+}
+
+// If the expression only uses literals, types and defined macros
+func (c *Compiler) constant(e node.Expr) bool {
+	constant := true
+	node.WalkIds(e, func(id *node.Id) {
+		if _, ok := c.macros[id.Value]; !ok && !c.isDefsym(id.Value) {
+			constant = false
+		}
+	})
+
+	return constant
 }
 
 func (c *Compiler) compile() {
@@ -101,38 +625,167 @@ func (c *Compiler) compile() {
 		switch n := s.(type) {
 		case *node.Label: continue;
 
-		case *node.Macro: c.compileMacro(n)
+		case *node.Macro:
+			if !c.early[n] {
+				c.compileMacro(n)
+			}
+
 		case *node.Embed: c.compileEmbed(n)
 		case *node.Let:   c.compileLet(n)
+		case *node.Data:  c.compileData(n)
 		case *node.Inst:  c.compileInst(n)
+		case *node.Meta:  c.compileMeta(n)
+		case *node.Org:   c.compileOrg(n)
+
+		// Evaluated once everything is defined
+		case *node.Assert: c.asserts = append(c.asserts, n)
 		}
 	}
+
+	c.writePadding()
+
+	for _, n := range c.asserts {
+		c.evalAssert(n)
+	}
+}
+
+func (c *Compiler) evalAssert(n *node.Assert) {
+	if c.evalExpr(n.Cond) != 0 {
+		return
+	}
+
+	if n.Message == nil {
+		c.diags.Error(n.Token.Where, "Assertion %v failed", n.Cond)
+	} else {
+		c.diags.Error(n.Token.Where, "Assertion %v failed: %v", n.Cond, n.Message.Value)
+	}
 }
 
 func (c *Compiler) redefined(name *node.Id) bool {
 	if prev, ok := c.labels[name.Value]; ok {
-		goerror.Error(name.Token.Where, "Label '%v' redefined", name.Value)
-		goerror.Note(prev.Token.Where, "Previously defined here")
+		c.diags.Error(name.Token.Where, "Label '%v' redefined", name.Value)
+		c.diags.Note(prev.Token.Where, previously(prev.Imported))
 		return true
 	} else if prev, ok := c.vars[name.Value]; ok {
-		goerror.Error(name.Token.Where, "Variable '%v' redefined", name.Value)
-		goerror.Note(prev.Token.Where, "Previously defined here")
+		c.diags.Error(name.Token.Where, "Variable '%v' redefined", name.Value)
+		c.diags.Note(prev.Token.Where, previously(prev.Imported))
 		return true
 	} else if prev, ok := c.macros[name.Value]; ok {
-		goerror.Error(name.Token.Where, "Macro '%v' redefined", name.Value)
-		goerror.Note(prev.Token.Where, "Previously defined here")
+		c.diags.Error(name.Token.Where, "Macro '%v' redefined", name.Value)
+		c.diags.Note(prev.Token.Where, "Previously defined here")
+		return true
+	} else if prev, ok := c.defsyms[name.Value]; ok {
+		c.diags.Error(name.Token.Where, "Symbol '%v' redefined", name.Value)
+		c.diags.Note(prev.Where, "Previously given here")
 		return true
 	}
 
 	return false
 }
 
+func previously(imported bool) string {
+	if imported {
+		return "Previously imported here"
+	}
+
+	return "Previously defined here"
+}
+
 func (c *Compiler) compileMacro(n *node.Macro) {
 	if c.redefined(n.Name) {
 		return
 	}
 
-	c.macros[n.Name.Value] = Macro{Token: n.Token, Value: c.evalExpr(n.Value)}
+	if kind := c.kindOf(n.Value); kind == stringKind {
+		c.macros[n.Name.Value] = Macro{Token: n.Token, Text: c.evalString(n.Value), kind: kind}
+	} else {
+		c.macros[n.Name.Value] = Macro{Token: n.Token, Value: c.evalExpr(n.Value), kind: kind}
+	}
+}
+
+// Far more memory than programs use, so a typo in a size or a fill count is an error instead of
+// allocating gigabytes
+const DefaultMaxMemory = 1 << 24
+
+func (c *Compiler) maxMemory() agen.Word {
+	if c.opts.MaxMemory == 0 {
+		return DefaultMaxMemory
+	}
+
+	return c.opts.MaxMemory
+}
+
+// Reports adding size bytes to the memory going over the max size
+func (c *Compiler) fitsMemory(size agen.Word, what string, where token.Where) bool {
+	used := c.a.MemorySize()
+	if size <= c.maxMemory() && used <= c.maxMemory() - size {
+		return true
+	}
+
+	c.diags.Error(where, "%v needs %v bytes, the memory would go over the max of %v bytes (%v " +
+	              "already used)", what, size, c.maxMemory(), used)
+	return false
+}
+
+// Padding org can add at once, so a typo in the address does not allocate gigabytes
+const maxOrgPadding = 1 << 24
+
+// Pads the memory with zeros so the next variable starts at the address. The memory is only ever
+// appended to, so the address can not be before its end.
+func (c *Compiler) compileOrg(n *node.Org) {
+	addr := c.evalExpr(n.Addr)
+	size := c.a.MemorySize()
+
+	if addr < size {
+		c.diags.Error(n.Addr.GetToken().Where, "Address %v is before the end of the memory (%v " +
+		              "bytes), variables can not overlap", addr, size)
+		return
+	} else if addr - size > maxOrgPadding {
+		c.diags.Error(n.Addr.GetToken().Where, "Address %v needs %v bytes of padding, more than " +
+		              "%v", addr, addr - size, maxOrgPadding)
+		return
+	} else if addr == size || !c.fitsMemory(addr - size, "Org", n.Addr.GetToken().Where) {
+		return
+	}
+
+	c.writeMemoryBytes(make([]byte, addr - size))
+}
+
+// Byte data is appended to the memory at once, instead of element by element
+func (c *Compiler) writeMemoryBytes(data []byte) agen.Word {
+	return c.a.AddMemoryString(string(data))
+}
+
+func (c *Compiler) writeMemory(list []agen.Word, type_ agen.Type) agen.Word {
+	if SizeOfType(type_) != 1 {
+		return c.a.AddMemoryInt(list, type_)
+	}
+
+	data := make([]byte, len(list))
+	for i, value := range list {
+		data[i] = byte(value)
+	}
+
+	return c.writeMemoryBytes(data)
+}
+
+func (c *Compiler) compileMeta(n *node.Meta) {
+	for i, entry := range c.meta {
+		if entry.Key == n.Key {
+			c.diags.Error(n.Token.Where, "Metadata key '%v' redefined", n.Key)
+			c.diags.Note(c.metaTokens[i].Where, "Previously defined here")
+			return
+		}
+	}
+
+	if len(n.Key) > executable.MaxMetaKey {
+		c.diags.Error(n.Token.Where, "Metadata key '%v' is longer than %v bytes",
+		              n.Key, executable.MaxMetaKey)
+		return
+	}
+
+	c.meta       = append(c.meta, executable.Meta{Key: n.Key, Value: n.Value.Value})
+	c.metaTokens = append(c.metaTokens, n.Token)
 }
 
 func (c *Compiler) compileEmbed(n *node.Embed) {
@@ -142,15 +795,21 @@ func (c *Compiler) compileEmbed(n *node.Embed) {
 
 	data, err := os.ReadFile(n.Path.Value)
 	if err != nil {
-		goerror.Error(n.Token.Where, "Could not embed file '%v'", n.Path.Value)
+		c.diags.Error(n.Token.Where, "Could not embed file '%v'", n.Path.Value)
+		return
+	}
+
+	c.files = append(c.files, n.Path.Value)
+	if !c.fitsMemory(agen.Word(len(data)), fmt.Sprintf("'%v'", n.Name.Value), n.Token.Where) {
 		return
 	}
 
 	size := c.a.MemorySize()
-	addr := c.a.AddMemoryString(string(data))
+	addr := c.writeMemoryBytes(data)
 	size  = c.a.MemorySize() - size
 
-	c.vars[n.Name.Value] = Var{Token: n.Token, Addr: addr, Size: size}
+	c.vars[n.Name.Value] = Var{Token: n.Token, Addr: addr, Size: size, Elem: 1}
+	c.dataHook(n.Name.Value, addr, size, n.Token.Where)
 }
 
 func (c *Compiler) compileLet(n *node.Let) {
@@ -158,37 +817,640 @@ func (c *Compiler) compileLet(n *node.Let) {
 		return
 	}
 
-	list := []agen.Word{}
-	for _, expr := range n.Values {
-		switch e := expr.(type) {
-		case *node.Fill:
-			count := c.evalExpr(e.Count)
-			value := c.evalExpr(e.Value)
-			for i := agen.Word(0); i < count; i ++ {
-				list = append(list, value)
+	c.checkFloats(n.Values, n.Type)
+	if c.opts.MixedData {
+		c.checkMixed(n)
+	}
+
+	// Written at the end of the memory
+	c.self = &letAddrs{base: c.a.MemorySize(), start: c.a.MemorySize()}
+	list  := c.evalValues(n.Values, n.Type, fmt.Sprintf("'%v'", n.Name.Value))
+	c.self = nil
+
+	// Data with its own address is only right at that address
+	var key string
+	if c.opts.MergeStrings && len(list) > 0 && !usesSelf(n.Values) {
+		key = mergeKey(list, n.Type.Type)
+		if prev, ok := c.merged[key]; ok {
+			c.vars[n.Name.Value] = Var{Token: n.Token, Addr: prev.Addr, Size: prev.Size,
+			                           Elem: prev.Elem, Sign: n.Type.Sign}
+			c.dataHook(n.Name.Value, prev.Addr, prev.Size, n.Token.Where)
+			return
+		}
+	}
+
+	need := agen.Word(len(list)) * SizeOfType(n.Type.Type)
+	if !c.fitsMemory(need, fmt.Sprintf("'%v'", n.Name.Value), n.Name.Token.Where) {
+		return
+	}
+
+	size := c.a.MemorySize()
+	addr := c.writeMemory(list, n.Type.Type)
+	size  = c.a.MemorySize() - size
+
+	c.vars[n.Name.Value] = Var{Token: n.Token, Addr: addr, Size: size,
+	                           Elem: SizeOfType(n.Type.Type), Sign: n.Type.Sign}
+	c.dataHook(n.Name.Value, addr, size, n.Token.Where)
+
+	if len(key) > 0 {
+		c.merged[key] = c.vars[n.Name.Value]
+	}
+}
+
+// The bytes a list of values is written to the memory as, elements are truncated to their size
+func mergeKey(list []agen.Word, type_ agen.Type) string {
+	size := SizeOfType(type_)
+	data := make([]byte, 0, agen.Word(len(list)) * size)
+	for _, value := range list {
+		for i := agen.Word(0); i < size; i ++ {
+			data = append(data, byte(value >> (i * 8)))
+		}
+	}
+
+	return string(data)
+}
+
+func (c *Compiler) dataHook(name string, addr, size agen.Word, where token.Where) {
+	if c.opts.OnData != nil {
+		c.opts.OnData(name, addr, size, where)
+	}
+}
+
+// Makes room for n more elements at once
+func grow(list []agen.Word, n int) []agen.Word {
+	if len(list) + n <= cap(list) {
+		return list
+	}
+
+	grown := make([]agen.Word, len(list), len(list) + n)
+	copy(grown, list)
+	return grown
+}
+
+// Appends count copies of the value. The copies are doubled each step, so large fills do not
+// append element by element.
+func fill(list []agen.Word, value, count agen.Word) []agen.Word {
+	start, end := len(list), len(list) + int(count)
+
+	list = grow(list, int(count))[:end]
+	if count == 0 {
+		return list
+	}
+
+	list[start] = value
+	for n := 1; start + n < end; n *= 2 {
+		copy(list[start + n:], list[start:start + n])
+	}
+
+	return list
+}
+
+// Addresses of a let while its values are evaluated. self is the address of the element being
+// evaluated, and selfbase the address of the let. The copies of a fill are elements of their own,
+// so each of them gets its own address.
+type letAddrs struct {
+	base  agen.Word
+	start agen.Word // Of the list being evaluated, a padded field is a list of its own
+	elem  agen.Word
+
+	// Evaluating the copies of a fill after the first. They are checked like the first one until
+	// one of them is truncated, so a fill warns once.
+	repeated  bool
+	truncated bool
+}
+
+func usesSelf(values []node.Expr) bool {
+	for _, expr := range values {
+		if node.UsesSelf(expr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Called before an element of the list is evaluated
+func (c *Compiler) nextElem(list []agen.Word, type_ *node.Type) {
+	if c.self != nil {
+		c.self.elem = c.self.start + agen.Word(len(list)) * SizeOfType(type_.Type)
+	}
+}
+
+func (c *Compiler) evalValues(values []node.Expr, type_ *node.Type, of string) []agen.Word {
+	list := []agen.Word{}
+	for _, expr := range values {
+		if s := c.asString(expr); s != nil {
+			expr = s
+		}
+
+		c.nextElem(list, type_)
+		switch e := expr.(type) {
+		case *node.Fill:
+			self := node.UsesSelf(e.Value)
+			if self {
+				c.self.truncated = false
+			}
+
+			count := c.evalExpr(e.Count)
+			field := c.evalField(e.Value, type_, of)
+
+			// Checked before the values are allocated, with the ones before them
+			elems := c.maxMemory() / SizeOfType(type_.Type)
+			if len(field) > 0 && (count > elems / agen.Word(len(field)) ||
+			                      agen.Word(len(list)) + count * agen.Word(len(field)) > elems) {
+				c.diags.Error(e.Count.GetToken().Where, "Fill count %v in %v is over the max " +
+				              "memory size of %v bytes", count, of, c.maxMemory())
+				continue
 			}
 
+			if len(field) == 1 && !self {
+				list = fill(list, field[0], count)
+				break
+			}
+
+			list = grow(list, int(count) * len(field))
+			for i := agen.Word(0); i < count; i ++ {
+				// Nothing is written after errors, so the copies are not evaluated again then
+				if i > 0 && self && !c.diags.Happened() {
+					c.self.repeated = true
+					c.nextElem(list, type_)
+					field = c.evalField(e.Value, type_, of)
+					c.self.repeated = false
+				}
+
+				list = append(list, field...)
+			}
+
+		case *node.Pad: list = append(list, c.evalPad(e, type_, of)...)
+
 		case *node.String:
-			for _, ch := range e.Value {
-				list = append(list, agen.Word(ch))
+			size := SizeOfType(type_.Type)
+			if len(e.File) > 0 && size > 1 && !e.Raw {
+				c.checkText(e, type_)
 			}
 
-		default: list = append(list, c.evalExpr(expr))
+			list = append(list, encodeString(e.Value, e.Raw, size)...)
+
+		default:
+			value := c.evalExpr(expr)
+			c.checkFits(value, expr, type_, of)
+
+			list = append(list, value)
 		}
 	}
 
-	size := c.a.MemorySize()
-	addr := c.a.AddMemoryInt(list, n.Type.Type)
-	size  = c.a.MemorySize() - size
+	return list
+}
+
+// Value of a fill, padded fields are repeated whole
+func (c *Compiler) evalField(e node.Expr, type_ *node.Type, of string) []agen.Word {
+	if pad, ok := e.(*node.Pad); ok {
+		return c.evalPad(pad, type_, of)
+	}
+
+	value := c.evalExpr(e)
+	c.checkFits(value, e, type_, of)
+
+	return []agen.Word{value}
+}
+
+// Writes the value, then zero elements up to the length of the field
+func (c *Compiler) evalPad(n *node.Pad, type_ *node.Type, of string) []agen.Word {
+	length := c.evalExpr(n.Length)
+	if length > c.maxMemory() / SizeOfType(type_.Type) {
+		c.diags.Error(n.Length.GetToken().Where, "Pad length %v in %v is over the max memory " +
+		              "size of %v bytes", length, of, c.maxMemory())
+		return nil
+	}
+
+	// The field is a list of its own, starting at the element of the pad
+	if c.self != nil {
+		start := c.self.start
+		c.self.start = c.self.elem
+		defer func() { c.self.start = start }()
+	}
+
+	value := c.evalValues([]node.Expr{n.Value}, type_, of)
+	if agen.Word(len(value)) > length {
+		c.diags.Error(n.Value.GetToken().Where, "Padded value in %v is %v elements long, " +
+		              "the field is %v", of, len(value), length)
+	}
+
+	// Too long values are cut, so the fields after them keep their place
+	field := make([]agen.Word, length)
+	copy(field, value)
+	return field
+}
+
+// Strings are UTF-8 in byte elements, UTF-16 (with surrogate pairs) in 2 byte elements and code
+// points in wider ones, so every code point fits. Raw strings (bytes "...") are their UTF-8 bytes,
+// one per element of any size.
+func encodeString(s string, raw bool, size agen.Word) []agen.Word {
+	var list []agen.Word
+	switch {
+	case raw || size == 1:
+		list = make([]agen.Word, len(s))
+		for i := 0; i < len(s); i ++ {
+			list[i] = agen.Word(s[i])
+		}
+
+	case size == 2:
+		for _, unit := range utf16.Encode([]rune(s)) {
+			list = append(list, agen.Word(unit))
+		}
+
+	default:
+		for _, r := range s {
+			list = append(list, agen.Word(r))
+		}
+	}
+
+	return list
+}
+
+// Reports the first invalid UTF-8 sequence of a text file read with incstr, at its position in
+// the file
+func (c *Compiler) checkText(n *node.String, type_ *node.Type) {
+	if utf8.ValidString(n.Value) {
+		return
+	}
+
+	where := token.Where{Path: n.File, Row: 1, Col: 1, Len: 1}
+	for i := 0; i < len(n.Value); {
+		r, size := utf8.DecodeRuneInString(n.Value[i:])
+		if r == utf8.RuneError && size <= 1 {
+			end := strings.IndexByte(n.Value[i:], '\n')
+			if end == -1 {
+				end = len(n.Value) - i
+			}
+
+			where.Line = n.Value[i - (where.Col - 1):i + end]
+			break
+		}
+
+		if r == '\n' {
+			where.Row ++
+			where.Col = 1
+		} else {
+			where.Col += size
+		}
+
+		i += size
+	}
+
+	encoding := "code points"
+	if SizeOfType(type_.Type) == 2 {
+		encoding = "UTF-16"
+	}
+
+	c.diags.Error(where, "Invalid UTF-8 in a text file, '%v' elements are %v", type_.Token.Data,
+	              encoding)
+	c.diags.Note(n.Token.Where, "Read here")
+}
+
+// Warns about values that get truncated to the element size, both signed and unsigned values are
+// allowed
+func (c *Compiler) checkFits(value agen.Word, expr node.Expr, type_ *node.Type, of string) {
+	size := SizeOfType(type_.Type)
+	if size == agen.Word(agen.WordSize) || c.kindOf(expr) == floatKind ||
+	   (c.self != nil && c.self.repeated && c.self.truncated) {
+		return
+	}
 
-	c.vars[n.Name.Value] = Var{Token: n.Token, Addr: addr, Size: size}
+	// Qualified elements only take their own range, a negative value in 8 bytes can not be told
+	// apart from a large one, so those are not checked
+	bits := uint(size * 8)
+	v    := int64(value)
+	switch type_.Sign {
+	case node.Signed:
+		if v >= -(1 << (bits - 1)) && v < 1 << (bits - 1) {
+			return
+		}
+
+		c.diags.Error(expr.GetToken().Where, "Value %v is out of the range of the '%v' " +
+		              "elements of %v (%v to %v)", v, type_, of, -(1 << (bits - 1)),
+		              1 << (bits - 1) - 1)
+		return
+
+	case node.Unsigned:
+		if v >= 0 && v < 1 << bits {
+			return
+		}
+
+		c.diags.Error(expr.GetToken().Where, "Value %v is out of the range of the '%v' " +
+		              "elements of %v (0 to %v)", v, type_, of, 1 << bits - 1)
+		return
+	}
+
+	if v >= -(1 << (bits - 1)) && v < 1 << bits {
+		return
+	}
+
+	if c.warn("truncation", expr.GetToken().Where, "Value %v does not fit into the %v byte " +
+	          "'%v' elements of %v, it is truncated", int64(value), size, type_.Token.Data,
+	          of) && c.self != nil {
+		c.self.truncated = true
+	}
+}
+
+// Inline data is stored in the program as 'nop' instructions with the data as the argument, so
+// every instruction slot holds 8 bytes of data and the VM executes through it harmlessly. The
+// data is padded with zeros to fill the last slot.
+func (c *Compiler) dataSlots(n *node.Data) agen.Word {
+	var count agen.Word
+	for _, expr := range n.Values {
+		if c.kindOf(expr) == stringKind {
+			if !c.constant(expr) {
+				c.diags.Error(expr.GetToken().Where, "Strings in inline data can only use " +
+				              "literals and macros of them defined before it")
+				continue
+			}
+
+			expr = c.asString(expr)
+		}
+
+		switch e := expr.(type) {
+		case *node.Fill:
+			fillCount, ok := c.constCount(e.Count, "Fill count")
+			if !ok {
+				continue
+			}
+
+			field := agen.Word(1)
+			if pad, ok := e.Value.(*node.Pad); ok {
+				if field, ok = c.constCount(pad.Length, "Pad length"); !ok {
+					continue
+				}
+			}
+
+			count += fillCount * field
+
+		case *node.Pad:
+			if length, ok := c.constCount(e.Length, "Pad length"); ok {
+				count += length
+			}
+
+		case *node.String:
+			count += agen.Word(len(encodeString(e.Value, e.Raw, SizeOfType(n.Type.Type))))
+
+		default: count ++
+		}
+	}
+
+	size := count * SizeOfType(n.Type.Type)
+	slot := agen.Word(agen.WordSize)
+	return (size + slot - 1) / slot
+}
+
+// Data sizes decide the addresses of labels, so only what is known before them can be used
+func (c *Compiler) constCount(e node.Expr, what string) (agen.Word, bool) {
+	if !c.constant(e) {
+		c.diags.Error(e.GetToken().Where, "%v in inline data can only use literals and macros of " +
+		              "them defined before it", what)
+		return 0, false
+	} else if c.kindOf(e) == floatKind {
+		c.diags.Error(e.GetToken().Where, "%v is a float", what)
+		return 0, false
+	}
+
+	return c.evalExpr(e), true
+}
+
+func (c *Compiler) compileData(n *node.Data) {
+	c.checkFloats(n.Values, n.Type)
+	size := int(SizeOfType(n.Type.Type))
+
+	bytes := []byte{}
+	for _, v := range c.evalValues(n.Values, n.Type, "inline data") {
+		var buf [agen.WordSize]byte
+		binary.BigEndian.PutUint64(buf[:], uint64(v))
+
+		bytes = append(bytes, buf[agen.WordSize - size:]...)
+	}
+
+	for len(bytes) % agen.WordSize != 0 {
+		bytes = append(bytes, 0)
+	}
+
+	// Labels after the data got their addresses from its size before string macros defined after
+	// it were known
+	if agen.Word(len(bytes) / agen.WordSize) != c.slots[n] {
+		for _, expr := range n.Values {
+			if c.kindOf(expr) == stringKind {
+				c.diags.Error(expr.GetToken().Where, "Strings in inline data can only use " +
+				              "literals and macros of them defined before it")
+				return
+			}
+		}
+	}
+
+	for i := 0; i < len(bytes); i += agen.WordSize {
+		c.writeInst("nop", agen.Word(binary.BigEndian.Uint64(bytes[i:i + agen.WordSize])), true,
+		            n.Token.Where)
+	}
 }
 
 func (c *Compiler) compileInst(n *node.Inst) {
+	if inst := Insts[n.Name]; !inst.Supported() {
+		c.diags.Error(n.Token.Where, "Instruction '%v' needs AVM %v.%v, targeting %v.%v", n.Name,
+		              inst.MinMajor, inst.MinMinor, agen.VersionMajor, agen.VersionMinor)
+		return
+	}
+
 	if n.Arg == nil {
-		c.a.AddInst(n.Name)
+		c.writeInst(n.Name, 0, false, n.Token.Where)
+		return
+	}
+
+	where   := n.Arg.GetToken().Where
+	operand := Insts[n.Name].Operand
+	kind    := c.kindOf(n.Arg)
+	switch {
+	case (operand == IntOperand || operand == RelOperand) && kind == floatKind:
+		c.warn("operand-kind", where, "Float passed to '%v', which takes an integer (use 'bits' " +
+		       "if intended)", n.Name)
+
+	case operand == FloatOperand && kind == intKind:
+		c.warn("operand-kind", where, "Integer passed to '%v', which takes a float", n.Name)
+	}
+
+	if isJump(n.Name) {
+		c.checkCodeAddr(n.Name, n.Arg)
+	}
+
+	value := c.evalExpr(n.Arg)
+	if !isJump(n.Name) && (operand == AnyOperand || operand == IntOperand) {
+		c.checkBounds(n.Arg, value)
+	}
+
+	if operand == RelOperand {
+		// The argument is still written as an absolute address, the distance is encoded
+		if value >= c.programSize {
+			c.diags.Error(where, "Address %v passed to '%v' is outside of the program (%v " +
+			              "instructions)", value, n.Name, c.programSize)
+		}
+
+		delta := value - (c.instCount + 1)
+		if width := Insts[n.Name].Width; !fitsSigned(delta, width) {
+			c.diags.Error(where, "Address %v passed to '%v' is %v instructions away, which does " +
+			              "not fit into the %v bits it uses", value, n.Name, int64(delta), width)
+		}
+
+		value = delta
+	} else if width := Insts[n.Name].Width; !fitsWidth(value, width) && kind != floatKind {
+		c.warn("operand-width", where, "Argument %v of '%v' does not fit into the %v bits it " +
+		       "uses (mask it with '(& X 0x%X)' if intended)", int64(value), n.Name, width,
+		       uint64(1) << width - 1)
+	}
+
+	c.writeInst(n.Name, value, true, where)
+}
+
+// If the value fits into the bits, as an unsigned or a sign extended number
+func fitsWidth(value agen.Word, width int) bool {
+	if width == 0 || width >= agen.WordSize * 8 {
+		return true
+	}
+
+	signed := int64(value) >> (width - 1)
+	return value >> width == 0 || signed == -1
+}
+
+// If the value fits into the bits as a sign extended number
+func fitsSigned(value agen.Word, width int) bool {
+	if width == 0 || width >= agen.WordSize * 8 {
+		return true
+	}
+
+	signed := int64(value) >> (width - 1)
+	return signed == 0 || signed == -1
+}
+
+// Warns about variables used as code addresses, a '(bits X)' cast silences it
+func (c *Compiler) checkCodeAddr(name string, e node.Expr) {
+	switch n := e.(type) {
+	case *node.Id:
+		if var_, ok := c.vars[n.Value]; ok {
+			if c.warn("code-addr", n.Token.Where, "'%v' takes a code address, but '%v' is a " +
+			          "variable (use '(bits %v)' if intended)", name, n.Value, n.Value) {
+				c.diags.Note(var_.Token.Where, "'%v' defined here", n.Value)
+			}
+		}
+
+	case *node.BinOp:
+		for _, arg := range n.Args {
+			c.checkCodeAddr(name, arg)
+		}
+	}
+}
+
+func (c *Compiler) writeInst(name string, operand agen.Word, hasArg bool, where token.Where) {
+	if hasArg {
+		c.a.AddInstWith(name, operand)
 	} else {
-		c.a.AddInstWith(n.Name, c.evalExpr(n.Arg))
+		c.a.AddInst(name)
+	}
+
+	if c.opts.OnInst != nil {
+		c.opts.OnInst(c.instCount, Insts[name].Op, operand, where)
+	}
+
+	c.instCount ++
+}
+
+func (c *Compiler) kindOf(e node.Expr) valueKind {
+	switch n := e.(type) {
+	case *node.Int, *node.SizeOf, *node.Bits, *node.StrLen, *node.Defined, *node.Self:
+		return intKind
+
+	case *node.Float:                return floatKind
+	case *node.String, *node.StrCat: return stringKind
+
+	case *node.Id:
+		if macro, ok := c.macros[n.Value]; ok {
+			return macro.kind
+		} else if _, ok := c.labels[n.Value]; ok {
+			return intKind
+		} else if _, ok := c.vars[n.Value]; ok {
+			return intKind
+		} else if c.isDefsym(n.Value) {
+			return intKind
+		}
+
+	case *node.BinOp:
+		switch n.Op {
+		case "==", "!=", "<", "<=", ">", ">=", "&&", "||", "!": return intKind
+		}
+
+		kind := c.kindOf(n.Args[0])
+		for _, arg := range n.Args[1:] {
+			if c.kindOf(arg) != kind {
+				return unknownKind
+			}
+		}
+
+		return kind
+	}
+
+	return unknownKind
+}
+
+// Floats are 8 bytes, so they do not fit into smaller elements
+func (c *Compiler) checkFloats(values []node.Expr, type_ *node.Type) {
+	if SizeOfType(type_.Type) == agen.Word(agen.WordSize) {
+		return
+	}
+
+	for _, expr := range values {
+		if fill, ok := expr.(*node.Fill); ok {
+			expr = fill.Value
+		}
+
+		if pad, ok := expr.(*node.Pad); ok {
+			expr = pad.Value
+		}
+
+		if c.kindOf(expr) == floatKind {
+			c.diags.Error(expr.GetToken().Where, "Float in a list of '%v' elements, floats need " +
+			              "8 bytes (f64)", type_.Token.Data)
+		}
+	}
+}
+
+// Strings take an element per character, which in a list of numbers of wider elements is rarely
+// what was meant. Lists of only strings are wide texts and fine.
+func (c *Compiler) checkMixed(n *node.Let) {
+	size := SizeOfType(n.Type.Type)
+	if size == 1 {
+		return
+	}
+
+	var str node.Expr
+	numbers := false
+	for _, expr := range n.Values {
+		if fill, ok := expr.(*node.Fill); ok {
+			expr = fill.Value
+		}
+
+		if pad, ok := expr.(*node.Pad); ok {
+			expr = pad.Value
+		}
+
+		if c.kindOf(expr) != stringKind {
+			numbers = true
+		} else if str == nil {
+			str = expr
+		}
+	}
+
+	if str == nil || !numbers {
+		return
+	}
+
+	if c.warn("mixed-data", str.GetToken().Where, "String in '%v' among numbers takes an " +
+	          "element of %v bytes per character", n.Name.Value, size) {
+		c.diags.Note(n.Type.Token.Where, "Elements are '%v', put the text into a separate char " +
+		             "variable if it was meant as bytes", n.Type.Token.Data)
 	}
 }
 
@@ -198,64 +1460,188 @@ func (c *Compiler) evalExpr(e node.Expr) agen.Word {
 	case *node.Float: return agen.Word(math.Float64bits(n.Value))
 	case *node.Id:
 		if label, ok := c.labels[n.Value]; ok {
+			c.ref(n.Token)
 			return label.Addr
 		} else if var_, ok := c.vars[n.Value]; ok {
+			c.ref(n.Token)
 			return var_.Addr
 		} else if macro, ok := c.macros[n.Value]; ok {
+			c.ref(n.Token)
+			if macro.kind == stringKind {
+				c.diags.Error(n.Token.Where, "String macro '%v' in constant expression, it can " +
+				              "only be used as data or with strlen and strcat", n.Value)
+			}
+
 			return macro.Value
+		} else if sym, ok := c.defsyms[n.Value]; ok {
+			c.ref(n.Token)
+			return sym.Value
 		} else {
-			goerror.Error(n.Token.Where, "Undefined identifier '%v'", n.Value)
+			c.diags.Error(n.Token.Where, "Undefined identifier '%v'", n.Value)
 		}
 
-	case *node.BinOp:  return c.evalBinOp(n)
-	case *node.SizeOf: return c.evalSizeOf(n)
+	case *node.BinOp:   return c.evalBinOp(n)
+	case *node.SizeOf:  return c.evalSizeOf(n)
+	case *node.Defined: return c.evalDefined(n)
+	case *node.Self:    return c.evalSelf(n)
+	case *node.Bits:    return c.evalExpr(n.Value)
+	case *node.StrLen:  return agen.Word(len(c.evalString(n.Value)))
 
-	case *node.Type:   goerror.Error(n.Token.Where, "Unexpected type in constant expression")
-	case *node.String: goerror.Error(n.Token.Where, "Unexpected string in constant expression")
-	case *node.Fill:   goerror.Error(n.Token.Where, "Unexpected fill in constant expression")
-	default: goerror.Error(n.GetToken().Where, "Unexpected %v in constant expression", n.GetToken())
+	case *node.Type:   c.diags.Error(n.Token.Where, "Unexpected type in constant expression")
+	case *node.String: c.diags.Error(n.Token.Where, "Unexpected string in constant expression")
+	case *node.StrCat: c.diags.Error(n.Token.Where, "Unexpected string in constant expression")
+	case *node.Fill:   c.diags.Error(n.Token.Where, "Unexpected fill in constant expression")
+	case *node.Pad:    c.diags.Error(n.Token.Where, "Unexpected pad in constant expression")
+	default: c.diags.Error(n.GetToken().Where, "Unexpected %v in constant expression", n.GetToken())
 	}
 
 	return 0;
 }
 
+// String literals, joined strings and string macros as a string, nil for other values
+func (c *Compiler) asString(e node.Expr) *node.String {
+	switch n := e.(type) {
+	case *node.String: return n
+	case *node.StrCat:
+		s := &node.String{Token: n.Token}
+		for _, arg := range n.Args {
+			s.Value += c.evalString(arg)
+		}
+
+		return s
+
+	case *node.Id:
+		if macro, ok := c.macros[n.Value]; ok && macro.kind == stringKind {
+			c.ref(n.Token)
+			return &node.String{Token: n.Token, Value: macro.Text}
+		}
+	}
+
+	return nil
+}
+
+func (c *Compiler) evalString(e node.Expr) string {
+	if s := c.asString(e); s != nil {
+		return s.Value
+	}
+
+	c.diags.Error(e.GetToken().Where, "Expected a string, got '%v'", node.Source(e))
+	return ""
+}
+
+func SizeOfType(type_ agen.Type) agen.Word {
+	switch type_ {
+	case agen.I8:  return 1
+	case agen.I16: return 2
+	case agen.I32: return 4
+	case agen.I64: return 8
+
+	default: panic("Unreachable")
+	}
+}
+
+func (c *Compiler) evalSelf(n *node.Self) agen.Word {
+	if c.self == nil {
+		c.diags.Error(n.Token.Where, "'%v' outside of the values of a let", n)
+		return 0
+	} else if n.Base {
+		return c.self.base
+	}
+
+	return c.self.elem
+}
+
+// Macros count wherever they are in the program, so the result does not depend on the order the
+// macros are evaluated in
+func (c *Compiler) evalDefined(n *node.Defined) agen.Word {
+	return boolToWord(c.named[n.Id.Value] || c.isDefsym(n.Id.Value))
+}
+
 func (c *Compiler) evalSizeOf(n *node.SizeOf) agen.Word {
 	if n.Id == nil {
-		switch n.Type.Type {
-		case agen.I8:  return 1
-		case agen.I16: return 2
-		case agen.I32: return 4
-		case agen.I64: return 8
-		}
+		return SizeOfType(n.Type.Type)
 	} else {
 		if _, ok := c.labels[n.Id.Value]; ok {
-			goerror.Error(n.Token.Where, "Cannot get size of label '%v'", n.Id.Value)
+			c.diags.Error(n.Token.Where, "Cannot get size of label '%v'", n.Id.Value)
 		} else if var_, ok := c.vars[n.Id.Value]; ok {
+			c.ref(n.Id.Token)
 			return var_.Size
 		} else if _, ok := c.macros[n.Id.Value]; ok {
-			goerror.Error(n.Token.Where, "Cannot get size of macro '%v'", n.Id.Value)
+			c.diags.Error(n.Token.Where, "Cannot get size of macro '%v'", n.Id.Value)
+		} else if c.isDefsym(n.Id.Value) {
+			c.diags.Error(n.Token.Where, "Cannot get size of symbol '%v'", n.Id.Value)
 		} else {
-			goerror.Error(n.Token.Where, "Undefined identifier '%v'", n.Id.Value)
+			c.diags.Error(n.Token.Where, "Undefined identifier '%v'", n.Id.Value)
 		}
 	}
 
 	return 0
 }
 
+func boolToWord(b bool) agen.Word {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// Comparisons are signed, and '&&' and '||' stop at the first argument that decides the result,
+// so the rest is not evaluated and can not fail
 func (c *Compiler) evalBinOp(n *node.BinOp) agen.Word {
+	switch n.Op {
+	case "!": return boolToWord(c.evalExpr(n.Args[0]) == 0)
+
+	case "&&", "||":
+		for _, expr := range n.Args {
+			if (c.evalExpr(expr) != 0) == (n.Op == "||") {
+				return boolToWord(n.Op == "||")
+			}
+		}
+
+		return boolToWord(n.Op == "&&")
+
+	case "==", "!=", "<", "<=", ">", ">=":
+		a, b := int64(c.evalExpr(n.Args[0])), int64(c.evalExpr(n.Args[1]))
+		switch n.Op {
+		case "==": return boolToWord(a == b)
+		case "!=": return boolToWord(a != b)
+		case "<":  return boolToWord(a <  b)
+		case "<=": return boolToWord(a <= b)
+		case ">":  return boolToWord(a >  b)
+		case ">=": return boolToWord(a >= b)
+		}
+	}
+
 	result := c.evalExpr(n.Args[0])
 	for i, expr := range n.Args {
 		if i == 0 {
 			continue
 		}
 
+		value := c.evalExpr(expr)
 		switch n.Op {
-		case "+": result += c.evalExpr(expr)
-		case "-": result -= c.evalExpr(expr)
-		case "*": result *= c.evalExpr(expr)
-		case "/": result /= c.evalExpr(expr)
-		case "%": result %= c.evalExpr(expr)
-		case "^": result  = agen.Word(math.Pow(float64(result), float64(c.evalExpr(expr))))
+		case "+": result += value
+		case "-": result -= value
+		case "*": result *= value
+		case "^": result  = agen.Word(math.Pow(float64(result), float64(value)))
+
+		case "&":  result &= value
+		case "|":  result |= value
+		case ">>": result >>= value
+		case "<<": result <<= value
+
+		case "/", "%":
+			if value == 0 {
+				c.diags.Error(expr.GetToken().Where, "Division by zero in constant expression")
+				return 0
+			}
+
+			if n.Op == "/" {
+				result /= value
+			} else {
+				result %= value
+			}
 		}
 	}
 
@@ -0,0 +1,123 @@
+package compiler_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/avm-collection/anasm/anasmtest"
+	"github.com/avm-collection/anasm/internal/compiler"
+)
+
+var concurrent = []struct {
+	src string
+	ok  bool
+}{
+	{"let msg char = \"hello\", 0\n.entry\n\tpsh msg\n\tpsh 1\n\thlt\n", true},
+	{".entry\n\tpsh 1\n\tpsh 2\n\tadd\n\thlt\n",                          true},
+	{".entry\n\tpsh undefined\n\thlt\n",                                  false},
+	{"let x = (pad 4 1\n.entry\n\thlt\n",                                  false},
+	{"mac N = 4\nlet buf byte = 0 .. N\n.entry\n\tpsh buf\n\thlt\n",      true},
+}
+
+// Compilers share no state, so failing ones running at the same time do not fail the others, and
+// every compiler writes the same executable as when running alone. Run with -race.
+func TestConcurrent(t *testing.T) {
+	want := make([][]byte, len(concurrent))
+	for i, tt := range concurrent {
+		var err error
+		if want[i], err = anasmtest.AssembleBytes(tt.src); (err == nil) != tt.ok {
+			t.Fatalf("Program %v assembled: %v, expected %v", i, err == nil, tt.ok)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for run := 0; run < 8; run ++ {
+		for i, tt := range concurrent {
+			wg.Add(1)
+			go func(i int, src string) {
+				defer wg.Done()
+
+				// The instruction table is read while compiling
+				_ = fmt.Sprint(compiler.Insts["psh"])
+
+				if got, _ := anasmtest.AssembleBytes(src); !bytes.Equal(got, want[i]) {
+					t.Errorf("Program %v assembled differently in parallel", i)
+				}
+			}(i, tt.src)
+		}
+	}
+
+	wg.Wait()
+}
+
+// A reset compiler assembles like a new one, after errors too
+func TestReset(t *testing.T) {
+	c := compiler.New(concurrent[2].src, "<test>", compiler.Options{})
+	if c.Compile() {
+		t.Fatal("Program with an undefined name assembled")
+	}
+
+	c.Reset(concurrent[0].src, "<test>")
+	if !c.Compile() {
+		t.Fatal("Program failed to assemble after a reset")
+	}
+
+	got, err := c.Exec(false)
+	if err != nil {
+		t.Fatal(err)
+	} else if want := anasmtest.MustAssembleBytes(t, concurrent[0].src); !bytes.Equal(got, want) {
+		t.Errorf("Reset compiler assembled differently")
+	}
+}
+
+// Every input gets its own executable or diagnostics, the same as assembling it alone
+func TestCompileAll(t *testing.T) {
+	inputs := []compiler.Input{}
+	for run := 0; run < 4; run ++ {
+		for i, tt := range concurrent {
+			path  := fmt.Sprintf("%v.anasm", i)
+			inputs = append(inputs, compiler.Input{Source: tt.src, Path: path})
+		}
+	}
+
+	results := compiler.CompileAll(context.Background(), inputs, compiler.Options{})
+	if len(results) != len(inputs) {
+		t.Fatalf("Got %v results for %v inputs", len(results), len(inputs))
+	}
+
+	for i, result := range results {
+		tt := concurrent[i % len(concurrent)]
+		if result.Err != nil {
+			t.Fatalf("Input %v: %v", i, result.Err)
+		} else if result.OK() != tt.ok {
+			t.Fatalf("Input %v assembled: %v, expected %v", i, result.OK(), tt.ok)
+		} else if !tt.ok {
+			if len(result.Diags) == 0 || result.Diags[0].Where.Path != inputs[i].Path {
+				t.Errorf("Input %v failed without an error of its own: %+v", i, result.Diags)
+			}
+
+			continue
+		}
+
+		if want := anasmtest.MustAssembleBytes(t, tt.src); !bytes.Equal(result.Exe, want) {
+			t.Errorf("Input %v assembled differently in a batch", i)
+		}
+	}
+}
+
+// Nothing is assembled after the context is cancelled
+func TestCompileAllCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	inputs := []compiler.Input{{Source: concurrent[0].src, Path: "a.anasm"},
+	                           {Source: concurrent[1].src, Path: "b.anasm"}}
+	for i, result := range compiler.CompileAll(ctx, inputs, compiler.Options{}) {
+		if result.Err != context.Canceled || result.OK() {
+			t.Errorf("Input %v was assembled after cancelling (error %v)", i, result.Err)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package compiler
+
+import (
+	"github.com/avm-collection/anasm/internal/node"
+	"github.com/avm-collection/anasm/internal/parser"
+)
+
+// Fallthrough check. Every label is taken as the start of a routine ending with a jump, a return
+// or a halt, so code running off the end of one into the next label is reported. Falling through
+// on purpose is marked with a 'fallthrough' comment before the next label (see
+// parser.FallthroughComment). Labels right after each other are aliases and never reported.
+
+func (c *Compiler) checkFallthrough() {
+	var label *node.Label // Routine the instructions are in
+	var last  *node.Inst  // Last instruction of it
+	for _, s := range c.program.List {
+		switch n := s.(type) {
+		case *node.Inst: last = n
+
+		case *node.Label:
+			if label != nil && last != nil && !endsFlow(last.Name) && !n.Fallthrough {
+				if c.warn("fallthrough", n.Token.Where, "Code of '%v' falls through into '%v'",
+				          label.Name.Value, n.Name.Value) {
+					c.diags.Note(last.Token.Where, "Last instruction of '%v', mark the fall " +
+					             "through with a '%v' comment if intended", label.Name.Value,
+					             parser.FallthroughComment)
+				}
+			}
+
+			label, last = n, nil
+		}
+	}
+}
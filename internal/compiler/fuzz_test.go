@@ -0,0 +1,44 @@
+package compiler_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/avm-collection/anasm/internal/compiler"
+	"github.com/avm-collection/anasm/internal/node"
+)
+
+// Malformed input has to be reported as errors, never crash the lexer, the parser, -E or the
+// compiler. Inputs that crashed once are in testdata/fuzz/FuzzCompile, the tests are seeds too.
+func FuzzCompile(f *testing.F) {
+	paths, err := filepath.Glob(filepath.Join("..", "..", "tests", "*.anasm"))
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+
+		f.Add(string(data))
+	}
+
+	f.Fuzz(func(t *testing.T, src string) {
+		// Reading devices can block
+		if strings.Contains(src, "/dev/") {
+			t.Skip()
+		}
+
+		c := compiler.New(src, "<fuzz>", compiler.Options{})
+		program, _ := c.Parse()
+		for _, s := range program.List {
+			node.Source(s)
+		}
+
+		c.Emit(program)
+	})
+}
@@ -0,0 +1,184 @@
+package compiler
+
+import (
+	"github.com/avm-collection/anasm/internal/node"
+)
+
+// Dead code elimination. Instructions are walked from the entry point following the control
+// flow, anything not visited is removed along with data that is never referenced. It has to stay
+// conservative, so if a jump takes an address that is not a label, nothing is removed.
+
+// Instructions taking a code address, including ones with relative operands loaded with -insts.
+// Those are not known to always jump, so the code after them is taken to run too.
+func isJump(name string) bool {
+	switch name {
+	case "jmp", "jnz", "cal": return true
+
+	default: return Insts[name].Operand == RelOperand
+	}
+}
+
+func endsFlow(name string) bool {
+	switch name {
+	case "jmp", "ret", "hlt": return true
+
+	default: return false
+	}
+}
+
+// Label name -> statement index
+func (c *Compiler) labelIndices() map[string]int {
+	labels := make(map[string]int)
+	for i, s := range c.program.List {
+		if n, ok := s.(*node.Label); ok {
+			labels[n.Name.Value] = i
+		}
+	}
+
+	return labels
+}
+
+// Statement indices of the labels whose address is taken by data, they are possible jump targets
+func (c *Compiler) addressTaken(labels map[string]int) (taken []int) {
+	add := func(id *node.Id) {
+		if i, ok := labels[id.Value]; ok {
+			taken = append(taken, i)
+		}
+	}
+
+	for _, s := range c.program.List {
+		switch n := s.(type) {
+		case *node.Macro: node.WalkIds(n.Value, add)
+
+		case *node.Let:
+			for _, val := range n.Values {
+				node.WalkIds(val, add)
+			}
+
+		case *node.Data:
+			for _, val := range n.Values {
+				node.WalkIds(val, add)
+			}
+		}
+	}
+
+	return
+}
+
+func (c *Compiler) gcCode() {
+	labels := c.labelIndices()
+
+	entry, ok := c.entryIndex(labels)
+	if !ok {
+		return // Reported by the compiler later, or an entry address that removing code would move
+	}
+
+	reachable := make([]bool, len(c.program.List))
+	work      := append([]int{entry}, c.addressTaken(labels)...)
+
+	for len(work) > 0 {
+		i   := work[len(work) - 1]
+		work = work[:len(work) - 1]
+
+		for ; i < len(c.program.List); i ++ {
+			n, ok := c.program.List[i].(*node.Inst)
+			if !ok {
+				continue
+			} else if reachable[i] {
+				break
+			}
+
+			reachable[i] = true
+
+			if isJump(n.Name) {
+				id, ok := n.Arg.(*node.Id)
+				if !ok {
+					c.diags.Note(n.Token.Where, "Dead code elimination disabled, '%v' jumps to " +
+					             "an address not derived from a label", n.Name)
+					return
+				} else if _, ok := labels[id.Value]; !ok {
+					c.diags.Note(n.Token.Where, "Dead code elimination disabled, '%v' is not " +
+					             "a label", id.Value)
+					return
+				}
+			}
+
+			if n.Arg != nil {
+				node.WalkIds(n.Arg, func(id *node.Id) {
+					if i, ok := labels[id.Value]; ok {
+						work = append(work, i)
+					}
+				})
+			}
+
+			if endsFlow(n.Name) {
+				break
+			}
+		}
+	}
+
+	// Collect the data referenced by the remaining code, lets can reference each other so
+	// repeat until nothing new is found
+	referenced := make(map[string]bool)
+	for i, s := range c.program.List {
+		switch n := s.(type) {
+		case *node.Inst:
+			if reachable[i] && n.Arg != nil {
+				node.WalkIds(n.Arg, func(id *node.Id) {referenced[id.Value] = true})
+			}
+
+		case *node.Macro:  node.WalkIds(n.Value, func(id *node.Id) {referenced[id.Value] = true})
+		case *node.Assert: node.WalkIds(n.Cond,  func(id *node.Id) {referenced[id.Value] = true})
+		case *node.Org:    node.WalkIds(n.Addr,  func(id *node.Id) {referenced[id.Value] = true})
+
+		case *node.Data:
+			for _, val := range n.Values {
+				node.WalkIds(val, func(id *node.Id) {referenced[id.Value] = true})
+			}
+		}
+	}
+
+	for changed := true; changed; {
+		changed = false
+
+		for _, s := range c.program.List {
+			n, ok := s.(*node.Let)
+			if !ok || !referenced[n.Name.Value] {
+				continue
+			}
+
+			for _, val := range n.Values {
+				node.WalkIds(val, func(id *node.Id) {
+					if !referenced[id.Value] {
+						referenced[id.Value] = true
+						changed              = true
+					}
+				})
+			}
+		}
+	}
+
+	list := []node.Statement{}
+	for i, s := range c.program.List {
+		switch n := s.(type) {
+		case *node.Inst:
+			if !reachable[i] {
+				continue
+			}
+
+		case *node.Let:
+			if !referenced[n.Name.Value] {
+				continue
+			}
+
+		case *node.Embed:
+			if !referenced[n.Name.Value] {
+				continue
+			}
+		}
+
+		list = append(list, s)
+	}
+
+	c.program.List = list
+}
@@ -0,0 +1,33 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/avm-collection/anasm/internal/executable"
+)
+
+// Instructions with relative operands are jumps, so a numeric target disables the removal of
+// dead code like it does for 'jmp'
+func TestGCRelJump(t *testing.T) {
+	err := loadInsts(t, `[{"name": "rjs", "op": 162, "arg": true, "operand": "rel"}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := New(".entry\n\trjs 2\n\thlt\n\tpsh 7\n\tprt\n\thlt\n", "<test>", Options{GCCode: true})
+	if !c.Compile() {
+		t.Fatal("Failed to assemble")
+	}
+
+	data, err := c.Exec(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exe, err := executable.Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	} else if exe.InstCount() != 5 {
+		t.Errorf("Got %v instructions, expected all 5", exe.InstCount())
+	}
+}
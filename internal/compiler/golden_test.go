@@ -0,0 +1,151 @@
+package compiler_test
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/avm-collection/anasm/internal/compiler"
+	"github.com/avm-collection/anasm/internal/diag"
+	"github.com/avm-collection/anasm/internal/executable"
+)
+
+// Every program of testdata/golden is compiled, and its diagnostics and executable are compared
+// to the .golden file next to it, so changes of the output show in the diff of the goldens.
+// Regenerate them with
+//
+//   go test ./internal/compiler -run Golden -update
+
+var update = flag.Bool("update", false, "Rewrite the golden files of testdata/golden")
+
+func renderWhere(b *strings.Builder, kind string, d diag.Diagnostic) {
+	if d.Simple {
+		fmt.Fprintf(b, "%v: %v\n", kind, d.Msg)
+		return
+	}
+
+	fmt.Fprintf(b, "%v: %v:%v:%v: %v\n", kind, filepath.ToSlash(d.Where.Path), d.Where.Row,
+	            d.Where.Col, d.Msg)
+}
+
+// Diagnostics in the order they print, with their notes
+func renderDiagnostics(b *strings.Builder, diags []diag.Diagnostic) {
+	for _, d := range diags {
+		if d.Warning {
+			renderWhere(b, "warning", d)
+		} else {
+			renderWhere(b, "error", d)
+		}
+
+		for _, n := range d.Notes {
+			renderWhere(b, "\tnote", diag.Diagnostic{Where: n.Where, Msg: n.Msg})
+		}
+
+		for from := d.Where.IncludedFrom; from != nil && !d.Simple; from = from.IncludedFrom {
+			renderWhere(b, "\tnote", diag.Diagnostic{Where: *from, Msg: "Included from here"})
+		}
+	}
+}
+
+// Hex dump of the executable. The version bytes change with every release, so they are zeroed.
+func renderExec(b *strings.Builder, data []byte) error {
+	exe, err := executable.Parse(data)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(b, "entry %v, %v bytes of memory, %v instructions\n",
+	            int64(exe.EntryPoint), len(exe.Memory), exe.InstCount())
+
+	data    = append([]byte{}, data...)
+	version := len(exe.Shebang) + len(executable.Magic)
+	copy(data[version:version + len(exe.Version)], make([]byte, len(exe.Version)))
+
+	for i := 0; i < len(data); i += 16 {
+		end := i + 16
+		if end > len(data) {
+			end = len(data)
+		}
+
+		fmt.Fprintf(b, "%08x  % x\n", i, data[i:end])
+	}
+
+	return nil
+}
+
+// Compiles the program at the path and renders what it outputs
+func render(t *testing.T, path string) string {
+	t.Helper()
+
+	src, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c     := compiler.New(string(src), filepath.ToSlash(path), compiler.Options{})
+	diags := c.Check()
+
+	var b strings.Builder
+	b.WriteString("-- diagnostics --\n")
+	renderDiagnostics(&b, diags)
+
+	for _, d := range diags {
+		if !d.Warning {
+			return b.String()
+		}
+	}
+
+	data, err := c.Exec(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.WriteString("-- executable --\n")
+	if err := renderExec(&b, data); err != nil {
+		t.Fatal(err)
+	}
+
+	return b.String()
+}
+
+func TestGolden(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "golden", "*.anasm"))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(paths) == 0 {
+		t.Fatal("No programs in testdata/golden")
+	}
+
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			got := render(t, path)
+
+			// The order of the output may not depend on map iteration or scheduling
+			if again := render(t, path); again != got {
+				t.Fatalf("Output differs between two compilations:\n%v\n%v", got, again)
+			}
+
+			golden := strings.TrimSuffix(path, ".anasm") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+
+				return
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create it)", err)
+			}
+
+			if !bytes.Equal([]byte(got), want) {
+				t.Errorf("Output differs from %v (run with -update to accept it):\n%v", golden, got)
+			}
+		})
+	}
+}
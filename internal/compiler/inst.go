@@ -1,107 +1,400 @@
 package compiler
 
+import (
+	"os"
+	"fmt"
+	"sort"
+	"sync"
+	"encoding/json"
+
+	"github.com/avm-collection/agen"
+
+	"github.com/avm-collection/anasm/internal/lexer"
+)
+
+// How an instruction interprets its argument
+type Operand int
+const (
+	AnyOperand = Operand(iota) // Raw 8 bytes
+	IntOperand
+	FloatOperand
+	RelOperand // Code address, encoded relative to the next instruction
+)
+
+func (o Operand) String() string {
+	switch o {
+	case AnyOperand:   return "any"
+	case IntOperand:   return "integer"
+	case FloatOperand: return "float"
+	case RelOperand:   return "relative code address"
+
+	default: panic("Unreachable")
+	}
+}
+
 type Inst struct {
-	Op     byte
-	HasArg bool
+	Op      byte
+	HasArg  bool
+	Operand Operand
+	Width   int // Bits of the argument the instruction uses, 0 for all of them
+
+	MinMajor, MinMinor byte // Minimum AVM version the instruction needs, 0.0 for built-ins
+
+	// Stack effect, values popped and pushed. Pops is -1 if it is not known.
+	Pops, Pushes int
+
+	// Documentation
+	Category string
+	Desc     string
+	Arg      string // Meaning of the argument
+	Example  string // Usage, only for instructions with an argument
+}
+
+// Instruction categories in the order they are documented in
+var Categories = []string{
+	"Stack", "Arithmetic", "Float arithmetic", "Control flow", "Logic", "Comparison",
+	"Unsigned comparison", "Float comparison", "Memory", "Files", "Bitwise", "Libraries", "Debug",
+	"Other",
 }
 
 var (
 	Insts = map[string]Inst{
-		"nop": Inst{Op: 0x00},
-
-		"psh": Inst{Op: 0x10, HasArg: true},
-		"pop": Inst{Op: 0x11},
-
-		"add": Inst{Op: 0x20},
-		"sub": Inst{Op: 0x21},
-
-		"mul": Inst{Op: 0x22},
-		"div": Inst{Op: 0x23},
-		"mod": Inst{Op: 0x24},
-
-		"inc": Inst{Op: 0x25},
-		"dec": Inst{Op: 0x26},
-
-		"fad": Inst{Op: 0x27},
-		"fsb": Inst{Op: 0x28},
-
-		"fmu": Inst{Op: 0x29},
-		"fdi": Inst{Op: 0x2a},
-
-		"fin": Inst{Op: 0x2b},
-		"fde": Inst{Op: 0x2c},
-
-		"neg": Inst{Op: 0x2d},
-		"not": Inst{Op: 0x2e},
-
-		"jmp": Inst{Op: 0x30, HasArg: true},
-		"jnz": Inst{Op: 0x31, HasArg: true},
-
-		"cal": Inst{Op: 0x38, HasArg: true},
-		"ret": Inst{Op: 0x39},
-
-		"and": Inst{Op: 0x46},
-		"orr": Inst{Op: 0x47},
-
-		"equ": Inst{Op: 0x32},
-		"neq": Inst{Op: 0x33},
-		"grt": Inst{Op: 0x34},
-		"geq": Inst{Op: 0x35},
-		"les": Inst{Op: 0x36},
-		"leq": Inst{Op: 0x37},
-
-		"ueq": Inst{Op: 0x3a},
-		"une": Inst{Op: 0x3b},
-		"ugr": Inst{Op: 0x3c},
-		"ugq": Inst{Op: 0x3d},
-		"ule": Inst{Op: 0x3e},
-		"ulq": Inst{Op: 0x3f},
-
-		"feq": Inst{Op: 0x40},
-		"fne": Inst{Op: 0x41},
-		"fgr": Inst{Op: 0x42},
-		"fgq": Inst{Op: 0x43},
-		"fle": Inst{Op: 0x44},
-		"flq": Inst{Op: 0x45},
-
-		"dup": Inst{Op: 0x50, HasArg: true},
-		"swp": Inst{Op: 0x51, HasArg: true},
-		"emp": Inst{Op: 0x52},
-		"set": Inst{Op: 0x53},
-		"cpy": Inst{Op: 0x54},
-
-		"r08": Inst{Op: 0x60},
-		"r16": Inst{Op: 0x61},
-		"r32": Inst{Op: 0x62},
-		"r64": Inst{Op: 0x63},
-
-		"w08": Inst{Op: 0x64},
-		"w16": Inst{Op: 0x65},
-		"w32": Inst{Op: 0x66},
-		"w64": Inst{Op: 0x67},
-
-		"ope": Inst{Op: 0x70},
-		"clo": Inst{Op: 0x71},
-		"wrf": Inst{Op: 0x72},
-		"rdf": Inst{Op: 0x73},
-		"szf": Inst{Op: 0x74},
-		"flu": Inst{Op: 0x75},
-
-		"ban": Inst{Op: 0x80},
-		"bor": Inst{Op: 0x81},
-		"bsr": Inst{Op: 0x82},
-		"bsl": Inst{Op: 0x83},
-
-		"lol": Inst{Op: 0x90},
-		"cll": Inst{Op: 0x91},
-		"llf": Inst{Op: 0x92},
-		"ulf": Inst{Op: 0x93},
-		"clf": Inst{Op: 0x94},
-
-		"dmp": Inst{Op: 0xF0},
-		"prt": Inst{Op: 0xF1},
-		"fpr": Inst{Op: 0xF2},
-
-		"hlt": Inst{Op: 0xFF},
+		"nop": Inst{Op: 0x00, Category: "Stack", Desc: "Do nothing"},
+
+		"psh": Inst{Op: 0x10, HasArg: true, Pushes: 1, Category: "Stack", Desc: "Push the argument",
+		            Arg: "Value to push", Example: "psh 5"},
+		"pop": Inst{Op: 0x11, Pops: 1, Category: "Stack", Desc: "Pop the top value"},
+
+		"add": Inst{Op: 0x20, Pops: 2, Pushes: 1, Category: "Arithmetic",
+		            Desc: "Pop two integers, push their sum"},
+		"sub": Inst{Op: 0x21, Pops: 2, Pushes: 1, Category: "Arithmetic",
+		            Desc: "Pop two integers, push the second minus the top"},
+
+		"mul": Inst{Op: 0x22, Pops: 2, Pushes: 1, Category: "Arithmetic",
+		            Desc: "Pop two integers, push their product"},
+		"div": Inst{Op: 0x23, Pops: 2, Pushes: 1, Category: "Arithmetic",
+		            Desc: "Pop two integers, push the second divided by the top"},
+		"mod": Inst{Op: 0x24, Pops: 2, Pushes: 1, Category: "Arithmetic",
+		            Desc: "Pop two integers, push the remainder of their division"},
+
+		"inc": Inst{Op: 0x25, Pops: 1, Pushes: 1, Category: "Arithmetic",
+		            Desc: "Increment the top integer"},
+		"dec": Inst{Op: 0x26, Pops: 1, Pushes: 1, Category: "Arithmetic",
+		            Desc: "Decrement the top integer"},
+
+		"fad": Inst{Op: 0x27, Pops: 2, Pushes: 1, Category: "Float arithmetic",
+		            Desc: "Pop two floats, push their sum"},
+		"fsb": Inst{Op: 0x28, Pops: 2, Pushes: 1, Category: "Float arithmetic",
+		            Desc: "Pop two floats, push the second minus the top"},
+
+		"fmu": Inst{Op: 0x29, Pops: 2, Pushes: 1, Category: "Float arithmetic",
+		            Desc: "Pop two floats, push their product"},
+		"fdi": Inst{Op: 0x2a, Pops: 2, Pushes: 1, Category: "Float arithmetic",
+		            Desc: "Pop two floats, push the second divided by the top"},
+
+		"fin": Inst{Op: 0x2b, Pops: 1, Pushes: 1, Category: "Float arithmetic",
+		            Desc: "Increment the top float"},
+		"fde": Inst{Op: 0x2c, Pops: 1, Pushes: 1, Category: "Float arithmetic",
+		            Desc: "Decrement the top float"},
+
+		"neg": Inst{Op: 0x2d, Pops: 1, Pushes: 1, Category: "Arithmetic",
+		            Desc: "Negate the top integer"},
+		"not": Inst{Op: 0x2e, Pops: 1, Pushes: 1, Category: "Logic",
+		            Desc: "Replace the top value with 1 if it is 0, 0 otherwise"},
+
+		"jmp": Inst{Op: 0x30, HasArg: true, Operand: IntOperand, Category: "Control flow",
+		            Desc: "Jump to the address", Arg: "Address of the instruction",
+		            Example: "jmp loop"},
+		"jnz": Inst{Op: 0x31, HasArg: true, Operand: IntOperand, Pops: 1, Category: "Control flow",
+		            Desc: "Pop a value, jump to the address if it is not 0",
+		            Arg: "Address of the instruction", Example: "jnz loop"},
+
+		"cal": Inst{Op: 0x38, HasArg: true, Operand: IntOperand, Category: "Control flow",
+		            Desc: "Call the routine at the address", Arg: "Address of the routine",
+		            Example: "cal print"},
+		"ret": Inst{Op: 0x39, Category: "Control flow", Desc: "Return from the routine"},
+
+		"and": Inst{Op: 0x46, Pops: 2, Pushes: 1, Category: "Logic",
+		            Desc: "Pop two values, push 1 if both are not 0"},
+		"orr": Inst{Op: 0x47, Pops: 2, Pushes: 1, Category: "Logic",
+		            Desc: "Pop two values, push 1 if either is not 0"},
+
+		"equ": Inst{Op: 0x32, Pops: 2, Pushes: 1, Category: "Comparison",
+		            Desc: "Pop two integers, push 1 if they are equal"},
+		"neq": Inst{Op: 0x33, Pops: 2, Pushes: 1, Category: "Comparison",
+		            Desc: "Pop two integers, push 1 if they are not equal"},
+		"grt": Inst{Op: 0x34, Pops: 2, Pushes: 1, Category: "Comparison",
+		            Desc: "Pop two integers, push 1 if the second is greater"},
+		"geq": Inst{Op: 0x35, Pops: 2, Pushes: 1, Category: "Comparison",
+		            Desc: "Pop two integers, push 1 if the second is greater or equal"},
+		"les": Inst{Op: 0x36, Pops: 2, Pushes: 1, Category: "Comparison",
+		            Desc: "Pop two integers, push 1 if the second is less"},
+		"leq": Inst{Op: 0x37, Pops: 2, Pushes: 1, Category: "Comparison",
+		            Desc: "Pop two integers, push 1 if the second is less or equal"},
+
+		"ueq": Inst{Op: 0x3a, Pops: 2, Pushes: 1, Category: "Unsigned comparison",
+		            Desc: "Pop two unsigned integers, push 1 if they are equal"},
+		"une": Inst{Op: 0x3b, Pops: 2, Pushes: 1, Category: "Unsigned comparison",
+		            Desc: "Pop two unsigned integers, push 1 if they are not equal"},
+		"ugr": Inst{Op: 0x3c, Pops: 2, Pushes: 1, Category: "Unsigned comparison",
+		            Desc: "Pop two unsigned integers, push 1 if the second is greater"},
+		"ugq": Inst{Op: 0x3d, Pops: 2, Pushes: 1, Category: "Unsigned comparison",
+		            Desc: "Pop two unsigned integers, push 1 if the second is greater or equal"},
+		"ule": Inst{Op: 0x3e, Pops: 2, Pushes: 1, Category: "Unsigned comparison",
+		            Desc: "Pop two unsigned integers, push 1 if the second is less"},
+		"ulq": Inst{Op: 0x3f, Pops: 2, Pushes: 1, Category: "Unsigned comparison",
+		            Desc: "Pop two unsigned integers, push 1 if the second is less or equal"},
+
+		"feq": Inst{Op: 0x40, Pops: 2, Pushes: 1, Category: "Float comparison",
+		            Desc: "Pop two floats, push 1 if they are equal"},
+		"fne": Inst{Op: 0x41, Pops: 2, Pushes: 1, Category: "Float comparison",
+		            Desc: "Pop two floats, push 1 if they are not equal"},
+		"fgr": Inst{Op: 0x42, Pops: 2, Pushes: 1, Category: "Float comparison",
+		            Desc: "Pop two floats, push 1 if the second is greater"},
+		"fgq": Inst{Op: 0x43, Pops: 2, Pushes: 1, Category: "Float comparison",
+		            Desc: "Pop two floats, push 1 if the second is greater or equal"},
+		"fle": Inst{Op: 0x44, Pops: 2, Pushes: 1, Category: "Float comparison",
+		            Desc: "Pop two floats, push 1 if the second is less"},
+		"flq": Inst{Op: 0x45, Pops: 2, Pushes: 1, Category: "Float comparison",
+		            Desc: "Pop two floats, push 1 if the second is less or equal"},
+
+		"dup": Inst{Op: 0x50, HasArg: true, Operand: IntOperand, Pushes: 1, Category: "Stack",
+		            Desc: "Push a copy of the value at the offset from the top",
+		            Arg: "Offset from the top of the stack", Example: "dup 0"},
+		"swp": Inst{Op: 0x51, HasArg: true, Operand: IntOperand, Category: "Stack",
+		            Desc: "Swap the top value with the value at the offset from the top",
+		            Arg: "Offset from the top of the stack", Example: "swp 1"},
+		"emp": Inst{Op: 0x52, Pushes: 1, Category: "Stack", Desc: "Push 1 if the stack is empty"},
+		"set": Inst{Op: 0x53, Pops: 3, Category: "Memory", Desc: "Fill a memory range with a byte"},
+		"cpy": Inst{Op: 0x54, Pops: 3, Category: "Memory", Desc: "Copy a memory range"},
+
+		"r08": Inst{Op: 0x60, Pops: 1, Pushes: 1, Category: "Memory",
+		            Desc: "Pop an address, push the byte at it"},
+		"r16": Inst{Op: 0x61, Pops: 1, Pushes: 1, Category: "Memory",
+		            Desc: "Pop an address, push the 16 bit integer at it"},
+		"r32": Inst{Op: 0x62, Pops: 1, Pushes: 1, Category: "Memory",
+		            Desc: "Pop an address, push the 32 bit integer at it"},
+		"r64": Inst{Op: 0x63, Pops: 1, Pushes: 1, Category: "Memory",
+		            Desc: "Pop an address, push the 64 bit integer at it"},
+
+		"w08": Inst{Op: 0x64, Pops: 2, Category: "Memory",
+		            Desc: "Pop an address and a value, write the value to it as a byte"},
+		"w16": Inst{Op: 0x65, Pops: 2, Category: "Memory",
+		            Desc: "Pop an address and a value, write the value to it as 16 bits"},
+		"w32": Inst{Op: 0x66, Pops: 2, Category: "Memory",
+		            Desc: "Pop an address and a value, write the value to it as 32 bits"},
+		"w64": Inst{Op: 0x67, Pops: 2, Category: "Memory",
+		            Desc: "Pop an address and a value, write the value to it as 64 bits"},
+
+		"ope": Inst{Op: 0x70, Pops: 2, Pushes: 1, Category: "Files", Desc: "Open a file"},
+		"clo": Inst{Op: 0x71, Pops: 1, Category: "Files", Desc: "Close a file"},
+		"wrf": Inst{Op: 0x72, Pops: 3, Category: "Files",
+		            Desc: "Pop a file, a size and an address, write the memory to the file"},
+		"rdf": Inst{Op: 0x73, Pops: 3, Category: "Files",
+		            Desc: "Pop a file, a size and an address, read the file into the memory"},
+		"szf": Inst{Op: 0x74, Pops: 1, Pushes: 1, Category: "Files",
+		            Desc: "Push the size of a file"},
+		"flu": Inst{Op: 0x75, Pops: 1, Category: "Files", Desc: "Flush a file"},
+
+		"ban": Inst{Op: 0x80, Pops: 2, Pushes: 1, Category: "Bitwise",
+		            Desc: "Pop two integers, push their bitwise and"},
+		"bor": Inst{Op: 0x81, Pops: 2, Pushes: 1, Category: "Bitwise",
+		            Desc: "Pop two integers, push their bitwise or"},
+		"bsr": Inst{Op: 0x82, Pops: 2, Pushes: 1, Category: "Bitwise",
+		            Desc: "Pop two integers, push the second shifted right by the top"},
+		"bsl": Inst{Op: 0x83, Pops: 2, Pushes: 1, Category: "Bitwise",
+		            Desc: "Pop two integers, push the second shifted left by the top"},
+
+		"lol": Inst{Op: 0x90, Pops: 1, Pushes: 1, Category: "Libraries",
+		            Desc: "Load a native library"},
+		"cll": Inst{Op: 0x91, Pops: 1, Category: "Libraries", Desc: "Close a native library"},
+		"llf": Inst{Op: 0x92, Pops: 2, Pushes: 1, Category: "Libraries",
+		            Desc: "Load a function from a native library"},
+		"ulf": Inst{Op: 0x93, Pops: 1, Category: "Libraries",
+		            Desc: "Unload a native library function"},
+		"clf": Inst{Op: 0x94, Pops: -1, Category: "Libraries",
+		            Desc: "Call a native library function"},
+
+		"dmp": Inst{Op: 0xF0, Category: "Debug", Desc: "Print the stack"},
+		"prt": Inst{Op: 0xF1, Pops: 1, Category: "Debug", Desc: "Pop an integer and print it"},
+		"fpr": Inst{Op: 0xF2, Pops: 1, Category: "Debug", Desc: "Pop a float and print it"},
+
+		"hlt": Inst{Op: 0xFF, Pops: 1, Category: "Control flow",
+		            Desc: "Pop the exit code and stop the program"},
 	}
+
+	// The table can only be extended before the first compiler is created, after that it is only
+	// read, so compilers can not see it change under them
+	instsMu     sync.Mutex
+	instsSealed bool
 )
+
+func sealInsts() {
+	instsMu.Lock()
+	defer instsMu.Unlock()
+
+	instsSealed = true
+}
+
+// Checks that Insts is consistent: opcodes and names are unique (names are not keywords either),
+// the operand fields fit the argument and every instruction is encoded by agen with the same
+// opcode, so the disassembler maps the opcodes back to the same names
+func ValidateInstTable() error {
+	instsMu.Lock()
+	defer instsMu.Unlock()
+
+	names := []string{}
+	for name := range Insts {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	categories := make(map[string]bool)
+	for _, category := range Categories {
+		categories[category] = true
+	}
+
+	ops := make(map[byte]string)
+	for _, name := range names {
+		inst := Insts[name]
+		if prev, ok := ops[inst.Op]; ok {
+			return fmt.Errorf("Opcode 0x%02X of '%v' already used by '%v'", inst.Op, name, prev)
+		} else if _, ok := lexer.Keywords[name]; ok {
+			return fmt.Errorf("Instruction '%v' is named like a keyword", name)
+		}
+
+		ops[inst.Op] = name
+
+		if !inst.HasArg && (inst.Operand != AnyOperand || inst.Width != 0) {
+			return fmt.Errorf("Instruction '%v' has no argument, but an operand kind or width",
+			                  name)
+		} else if inst.Operand < AnyOperand || inst.Operand > RelOperand {
+			return fmt.Errorf("Invalid operand kind %v of '%v'", int(inst.Operand), name)
+		} else if inst.Width < 0 || inst.Width > agen.WordSize * 8 {
+			return fmt.Errorf("Width %v of '%v' is out of range (0-%v)", inst.Width, name,
+			                  agen.WordSize * 8)
+		} else if inst.Pops < -1 || inst.Pushes < 0 {
+			return fmt.Errorf("Invalid stack effect of '%v'", name)
+		} else if !categories[inst.Category] {
+			return fmt.Errorf("Unknown category '%v' of '%v'", inst.Category, name)
+		}
+
+		if encoded, ok := agen.Insts[name]; !ok {
+			return fmt.Errorf("Instruction '%v' is not known to agen", name)
+		} else if encoded.Op != inst.Op || encoded.HasArg != inst.HasArg {
+			return fmt.Errorf("Instruction '%v' is encoded by agen as opcode 0x%02X (argument: " +
+			                  "%v)", name, encoded.Op, encoded.HasArg)
+		}
+	}
+
+	return nil
+}
+
+// Format of the external instruction table entries
+type extraInst struct {
+	Name    string `json:"name"`
+	Op      int    `json:"op"`
+	Arg     bool   `json:"arg"`
+	Operand string `json:"operand"` // "any" (default), "int", "float" or "rel"
+	Width   int    `json:"width"`
+	Since   string `json:"since"`
+
+	Desc    string `json:"desc"`
+	ArgDesc string `json:"argDesc"`
+}
+
+// Merges the instructions from a JSON file into Insts, erroring on collisions with existing ones.
+// Has to be called before any compiler is created.
+func LoadInsts(path string) error {
+	instsMu.Lock()
+	defer instsMu.Unlock()
+
+	if instsSealed {
+		return fmt.Errorf("Instructions from '%v' can not be loaded after a compiler was created",
+		                  path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Could not open file '%v'", path)
+	}
+
+	var extra []extraInst
+	if err := json.Unmarshal(data, &extra); err != nil {
+		return fmt.Errorf("'%v': %v", path, err.Error())
+	}
+
+	ops := make(map[int]string)
+	for name, inst := range Insts {
+		ops[int(inst.Op)] = name
+	}
+
+	loaded := make(map[string]Inst)
+	for i, e := range extra {
+		if len(e.Name) == 0 {
+			return fmt.Errorf("'%v': entry %v: missing instruction name", path, i)
+		} else if _, ok := Insts[e.Name]; ok {
+			return fmt.Errorf("'%v': entry %v: instruction '%v' already exists", path, i, e.Name)
+		} else if _, ok := loaded[e.Name]; ok {
+			return fmt.Errorf("'%v': entry %v: duplicate instruction '%v'", path, i, e.Name)
+		} else if _, ok := lexer.Keywords[e.Name]; ok {
+			return fmt.Errorf("'%v': entry %v: instruction '%v' is named like a keyword",
+			                  path, i, e.Name)
+		}
+
+		if e.Op < 0 || e.Op > 0xFF {
+			return fmt.Errorf("'%v': entry %v: opcode %v of '%v' is out of range (0-255)",
+			                  path, i, e.Op, e.Name)
+		} else if prev, ok := ops[e.Op]; ok {
+			return fmt.Errorf("'%v': entry %v: opcode 0x%02X of '%v' already used by '%v'",
+			                  path, i, e.Op, e.Name, prev)
+		}
+
+		// The stack effect of external instructions is not known
+		inst := Inst{Op: byte(e.Op), HasArg: e.Arg, Pops: -1, Category: "Other", Desc: e.Desc,
+		             Arg: e.ArgDesc}
+		switch e.Operand {
+		case "", "any": inst.Operand = AnyOperand
+		case "int":     inst.Operand = IntOperand
+		case "float":   inst.Operand = FloatOperand
+		case "rel":     inst.Operand = RelOperand
+
+		default:
+			return fmt.Errorf("'%v': entry %v: invalid operand kind '%v' of '%v' " +
+			                  "(any/int/float/rel)", path, i, e.Operand, e.Name)
+		}
+
+		if e.Width < 0 || e.Width > agen.WordSize * 8 {
+			return fmt.Errorf("'%v': entry %v: width %v of '%v' is out of range (0-%v)",
+			                  path, i, e.Width, e.Name, agen.WordSize * 8)
+		}
+
+		inst.Width = e.Width
+
+		if len(e.Since) > 0 {
+			if _, err := fmt.Sscanf(e.Since, "%d.%d", &inst.MinMajor, &inst.MinMinor); err != nil {
+				return fmt.Errorf("'%v': entry %v: invalid version '%v' of '%v'",
+				                  path, i, e.Since, e.Name)
+			}
+		}
+
+		ops[e.Op]      = e.Name
+		loaded[e.Name] = inst
+	}
+
+	for name, inst := range loaded {
+		Insts[name]      = inst
+		agen.Insts[name] = agen.Inst{Op: inst.Op, HasArg: inst.HasArg}
+	}
+
+	return nil
+}
+
+func (inst Inst) Supported() bool {
+	if inst.MinMajor != agen.VersionMajor {
+		return inst.MinMajor < agen.VersionMajor
+	}
+
+	return inst.MinMinor <= agen.VersionMinor
+}
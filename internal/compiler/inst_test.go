@@ -0,0 +1,148 @@
+package compiler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/avm-collection/agen"
+)
+
+// Loads the JSON as an instruction table, as if no compiler had been created yet. Loaded
+// instructions are removed again when the test ends.
+func loadInsts(t *testing.T, table string) error {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "insts.json")
+	if err := os.WriteFile(path, []byte(table), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	instsMu.Lock()
+	sealed := instsSealed
+	before := make(map[string]bool)
+	for name := range Insts {
+		before[name] = true
+	}
+
+	instsSealed = false
+	instsMu.Unlock()
+
+	t.Cleanup(func() {
+		instsMu.Lock()
+		defer instsMu.Unlock()
+
+		for name := range Insts {
+			if !before[name] {
+				delete(Insts, name)
+				delete(agen.Insts, name)
+			}
+		}
+
+		instsSealed = sealed
+	})
+
+	return LoadInsts(path)
+}
+
+func TestLoadInstsErrors(t *testing.T) {
+	psh := Insts["psh"].Op
+
+	tests := []struct {
+		name, table, want string
+	}{
+		{"missing name", `[{"op": 200}]`, "entry 0: missing instruction name"},
+		{"existing instruction", `[{"name": "psh", "op": 200}]`,
+		 "entry 0: instruction 'psh' already exists"},
+		{"duplicate instruction", `[{"name": "foo", "op": 200}, {"name": "foo", "op": 201}]`,
+		 "entry 1: duplicate instruction 'foo'"},
+		{"keyword", `[{"name": "let", "op": 200}]`,
+		 "entry 0: instruction 'let' is named like a keyword"},
+		{"opcode out of range", `[{"name": "foo", "op": 256}]`,
+		 "entry 0: opcode 256 of 'foo' is out of range (0-255)"},
+		{"opcode of an instruction", fmt.Sprintf(`[{"name": "foo", "op": %v}]`, psh),
+		 fmt.Sprintf("entry 0: opcode 0x%02X of 'foo' already used by 'psh'", psh)},
+		{"duplicate opcode", `[{"name": "foo", "op": 200}, {"name": "bar", "op": 200}]`,
+		 "entry 1: opcode 0xC8 of 'bar' already used by 'foo'"},
+		{"operand kind", `[{"name": "foo", "op": 200, "arg": true, "operand": "ptr"}]`,
+		 "entry 0: invalid operand kind 'ptr' of 'foo' (any/int/float/rel)"},
+		{"width", `[{"name": "foo", "op": 200, "arg": true, "width": 65}]`,
+		 "entry 0: width 65 of 'foo' is out of range (0-64)"},
+		{"version", `[{"name": "foo", "op": 200, "since": "one"}]`,
+		 "entry 0: invalid version 'one' of 'foo'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := loadInsts(t, tt.table)
+			if err == nil {
+				t.Fatalf("Loaded, expected the error '%v'", tt.want)
+			}
+
+			// After the path of the table
+			if got := err.Error(); !strings.HasSuffix(got, "': " + tt.want) {
+				t.Errorf("Got the error '%v', expected '%v'", got, tt.want)
+			}
+
+			if _, ok := Insts["foo"]; ok {
+				t.Errorf("Instruction 'foo' was loaded from an invalid table")
+			}
+		})
+	}
+}
+
+// The distance of a relative jump has to fit into the width of the instruction as a signed
+// number, or the jump would land somewhere else
+func TestRelWidth(t *testing.T) {
+	err := loadInsts(t, `[{"name": "rjs", "op": 162, "arg": true, "operand": "rel", "width": 8}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		before  int // Instructions between the entry and the jump
+		after   int // Instructions between the jump and the target
+		back    bool
+		wantErr bool
+	}{
+		{"forwards",         0,   127, false, false},
+		{"too far forwards", 0,   128, false, true},
+		{"backwards",        127, 0,   true,  false},
+		{"too far back",     128, 0,   true,  true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			b.WriteString(".entry\n")
+			if tt.back {
+				b.WriteString(".target\n")
+			}
+
+			b.WriteString(strings.Repeat("\thlt\n", tt.before))
+			b.WriteString("\trjs target\n")
+			b.WriteString(strings.Repeat("\thlt\n", tt.after))
+			if !tt.back {
+				b.WriteString(".target\n")
+			}
+
+			b.WriteString("\thlt\n")
+
+			var errs []string
+			for _, d := range New(b.String(), "<test>", Options{}).Check() {
+				if !d.Warning {
+					errs = append(errs, d.Msg)
+				}
+			}
+
+			if tt.wantErr && len(errs) != 1 {
+				t.Errorf("Got the errors %q, expected one about the distance", errs)
+			} else if !tt.wantErr && len(errs) != 0 {
+				t.Errorf("Got the errors %q, expected none", errs)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package compiler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/avm-collection/agen"
+)
+
+// Memory map files list where everything ended up: the variables in address order with the gaps
+// between them, then the labels in instruction order. Columns have fixed widths and nothing
+// depends on the time or the order of maps, so maps of two builds can be diffed.
+
+// Region of the memory, a variable or the gap before one
+type Region struct {
+	Names []string // Variables in it, more than one if they were merged, none for gaps
+	Addr  agen.Word
+	Size  agen.Word
+}
+
+// The regions of the memory in address order, covering all of it. Only valid after Compile.
+func (c *Compiler) Regions() []Region {
+	regions := []Region{}
+
+	var end agen.Word
+	for _, name := range c.varsByAddr() {
+		var_ := c.vars[name]
+		// Merged variables
+		last := len(regions) - 1
+		if last >= 0 && len(regions[last].Names) > 0 && regions[last].Addr == var_.Addr &&
+		   regions[last].Size == var_.Size {
+			regions[last].Names = append(regions[last].Names, name)
+			continue
+		} else if var_.Addr > end {
+			regions = append(regions, Region{Addr: end, Size: var_.Addr - end})
+		}
+
+		regions = append(regions, Region{Names: []string{name}, Addr: var_.Addr, Size: var_.Size})
+		if var_.Addr + var_.Size > end {
+			end = var_.Addr + var_.Size
+		}
+	}
+
+	if size := c.a.MemorySize(); size > end {
+		regions = append(regions, Region{Addr: end, Size: size - end})
+	}
+
+	return regions
+}
+
+// Names of the variables of the program, not the imported ones, by address
+func (c *Compiler) varsByAddr() []string {
+	vars := []string{}
+	for name, var_ := range c.vars {
+		if !var_.Imported {
+			vars = append(vars, name)
+		}
+	}
+
+	// Merged variables share their address, the first defined comes first
+	sort.Slice(vars, func(i, j int) bool {
+		a, b := c.vars[vars[i]], c.vars[vars[j]]
+		if a.Addr != b.Addr {
+			return a.Addr < b.Addr
+		}
+
+		return whereLess(a.Token.Where, b.Token.Where)
+	})
+
+	return vars
+}
+
+func (c *Compiler) WriteMap(w io.Writer) error {
+	vars := c.varsByAddr()
+
+	labels := []string{}
+	for name, label := range c.labels {
+		if !label.Imported {
+			labels = append(labels, name)
+		}
+	}
+
+	sort.Slice(labels, func(i, j int) bool {
+		a, b := c.labels[labels[i]], c.labels[labels[j]]
+		if a.Addr != b.Addr {
+			return a.Addr < b.Addr
+		}
+
+		return whereLess(a.Token.Where, b.Token.Where)
+	})
+
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "# Memory map of '%v'\n\n", c.path)
+	fmt.Fprintf(out, "%-10v %-10v %4v %-8v %10v %10v  %-24v %v\n",
+	             "address", "end", "elem", "sign", "count", "bytes", "name", "defined at")
+
+	var end agen.Word
+	for _, name := range vars {
+		var_ := c.vars[name]
+		if var_.Addr > end {
+			fmt.Fprintf(out, "0x%08X 0x%08X %4v %-8v %10v %10v  (gap)\n",
+			             end, var_.Addr, "", "", "", var_.Addr - end)
+		}
+
+		count := ""
+		if var_.Elem > 0 {
+			count = fmt.Sprint(var_.Size / var_.Elem)
+		}
+
+		fmt.Fprintf(out, "0x%08X 0x%08X %4v %-8v %10v %10v  %-24v %v\n",
+		             var_.Addr, var_.Addr + var_.Size, var_.Elem, var_.Sign, count, var_.Size,
+		             name, var_.Token.Where)
+
+		if var_.Addr + var_.Size > end {
+			end = var_.Addr + var_.Size
+		}
+	}
+
+	if size := c.a.MemorySize(); size > end {
+		fmt.Fprintf(out, "0x%08X 0x%08X %4v %-8v %10v %10v  (gap)\n", end, size, "", "", "",
+		             size - end)
+	}
+
+	fmt.Fprintf(out, "\nmemory size %v bytes\n\n", c.a.MemorySize())
+
+	fmt.Fprintf(out, "%-10v %-24v %v\n", "index", "label", "defined at")
+	for _, name := range labels {
+		label := c.labels[name]
+		fmt.Fprintf(out, "%-10v %-24v %v\n", label.Addr, name, label.Token.Where)
+	}
+
+	fmt.Fprintf(out, "\nprogram size %v instructions\n", c.programSize)
+	if c.opts.PadEnd > 0 {
+		fmt.Fprintf(out, "padding %v..%v ('%v')\n", c.programSize - agen.Word(c.opts.PadEnd),
+		            c.programSize, c.padInst())
+	}
+
+	// Only in maps of builds given symbols, so the others stay the same
+	if len(c.defsyms) > 0 {
+		defsyms := []string{}
+		for name := range c.defsyms {
+			defsyms = append(defsyms, name)
+		}
+
+		sort.Strings(defsyms)
+
+		fmt.Fprintf(out, "\n%-18v %-24v %v\n", "value", "external symbol", "given at")
+		for _, name := range defsyms {
+			sym := c.defsyms[name]
+			fmt.Fprintf(out, "0x%016X %-24v %v\n", sym.Value, name, sym.Where)
+		}
+	}
+
+	return out.Flush()
+}
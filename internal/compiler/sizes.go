@@ -0,0 +1,98 @@
+package compiler
+
+import (
+	"sort"
+
+	"github.com/avm-collection/agen"
+
+	"github.com/avm-collection/anasm/internal/node"
+)
+
+// Size reports, to find what a program spends its bytes on. Instructions and inline data belong
+// to the closest label before them, variables to the closest label before their definition (a
+// label roughly being a routine), or to the file they are written in. Everything before the first
+// label belongs to NoLabel.
+
+const NoLabel = "-"
+
+type Size struct {
+	Name    string    // Label or file path
+	Program agen.Word // Bytes of instructions and inline data
+	Memory  agen.Word // Bytes of variables
+}
+
+func (s Size) Total() agen.Word {
+	return s.Program + s.Memory
+}
+
+// Sizes by the closest label, largest first. Only valid after Compile.
+func (c *Compiler) SizePerLabel() []Size {
+	label := NoLabel
+	return c.sizes(func(s node.Statement) string {
+		if n, ok := s.(*node.Label); ok {
+			label = n.Name.Value
+		}
+
+		return label
+	})
+}
+
+// Sizes by the file the code and variables are written in, largest first. Only valid after
+// Compile.
+func (c *Compiler) SizePerFile() []Size {
+	return c.sizes(func(s node.Statement) string {
+		return s.GetToken().Where.Path
+	})
+}
+
+func (c *Compiler) sizes(owner func(node.Statement) string) []Size {
+	sizes   := make(map[string]*Size)
+	counted := make(map[agen.Word]bool) // Addresses of variables, merged ones share memory
+	add     := func(name string) *Size {
+		if _, ok := sizes[name]; !ok {
+			sizes[name] = &Size{Name: name}
+		}
+
+		return sizes[name]
+	}
+
+	for _, s := range c.program.List {
+		if node.IsNil(s) {
+			continue
+		}
+
+		name := owner(s)
+		switch n := s.(type) {
+		case *node.Inst: add(name).Program += agen.InstSize
+		case *node.Data: add(name).Program += c.slots[n] * agen.InstSize
+
+		case *node.Let:   c.addVar(add(name), n.Name.Value, counted)
+		case *node.Embed: c.addVar(add(name), n.Name.Value, counted)
+		}
+	}
+
+	list := []Size{}
+	for _, size := range sizes {
+		list = append(list, *size)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Total() != list[j].Total() {
+			return list[i].Total() > list[j].Total()
+		}
+
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
+
+func (c *Compiler) addVar(size *Size, name string, counted map[agen.Word]bool) {
+	var_, ok := c.vars[name]
+	if !ok || var_.Size == 0 || counted[var_.Addr] {
+		return
+	}
+
+	counted[var_.Addr] = true
+	size.Memory       += var_.Size
+}
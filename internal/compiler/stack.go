@@ -0,0 +1,162 @@
+package compiler
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/avm-collection/anasm/internal/node"
+)
+
+// Static stack depth analysis. Instructions are walked from the entry point following the control
+// flow while tracking the range of possible stack depths. Paths that can not be followed (calls,
+// jumps to addresses not derived from a label, instructions with an unknown stack effect) are
+// dropped with a note instead of guessing, so the check never reports false underflows for them.
+
+const unbounded = math.MaxInt32
+
+type depth struct {
+	lo, hi int
+}
+
+func (d depth) String() string {
+	if d.lo == d.hi {
+		return fmt.Sprint(d.lo)
+	} else if d.hi == unbounded {
+		return fmt.Sprintf("%v or more", d.lo)
+	}
+
+	return fmt.Sprintf("%v to %v", d.lo, d.hi)
+}
+
+func (d depth) merge(other depth) depth {
+	if other.lo < d.lo {
+		d.lo = other.lo
+	}
+
+	// Growing depths (pushing in a loop) would never settle
+	if other.hi > d.hi {
+		d.hi = unbounded
+	}
+
+	return d
+}
+
+func (d depth) apply(pops, pushes int) depth {
+	d.lo = popDepth(d.lo, pops) + pushes
+	if d.hi != unbounded {
+		d.hi = popDepth(d.hi, pops) + pushes
+	}
+
+	return d
+}
+
+func popDepth(depth, pops int) int {
+	if pops > depth {
+		return 0 // Underflows are reported separately
+	}
+
+	return depth - pops
+}
+
+func (c *Compiler) checkStack() {
+	labels := c.labelIndices()
+
+	entry, ok := c.entryIndex(labels)
+	if !ok {
+		return
+	}
+
+	type path struct {
+		i int
+		d depth
+	}
+
+	depths   := make(map[int]depth) // Statement index -> depth before the instruction
+	joined   := make(map[int]bool) // Instructions already warned about, once for each kind
+	under    := make(map[int]bool)
+	work     := []path{{i: entry}}
+	notedCal := false
+
+	for len(work) > 0 {
+		p   := work[len(work) - 1]
+		work = work[:len(work) - 1]
+
+	walk:
+		for i, d := p.i, p.d; i < len(c.program.List); i ++ {
+			n, ok := c.program.List[i].(*node.Inst)
+			if !ok {
+				continue // Labels and inline data do not touch the stack
+			}
+
+			if prev, ok := depths[i]; ok {
+				merged := prev.merge(d)
+				if merged == prev {
+					break
+				}
+
+				if !joined[i] {
+					joined[i] = true
+					c.warn("stack", n.Token.Where, "Stack depth differs between paths reaching " +
+					       "'%v' (%v and %v)", n.Name, prev, d)
+				}
+
+				d = merged
+			}
+
+			depths[i] = d
+
+			inst := Insts[n.Name]
+			if inst.Pops < 0 {
+				c.diags.Note(n.Token.Where, "Stack check stops here, the stack effect of '%v' " +
+				             "is not known", n.Name)
+				break
+			}
+
+			need := inst.Pops
+			if n.Name == "dup" || n.Name == "swp" {
+				// Offsets that do not fit are reported by the compiler already
+				if off := c.evalExpr(n.Arg); c.kindOf(n.Arg) != floatKind && off < unbounded {
+					need = int(off) + 1
+				}
+			}
+
+			if d.lo < need && !under[i] {
+				under[i] = true
+				c.warn("stack", n.Token.Where, "'%v' may underflow the stack (needs %v, the " +
+				       "depth is %v)", n.Name, need, d)
+			}
+
+			d = d.apply(inst.Pops, inst.Pushes)
+
+			switch n.Name {
+			case "jmp", "jnz":
+				id, ok := n.Arg.(*node.Id)
+				target, isLabel := 0, false
+				if ok {
+					target, isLabel = labels[id.Value]
+				}
+
+				if !isLabel {
+					c.diags.Note(n.Token.Where, "Stack check does not follow '%v', it jumps to " +
+					             "an address not derived from a label", n.Name)
+				} else {
+					work = append(work, path{i: target, d: d})
+				}
+
+				if n.Name == "jmp" {
+					break walk
+				}
+
+			case "cal":
+				if !notedCal {
+					notedCal = true
+					c.diags.Note(n.Token.Where, "Stack check stops after calls, the stack effect " +
+					             "of routines is not known")
+				}
+				break walk
+
+			case "ret", "hlt": break walk
+			}
+		}
+	}
+}
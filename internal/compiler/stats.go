@@ -0,0 +1,61 @@
+package compiler
+
+import (
+	"runtime"
+	"time"
+)
+
+// Phase profiling, only measured with Options.Time since reading the memory statistics stops
+// the world
+
+type Phase struct {
+	Time  time.Duration
+	Alloc uint64 // Bytes allocated during the phase, an upper bound of its peak memory use
+}
+
+type Stats struct {
+	Parse   Phase // Lexing and parsing, with included files
+	Compile Phase // Everything up to the finished program, including the checks
+	Write   Phase // Writing the executable
+}
+
+func (s Stats) Total() Phase {
+	return Phase{
+		Time:  s.Parse.Time  + s.Compile.Time  + s.Write.Time,
+		Alloc: s.Parse.Alloc + s.Compile.Alloc + s.Write.Alloc,
+	}
+}
+
+// Zero unless Options.Time is set, phases that did not run are zero too
+func (c *Compiler) Stats() Stats {
+	return c.stats
+}
+
+type phaseStart struct {
+	time  time.Time
+	alloc uint64
+}
+
+func (c *Compiler) startPhase() phaseStart {
+	if !c.opts.Time {
+		return phaseStart{}
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	return phaseStart{time: time.Now(), alloc: mem.TotalAlloc}
+}
+
+func (c *Compiler) endPhase(start phaseStart, phase *Phase) {
+	if !c.opts.Time {
+		return
+	}
+
+	phase.Time = time.Since(start.time)
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	phase.Alloc = mem.TotalAlloc - start.alloc
+}
@@ -0,0 +1,184 @@
+package compiler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/avm-collection/agen"
+
+	"github.com/avm-collection/anasm/internal/lexer"
+	"github.com/avm-collection/anasm/internal/node"
+	"github.com/avm-collection/anasm/internal/token"
+)
+
+// Symbol files list the labels and variables of a built program with their addresses, one per
+// line as 'label NAME ADDR' or 'var NAME ADDR SIZE [signed/unsigned]'. Programs patching a
+// deployed image import them to use its routines and memory without having its source.
+//
+// Symbols given by the build are listed as 'defsym NAME VALUE' too, but not imported, they
+// describe the target and every build is given its own.
+
+type Import struct {
+	Name  string
+	Label bool // Variable otherwise
+	Addr  agen.Word
+	Size  agen.Word // Only for variables
+	Sign  string    // Only for variables with qualified elements, see node.Signed
+
+	Where token.Where // Line in the symbol file
+}
+
+// Symbol given by the build instead of defined in the program, like the base address of memory
+// mapped devices which differs per target. They are used like labels and variables, and defined
+// before anything else, like imports.
+type Defsym struct {
+	Name  string
+	Value agen.Word
+
+	Where token.Where // Where it was given, for diagnostics
+}
+
+func (c *Compiler) WriteSymbols(w io.Writer) error {
+	labels := []string{}
+	for name, label := range c.labels {
+		if !label.Imported {
+			labels = append(labels, name)
+		}
+	}
+
+	vars := []string{}
+	for name, var_ := range c.vars {
+		if !var_.Imported {
+			vars = append(vars, name)
+		}
+	}
+
+	defsyms := []string{}
+	for name := range c.defsyms {
+		defsyms = append(defsyms, name)
+	}
+
+	sort.Strings(labels)
+	sort.Strings(vars)
+	sort.Strings(defsyms)
+
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "# Symbols of '%v'\n", c.path)
+	for _, name := range labels {
+		fmt.Fprintf(out, "label %v 0x%X\n", name, c.labels[name].Addr)
+	}
+
+	for _, name := range vars {
+		var_ := c.vars[name]
+		if len(var_.Sign) > 0 {
+			fmt.Fprintf(out, "var %v 0x%X %v %v\n", name, var_.Addr, var_.Size, var_.Sign)
+		} else {
+			fmt.Fprintf(out, "var %v 0x%X %v\n", name, var_.Addr, var_.Size)
+		}
+	}
+
+	for _, name := range defsyms {
+		fmt.Fprintf(out, "defsym %v 0x%X\n", name, c.defsyms[name].Value)
+	}
+
+	return out.Flush()
+}
+
+func ReadSymbols(path string) ([]Import, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open file '%v'", path)
+	}
+
+	imports := []Import{}
+	for i, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") || fields[0] == "defsym" {
+			continue
+		}
+
+		im := Import{Where: token.Where{Row: i + 1, Col: 1, Len: len(line), Path: path, Line: line}}
+
+		switch {
+		case fields[0] == "label" && len(fields) == 3: im.Label = true
+		case fields[0] == "var" && (len(fields) == 4 || len(fields) == 5):
+			if im.Size, err = parseWord(fields[3]); err != nil {
+				return nil, fmt.Errorf("'%v:%v': Invalid size '%v'", path, i + 1, fields[3])
+			}
+
+			if len(fields) == 5 {
+				if im.Sign = fields[4]; im.Sign != node.Signed && im.Sign != node.Unsigned {
+					return nil, fmt.Errorf("'%v:%v': Expected '%v' or '%v', got '%v'", path,
+					                       i + 1, node.Signed, node.Unsigned, fields[4])
+				}
+			}
+
+		default:
+			return nil, fmt.Errorf("'%v:%v': Expected 'label NAME ADDR' or 'var NAME ADDR SIZE " +
+			                       "[signed/unsigned]'", path, i + 1)
+		}
+
+		// Symbol files are written by anasm, but can be edited or generated by other tools
+		if im.Name, err = lexer.NormalizeName(fields[1], true); err != nil {
+			return nil, fmt.Errorf("'%v:%v': %v", path, i + 1, err.Error())
+		} else if im.Addr, err = parseWord(fields[2]); err != nil {
+			return nil, fmt.Errorf("'%v:%v': Invalid address '%v'", path, i + 1, fields[2])
+		}
+
+		imports = append(imports, im)
+	}
+
+	return imports, nil
+}
+
+func parseWord(s string) (agen.Word, error) {
+	value, err := strconv.ParseUint(s, 0, 64)
+	return agen.Word(value), err
+}
+
+// Imported and given symbols are defined before anything else, so local definitions collide with
+// them. The entry point is not imported, every program has its own.
+func (c *Compiler) defineImports() {
+	for _, im := range c.opts.Imports {
+		tok := token.Token{Type: token.Id, Data: im.Name, Where: im.Where}
+		if im.Name == c.entry() {
+			continue
+		} else if prev, ok := c.labels[im.Name]; ok {
+			c.diags.Error(im.Where, "Label '%v' imported twice", im.Name)
+			c.diags.Note(prev.Token.Where, previously(true))
+		} else if prev, ok := c.vars[im.Name]; ok {
+			c.diags.Error(im.Where, "Variable '%v' imported twice", im.Name)
+			c.diags.Note(prev.Token.Where, previously(true))
+		} else if im.Label {
+			c.labels[im.Name] = Label{Token: tok, Addr: im.Addr, Imported: true}
+		} else {
+			c.vars[im.Name] = Var{Token: tok, Addr: im.Addr, Size: im.Size, Sign: im.Sign,
+			                      Imported: true}
+		}
+	}
+
+	for _, sym := range c.opts.Defsyms {
+		if prev, ok := c.defsyms[sym.Name]; ok {
+			c.diags.Error(sym.Where, "Symbol '%v' given twice", sym.Name)
+			c.diags.Note(prev.Where, "Previously given here")
+		} else if prev, ok := c.labels[sym.Name]; ok {
+			c.diags.Error(sym.Where, "Symbol '%v' is an imported label too", sym.Name)
+			c.diags.Note(prev.Token.Where, previously(true))
+		} else if prev, ok := c.vars[sym.Name]; ok {
+			c.diags.Error(sym.Where, "Symbol '%v' is an imported variable too", sym.Name)
+			c.diags.Note(prev.Token.Where, previously(true))
+		} else {
+			c.defsyms[sym.Name] = sym
+		}
+	}
+}
+
+func (c *Compiler) isDefsym(name string) bool {
+	_, ok := c.defsyms[name]
+	return ok
+}
@@ -0,0 +1,100 @@
+package compiler
+
+import (
+	"sort"
+
+	"github.com/avm-collection/agen"
+
+	"github.com/avm-collection/anasm/internal/token"
+)
+
+// Cross references. Every use of a label, variable or macro in a constant expression is recorded
+// while compiling, expressions can be evaluated more than once so duplicates are removed later.
+
+type Symbol struct {
+	Name string
+	Kind string // "label", "variable", "macro" or "external symbol", "imported label" and so on
+	Def  token.Where
+	Refs []token.Where // Sorted by file and position, empty for unused symbols
+
+	// Instruction index of labels, address of variables and value of the others (0 for string
+	// macros)
+	Value agen.Word
+}
+
+func (c *Compiler) ref(tok token.Token) {
+	c.refs[tok.Data] = append(c.refs[tok.Data], tok.Where)
+}
+
+func whereLess(a, b token.Where) bool {
+	if a.Path != b.Path {
+		return a.Path < b.Path
+	} else if a.Row != b.Row {
+		return a.Row < b.Row
+	}
+
+	return a.Col < b.Col
+}
+
+func sortWheres(wheres []token.Where) []token.Where {
+	sorted := append([]token.Where{}, wheres...)
+	sort.Slice(sorted, func(i, j int) bool {return whereLess(sorted[i], sorted[j])})
+
+	unique := sorted[:0]
+	for _, where := range sorted {
+		if len(unique) == 0 || whereLess(unique[len(unique) - 1], where) {
+			unique = append(unique, where)
+		}
+	}
+
+	return unique
+}
+
+// Symbol name -> every place it is referenced, only valid after Compile
+func (c *Compiler) XRef() map[string][]token.Where {
+	xref := make(map[string][]token.Where)
+	for name, refs := range c.refs {
+		xref[name] = sortWheres(refs)
+	}
+
+	return xref
+}
+
+func imported(kind string, imported bool) string {
+	if imported {
+		return "imported " + kind
+	}
+
+	return kind
+}
+
+// Every defined symbol with its references, sorted by the definition position
+func (c *Compiler) Symbols() []Symbol {
+	xref    := c.XRef()
+	symbols := []Symbol{}
+	for name, label := range c.labels {
+		symbols = append(symbols, Symbol{Name: name, Kind: imported("label", label.Imported),
+		                                 Def: label.Token.Where, Refs: xref[name],
+		                                 Value: label.Addr})
+	}
+
+	for name, var_ := range c.vars {
+		symbols = append(symbols, Symbol{Name: name, Kind: imported("variable", var_.Imported),
+		                                 Def: var_.Token.Where, Refs: xref[name],
+		                                 Value: var_.Addr})
+	}
+
+	for name, macro := range c.macros {
+		symbols = append(symbols, Symbol{Name: name, Kind: "macro", Def: macro.Token.Where,
+		                                 Refs: xref[name], Value: macro.Value})
+	}
+
+	for name, sym := range c.defsyms {
+		symbols = append(symbols, Symbol{Name: name, Kind: "external symbol", Def: sym.Where,
+		                                 Refs: xref[name], Value: sym.Value})
+	}
+
+	sort.Slice(symbols, func(i, j int) bool {return whereLess(symbols[i].Def, symbols[j].Def)})
+
+	return symbols
+}
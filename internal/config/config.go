@@ -3,8 +3,9 @@ package config
 const (
 	AppName    = "anasm"
 	GithubLink = "https://github.com/avm-collection/anasm"
+	LibDir     = "/usr/share/anasm" // Standard headers, searched by include
 
 	VersionMajor = 1
-	VersionMinor = 21
-	VersionPatch = 11
+	VersionMinor = 104
+	VersionPatch = 40
 )
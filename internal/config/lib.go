@@ -0,0 +1,32 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// Directories searched by include for the standard headers: the installed ones, then the lib
+// directory next to the executable or above it (bin/app of the makefile), then the lib directory
+// of the source tree anasm was built from, so headers resolve from anywhere in a checkout. Only
+// existing directories are returned.
+func LibDirs() []string {
+	candidates := []string{LibDir}
+	if exe, err := os.Executable(); err == nil {
+		dir := filepath.Dir(exe)
+		candidates = append(candidates, filepath.Join(dir, "lib"), filepath.Join(dir, "..", "lib"))
+	}
+
+	if _, file, _, ok := runtime.Caller(0); ok {
+		candidates = append(candidates, filepath.Join(filepath.Dir(file), "..", "..", "lib"))
+	}
+
+	dirs := []string{}
+	for _, dir := range candidates {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			dirs = append(dirs, filepath.Clean(dir))
+		}
+	}
+
+	return dirs
+}
@@ -0,0 +1,235 @@
+package diag
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"encoding/json"
+
+	"github.com/avm-collection/goerror"
+
+	"github.com/avm-collection/anasm/internal/token"
+)
+
+// Diagnostics at a position, like the goerror ones, but errors and warnings in included files
+// are followed by a note for every include directive that led to them, innermost first.
+//
+// Diagnostics are held back until Flush, and then printed in source position order,
+// errors before warnings at the same position. Passes of the compiler report in the order they
+// run, so this keeps the output the same however the passes are arranged.
+//
+// Every compilation reports into its own log, so compilations running at the same time do not see
+// each other's errors. Only the printing is shared.
+
+type Diagnostic struct {
+	Warning bool
+	Simple  bool // Without a position, printed before the others
+	Where   token.Where
+	Msg     string
+	Notes   []Note
+}
+
+type Note struct {
+	Where token.Where
+	Msg   string
+}
+
+// goerror prints and counts errors globally
+var output sync.Mutex
+
+type Log struct {
+	// Flush keeps the diagnostics for Take instead of printing them, for tools showing them
+	// their own way. Notes reported on their own are dropped.
+	Silent bool
+
+	pending []Diagnostic
+	kept    []Diagnostic // Flushed while silent
+	errors  int          // Flushed
+
+	recording bool
+	recorded  []Diagnostic // Flushed while recording
+}
+
+func (l *Log) Error(where token.Where, format string, args... interface{}) {
+	l.pending = append(l.pending, Diagnostic{Where: where, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (l *Log) Warning(where token.Where, format string, args... interface{}) {
+	l.pending = append(l.pending, Diagnostic{Warning: true, Where: where,
+	                                         Msg: fmt.Sprintf(format, args...)})
+}
+
+// Errors about the whole program, like a missing entry point
+func (l *Log) SimpleError(format string, args... interface{}) {
+	l.pending = append(l.pending, Diagnostic{Simple: true, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (l *Log) SimpleWarning(format string, args... interface{}) {
+	l.pending = append(l.pending, Diagnostic{Warning: true, Simple: true,
+	                                         Msg: fmt.Sprintf(format, args...)})
+}
+
+// Notes belong to the error or warning before them
+func (l *Log) Note(where token.Where, format string, args... interface{}) {
+	if len(l.pending) == 0 {
+		if l.Silent {
+			return
+		}
+
+		output.Lock()
+		goerror.Note(where, format, args...)
+		output.Unlock()
+		return
+	}
+
+	last      := &l.pending[len(l.pending) - 1]
+	last.Notes = append(last.Notes, Note{Where: where, Msg: fmt.Sprintf(format, args...)})
+}
+
+// Positions from the outermost include directive to the diagnostic
+func chain(where token.Where) []token.Where {
+	positions := []token.Where{where}
+	for from := where.IncludedFrom; from != nil; from = from.IncludedFrom {
+		positions = append([]token.Where{*from}, positions...)
+	}
+
+	return positions
+}
+
+// Prints the held back diagnostics in order
+func (l *Log) Flush() {
+	pending := l.pending
+
+	// Diagnostics outside of the assembled file tree (like in a symbols file) go in the order
+	// their files were first reported in
+	files := make(map[string]int)
+	for _, d := range pending {
+		if path := chain(d.Where)[0].Path; files[path] == 0 {
+			files[path] = len(files) + 1
+		}
+	}
+
+	sort.SliceStable(pending, func(i, j int) bool {
+		if pending[i].Simple || pending[j].Simple {
+			return pending[i].Simple && !pending[j].Simple
+		}
+
+		a, b := chain(pending[i].Where), chain(pending[j].Where)
+		if files[a[0].Path] != files[b[0].Path] {
+			return files[a[0].Path] < files[b[0].Path]
+		}
+
+		for k := 0; k < len(a) && k < len(b); k ++ {
+			if a[k].Row != b[k].Row {
+				return a[k].Row < b[k].Row
+			} else if a[k].Col != b[k].Col {
+				return a[k].Col < b[k].Col
+			}
+		}
+
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+
+		return !pending[i].Warning && pending[j].Warning
+	})
+
+	for _, d := range pending {
+		if !d.Warning {
+			l.errors ++
+		}
+	}
+
+	if l.recording {
+		l.recorded = append(l.recorded, pending...)
+	}
+
+	l.pending = nil
+	if l.Silent {
+		l.kept = append(l.kept, pending...)
+		return
+	}
+
+	output.Lock()
+	defer output.Unlock()
+
+	for _, d := range pending {
+		printDiagnostic(d)
+	}
+}
+
+// Diagnostics flushed while silent, in order, which are forgotten
+func (l *Log) Take() []Diagnostic {
+	kept  := l.kept
+	l.kept = nil
+	return kept
+}
+
+// Prints diagnostics taken from a silent log, like Flush would have
+func Print(diags []Diagnostic) {
+	output.Lock()
+	defer output.Unlock()
+
+	for _, d := range diags {
+		printDiagnostic(d)
+	}
+}
+
+func printDiagnostic(d Diagnostic) {
+	switch {
+	case d.Simple && d.Warning: goerror.SimpleWarning("%v", d.Msg)
+	case d.Simple:              goerror.SimpleError("%v", d.Msg)
+	case d.Warning:             goerror.Warning(d.Where, "%v", d.Msg)
+
+	default: goerror.Error(d.Where, "%v", d.Msg)
+	}
+
+	for _, n := range d.Notes {
+		goerror.Note(n.Where, "%v", n.Msg)
+	}
+
+	if d.Simple {
+		return
+	}
+
+	for from := d.Where.IncludedFrom; from != nil; from = from.IncludedFrom {
+		goerror.Note(*from, "Included from here")
+	}
+}
+
+// Recording keeps the flushed diagnostics, so a build cache can show the warnings of a build it
+// reuses again
+func (l *Log) StartRecording() {
+	l.recording = true
+	l.recorded  = nil
+}
+
+// Stops recording and encodes the recorded diagnostics for Replay
+func (l *Log) StopRecording() ([]byte, error) {
+	l.recording = false
+	data, err  := json.Marshal(l.recorded)
+	l.recorded  = nil
+	return data, err
+}
+
+// Holds back the diagnostics of a recording like they were reported again
+func (l *Log) Replay(data []byte) error {
+	replayed := []Diagnostic{}
+	if err := json.Unmarshal(data, &replayed); err != nil {
+		return err
+	}
+
+	l.pending = append(l.pending, replayed...)
+	return nil
+}
+
+// Reports if there were any errors in the log, held back or not
+func (l *Log) Happened() bool {
+	for _, d := range l.pending {
+		if !d.Warning {
+			return true
+		}
+	}
+
+	return l.errors > 0
+}
@@ -0,0 +1,130 @@
+package disasm
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/avm-collection/agen"
+
+	"github.com/avm-collection/anasm/internal/executable"
+)
+
+// Comparison of two AVM executables: header fields, then the program instruction by instruction and
+// the memory byte by byte. Returns whether the executables differ.
+func Diff(w io.Writer, oldInput, newInput []byte, oldPath, newPath string,
+          summary bool) (bool, error) {
+	before, err := executable.Parse(oldInput)
+	if err != nil {
+		return false, fmt.Errorf("'%v': %v", oldPath, err.Error())
+	}
+
+	after, err := executable.Parse(newInput)
+	if err != nil {
+		return false, fmt.Errorf("'%v': %v", newPath, err.Error())
+	}
+
+	differ := false
+
+	fields := []struct{
+		Name     string
+		Old, New interface{}
+	}{
+		{"version",      before.Version,     after.Version},
+		{"program size", before.ProgramSize, after.ProgramSize},
+		{"memory size",  before.MemorySize,  after.MemorySize},
+		{"entry point",  before.EntryPoint,  after.EntryPoint},
+	}
+
+	for _, field := range fields {
+		if field.Old != field.New {
+			differ = true
+			if !summary {
+				fmt.Fprintf(w, "%v: %v -> %v\n", field.Name, field.Old, field.New)
+			}
+		}
+	}
+
+	// Program
+	ops := opNames()
+
+	var changed, added, removed agen.Word
+	for i := agen.Word(0); i < before.InstCount() || i < after.InstCount(); i ++ {
+		switch {
+		case i >= after.InstCount():
+			removed ++
+			if !summary {
+				fmt.Fprintf(w, "- inst %v: %v\n", i, formatInst(ops, before.Inst(i)))
+			}
+
+		case i >= before.InstCount():
+			added ++
+			if !summary {
+				fmt.Fprintf(w, "+ inst %v: %v\n", i, formatInst(ops, after.Inst(i)))
+			}
+
+		case before.Inst(i) != after.Inst(i):
+			changed ++
+			if !summary {
+				fmt.Fprintf(w, "~ inst %v: %v -> %v\n", i, formatInst(ops, before.Inst(i)),
+				            formatInst(ops, after.Inst(i)))
+			}
+		}
+	}
+
+	// Memory, consecutive changed bytes are reported together
+	var memChanged int
+	size := len(before.Memory)
+	if len(after.Memory) > size {
+		size = len(after.Memory)
+	}
+
+	same := func(i int) bool {
+		if i >= len(before.Memory) || i >= len(after.Memory) {
+			return false
+		}
+
+		return before.Memory[i] == after.Memory[i]
+	}
+
+	for i := 0; i < size; {
+		if same(i) {
+			i ++
+			continue
+		}
+
+		start := i
+		for i < size && !same(i) {
+			i ++
+		}
+
+		memChanged += i - start
+		if !summary {
+			fmt.Fprintf(w, "~ mem 0x%04x: %v -> %v\n", start,
+			            hexBytes(clamp(before.Memory, start, i)),
+			            hexBytes(clamp(after.Memory,  start, i)))
+		}
+	}
+
+	if changed + added + removed > 0 || memChanged > 0 {
+		differ = true
+	}
+
+	if summary {
+		fmt.Fprintf(w, "%v instructions changed, %v added, %v removed, %v memory bytes changed\n",
+		            changed, added, removed, memChanged)
+	}
+
+	return differ, nil
+}
+
+func clamp(bytes []byte, start, end int) []byte {
+	if start > len(bytes) {
+		start = len(bytes)
+	}
+
+	if end > len(bytes) {
+		end = len(bytes)
+	}
+
+	return bytes[start:end]
+}
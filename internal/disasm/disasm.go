@@ -3,7 +3,6 @@ package disasm
 import (
 	"fmt"
 	"os"
-	"encoding/binary"
 	"math"
 	"strings"
 
@@ -11,102 +10,96 @@ import (
 	"github.com/avm-collection/agen"
 
 	"github.com/avm-collection/anasm/internal/compiler"
+	"github.com/avm-collection/anasm/internal/executable"
+	"github.com/avm-collection/anasm/internal/node"
 )
 
 type Disassembler struct {
 	input []byte
 	path  string
-	pos   int
+	exe   *executable.Executable
 
-	programSize agen.Word
-	memorySize  agen.Word
-	entryPoint  agen.Word
-
-	out string
+	ops map[byte]string // Opcode -> instruction name
+	out strings.Builder
 }
 
 func New(input []byte, path string) *Disassembler {
-	return &Disassembler{input: input, path: path}
+	return &Disassembler{input: input, path: path, ops: opNames()}
 }
 
-func (d *Disassembler) readBytes(size int) ([]byte, error) {
-	var bytes []byte
-
-	end := d.pos + size
-	for ; d.pos < end; d.pos ++ {
-		if d.pos >= len(d.input) {
-			return bytes, fmt.Errorf("'%v' incompatible file format (failed to read %v bytes)",
-			                         d.path, size)
-		}
-
-		bytes = append(bytes, d.input[d.pos])
+func opNames() map[byte]string {
+	ops := make(map[byte]string)
+	for name, inst := range compiler.Insts {
+		ops[inst.Op] = name
 	}
 
-	return bytes, nil
+	return ops
 }
 
-func (d *Disassembler) readMetadata() {
-	// The 'AVM' string
-	magic, err := d.readBytes(3)
-	if err != nil {
-		goerror.SimpleError("Failed to read '%v' magic", d.path)
-		return
+// Instruction with its argument as text, for listings
+func formatInst(ops map[byte]string, inst executable.Inst) string {
+	name, ok := ops[inst.Op]
+	if !ok {
+		return fmt.Sprintf("??? (opcode 0x%02x)", inst.Op)
+	} else if compiler.Insts[name].HasArg {
+		return fmt.Sprintf("%v %v", name, int64(inst.Arg))
 	}
 
-	if string(magic) != "AVM" {
-		goerror.SimpleError("'%v' is not an AVM executable", d.path)
-		return
-	}
+	return name
+}
 
-	// AVM version
-	version, err := d.readBytes(3)
+func (d *Disassembler) readMetadata() {
+	exe, err := executable.Parse(d.input)
 	if err != nil {
-		goerror.SimpleError("Failed to read '%v' version", d.path)
+		goerror.SimpleError("'%v': %v", d.path, err.Error())
 		return
 	}
+	d.exe = exe
 
-	if version[0] != agen.VersionMajor {
+	if exe.Version[0] != agen.VersionMajor {
 		goerror.SimpleWarning("'%v' major version is %v, supported is %v",
-		                      d.path, version[0], agen.VersionMajor)
-	} else if version[1] > agen.VersionMinor {
+		                      d.path, exe.Version[0], agen.VersionMajor)
+	} else if exe.Version[1] > agen.VersionMinor {
 		goerror.SimpleWarning("'%v' minor version is %v, greater than supported version (%v)",
-		                      d.path, version[1], agen.VersionMinor)
+		                      d.path, exe.Version[1], agen.VersionMinor)
 	}
+}
 
-	bytes, err := d.readBytes(agen.WordSize)
-	if err != nil {
-		goerror.SimpleError("Failed to read '%v' program size", d.path)
+func (d *Disassembler) writeInst(name string, data agen.Word, hasArgument bool) {
+	// Inline data is stored as 'nop' instructions with an argument
+	if name == "nop" && data != 0 {
+		fmt.Fprintf(&d.out, "\tdat i64 = %v\n", int64(data))
 		return
 	}
-	d.programSize = agen.Word(binary.BigEndian.Uint64(bytes))
 
-	bytes, err = d.readBytes(agen.WordSize)
-	if err != nil {
-		goerror.SimpleError("Failed to read '%v' memory size", d.path)
-		return
-	}
-	d.memorySize = agen.Word(binary.BigEndian.Uint64(bytes))
+	d.out.WriteString("\t" + name)
 
-	bytes, err = d.readBytes(agen.WordSize)
-	if err != nil {
-		goerror.SimpleError("Failed to read '%v' entry point", d.path)
-		return
+	if hasArgument {
+		f := math.Float64frombits(uint64(data))
+		if isFloatName(name, data) {
+			fmt.Fprintf(&d.out, " %v", node.FormatFloat(f))
+		} else {
+			// Argument as int, signed so it can be assembled back
+			fmt.Fprintf(&d.out, " %v", int64(data))
+
+			// Argument as float (in a comment)
+			fmt.Fprintf(&d.out, "\t\t# %v", f)
+		}
 	}
-	d.entryPoint = agen.Word(binary.BigEndian.Uint64(bytes))
-}
-
-func (d *Disassembler) writeInst(name string, data agen.Word, hasArgument bool) {
-	d.out += "\t" + name
 
-	if hasArgument {
-		// Argument as int
-		d.out += fmt.Sprintf(" %v", uint64(data))
+	d.out.WriteByte('\n')
+}
 
-		// Argument as float (in a comment)
-		d.out += "\t\t# " + fmt.Sprintf("%v", math.Float64frombits(uint64(data)))
+// Infinities and NaN are written by name to instructions taking floats, as long as the name
+// assembles back to the same bits
+func isFloatName(name string, data agen.Word) bool {
+	if operand := compiler.Insts[name].Operand; operand != compiler.FloatOperand &&
+	                                             operand != compiler.AnyOperand {
+		return false
 	}
 
-	d.out += "\n"
+	f := math.Float64frombits(uint64(data))
+	return math.IsInf(f, 0) || data == agen.Word(math.Float64bits(math.NaN()))
 }
 
 func InstFromOp(op byte) (string, bool, error) {
@@ -120,26 +113,20 @@ func InstFromOp(op byte) (string, bool, error) {
 }
 
 func (d *Disassembler) Disassemble(path string) bool {
-	// Skip the shebang
-	if d.input[d.pos] == '#' {
-		for d.input[d.pos] != '\n' {
-			d.pos ++
-		}
-
-		d.pos ++
-	}
-
 	if d.readMetadata(); goerror.Happened() {
 		return false
 	}
 
-	d.out += fmt.Sprintf("# Generated by ANASM disassembler for AVM v%v.%v\n\n",
-	                     agen.VersionMajor, agen.VersionMinor)
+	fmt.Fprintf(&d.out, "# Generated by ANASM disassembler for AVM v%v.%v\n\n",
+	            agen.VersionMajor, agen.VersionMinor)
 
-	if d.readMemory(); goerror.Happened() {
-		return false
+	if d.exe.IsFragment() {
+		d.out.WriteString("# Fragment without an entry point, assemble it with -no-entry\n\n")
 	}
 
+	d.readMeta()
+	d.readMemory()
+
 	if d.readInsts(); goerror.Happened() {
 		return false
 	}
@@ -152,62 +139,97 @@ func (d *Disassembler) Disassemble(path string) bool {
 	}
 	defer f.Close()
 
-	f.Write([]byte(d.out))
+	f.WriteString(d.out.String())
 
 	return true
 }
 
+func (d *Disassembler) readMeta() {
+	entries, err := d.exe.Meta()
+	if err != nil {
+		goerror.SimpleWarning("'%v': %v", d.path, err.Error())
+	}
+
+	for _, entry := range entries {
+		fmt.Fprintf(&d.out, "meta %v %v\n", entry.Key, node.Quote(entry.Value))
+	}
+
+	if len(entries) > 0 {
+		d.out.WriteByte('\n')
+	}
+}
+
 func (d *Disassembler) readMemory() {
-	if d.programSize == 0 || d.memorySize < 2 {
+	if d.exe.ProgramSize == 0 || len(d.exe.Memory) < 2 {
 		return
 	}
 
-	d.out += "let MEM byte ="
+	d.out.WriteString("let MEM byte =")
 
-	_, _ = d.readBytes(1) // Skip the 0 byte
-	for i := agen.Word(0); i < d.memorySize - 1; i ++ {
+	memory := d.exe.Memory[1:] // Skip the 0 byte
+	for i, b := range memory {
 		if i % 8 == 0 {
-			d.out += "\n\t"
+			d.out.WriteString("\n\t")
 		}
 
-		bytes, err := d.readBytes(1)
-		if err != nil {
-			goerror.SimpleError("Failed while reading memory of '%v'", d.path)
+		fmt.Fprintf(&d.out, "%3v", b)
+		if i + 1 < len(memory) {
+			d.out.WriteString(", ")
 		}
+	}
 
-		data := fmt.Sprintf("%v", bytes[0])
-		if len(data) < 3 {
-			data = strings.Repeat(" ", 3 - len(data)) + data
-		}
+	d.out.WriteString("\n\n")
+}
 
-		d.out += data
-		if i + 1 < d.memorySize - 1 {
-			d.out += ", "
-		}
+// Absolute address of the code a relative operand points to
+func (d *Disassembler) relTarget(i agen.Word, inst executable.Inst) (agen.Word, bool) {
+	name, ok := d.ops[inst.Op]
+	if !ok || compiler.Insts[name].Operand != compiler.RelOperand {
+		return 0, false
 	}
 
-	d.out += "\n\n"
+	return i + 1 + inst.Arg, true
+}
+
+func relLabel(target agen.Word) string {
+	return fmt.Sprintf("rel_%v", target)
 }
 
 func (d *Disassembler) readInsts() {
+	// Relative operands get labels, absolute addresses are not distinguishable from numbers
+	labels := make(map[agen.Word]bool)
+	for i := agen.Word(0); i < d.exe.ProgramSize; i ++ {
+		if target, ok := d.relTarget(i, d.exe.Inst(i)); ok && target < d.exe.ProgramSize {
+			labels[target] = true
+		}
+	}
+
 	// Read and convert instructions
-	for i := agen.Word(0); i < d.programSize; i ++ {
-		if i == d.entryPoint {
-			d.out += ".entry\n"
+	for i := agen.Word(0); i < d.exe.ProgramSize; i ++ {
+		if i == d.exe.EntryPoint {
+			d.out.WriteString(".entry\n")
 		}
 
-		bytes, err := d.readBytes(agen.InstSize)
-		if err != nil {
-			goerror.SimpleError("Failed while reading instruction from '%v' at %v", d.path, i)
+		if labels[i] {
+			fmt.Fprintf(&d.out, ".%v\n", relLabel(i))
 		}
 
-		name, hasArg, err := InstFromOp(bytes[0])
-		if err != nil {
-			goerror.SimpleError("At %v: %v", i, err.Error())
+		inst := d.exe.Inst(i)
+		name, ok := d.ops[inst.Op]
+		if !ok {
+			goerror.SimpleError("At %v: Unknown instruction with opcode %v", i, inst.Op)
 		}
 
-		data := agen.Word(binary.BigEndian.Uint64(bytes[1:]))
+		if target, ok := d.relTarget(i, inst); ok {
+			if target < d.exe.ProgramSize {
+				fmt.Fprintf(&d.out, "\t%v %v\n", name, relLabel(target))
+			} else {
+				fmt.Fprintf(&d.out, "\t%v %v\t\t# Outside of the program\n", name, target)
+			}
+
+			continue
+		}
 
-		d.writeInst(name, data, hasArg)
+		d.writeInst(name, inst.Arg, compiler.Insts[name].HasArg)
 	}
 }
@@ -0,0 +1,44 @@
+package disasm_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/avm-collection/anasm/anasmtest"
+	"github.com/avm-collection/anasm/internal/disasm"
+)
+
+// Synthetic program with the given number of loops, with memory, arguments and jumps to labels
+func program(loops int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "let table i64 = 0 .. %v\n\n.entry\n", loops)
+	for i := 0; i < loops; i ++ {
+		fmt.Fprintf(&b, ".loop_%v\n\tpsh table\n\tpsh %v\n\tpsh 2.5\n", i, i)
+		fmt.Fprintf(&b, "\tadd\n\tjnz loop_%v\n", i)
+	}
+
+	b.WriteString("\thlt\n")
+	return b.String()
+}
+
+// Disassembling a large program and assembling it back gives the same bytes
+func TestDisassembleLarge(t *testing.T) {
+	anasmtest.MustRoundTrip(t, program(5000))
+}
+
+func BenchmarkDisassemble(b *testing.B) {
+	input := anasmtest.MustAssembleBytes(b, program(5000))
+	path  := filepath.Join(b.TempDir(), "out.anasm")
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i ++ {
+		if !disasm.New(input, "<bench>").Disassemble(path) {
+			b.Fatal("Failed to disassemble")
+		}
+	}
+}
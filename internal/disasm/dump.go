@@ -0,0 +1,203 @@
+package disasm
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/avm-collection/agen"
+
+	"github.com/avm-collection/anasm/internal/compiler"
+	"github.com/avm-collection/anasm/internal/executable"
+)
+
+// Annotated hexdump of an AVM executable. Truncated files are dumped as far as they go.
+
+const dumpBytesPerLine = 16
+
+func hexBytes(bytes []byte) string {
+	var s strings.Builder
+	for i, b := range bytes {
+		if i > 0 {
+			s.WriteByte(' ')
+		}
+
+		fmt.Fprintf(&s, "%02x", b)
+	}
+
+	return s.String()
+}
+
+func printableBytes(bytes []byte) string {
+	var s strings.Builder
+	for _, b := range bytes {
+		if b >= ' ' && b <= '~' {
+			s.WriteByte(b)
+		} else {
+			s.WriteByte('.')
+		}
+	}
+
+	return s.String()
+}
+
+func dumpField(w io.Writer, offset int, bytes []byte, name string, value interface{}) {
+	fmt.Fprintf(w, "%08x  %-26v  %-13v %v\n", offset, hexBytes(bytes), name, value)
+}
+
+func Dump(w io.Writer, input []byte, path string) error {
+	exe, err := executable.Parse(input)
+
+	offset := 0
+	if len(exe.Shebang) > 0 {
+		fmt.Fprintf(w, "%08x  shebang %q\n", offset, exe.Shebang)
+		offset += len(exe.Shebang)
+	}
+
+	// Header fields, as far as they were read
+	header := input[offset:]
+	if len(header) > executable.HeaderSize {
+		header = header[:executable.HeaderSize]
+	}
+
+	version := fmt.Sprintf("%v.%v.%v", exe.Version[0], exe.Version[1], exe.Version[2])
+
+	var entryPoint interface{} = exe.EntryPoint
+	if exe.IsFragment() {
+		entryPoint = "none (fragment, can not be run)"
+	}
+	fields  := []struct{
+		Name  string
+		Size  int
+		Value interface{}
+	}{
+		{"magic",        len(executable.Magic), fmt.Sprintf("%q", executable.Magic)},
+		{"version",      3,                     version},
+		{"program size", agen.WordSize,         fmt.Sprintf("%v instructions", exe.ProgramSize)},
+		{"memory size",  agen.WordSize,         fmt.Sprintf("%v bytes", exe.MemorySize)},
+		{"entry point",  agen.WordSize,         entryPoint},
+	}
+
+	for _, field := range fields {
+		if len(header) < field.Size {
+			break
+		}
+
+		dumpField(w, offset, header[:field.Size], field.Name, field.Value)
+
+		header  = header[field.Size:]
+		offset += field.Size
+	}
+
+	if exe.Memory != nil {
+		fmt.Fprintf(w, "\nmemory (%v bytes)\n", len(exe.Memory))
+		for i := 0; i < len(exe.Memory); i += dumpBytesPerLine {
+			line := exe.Memory[i:]
+			if len(line) > dumpBytesPerLine {
+				line = line[:dumpBytesPerLine]
+			}
+
+			fmt.Fprintf(w, "%08x  %-47v  |%v|\n", exe.MemoryOffset() + i,
+			            hexBytes(line), printableBytes(line))
+		}
+	}
+
+	if exe.Program != nil {
+		ops := opNames()
+
+		if start := exe.MemoryOffset() + len(exe.Memory); exe.ProgramOffset() > start {
+			fmt.Fprintf(w, "\n%08x  padding (%v bytes)\n", start, exe.ProgramOffset() - start)
+		}
+
+		fmt.Fprintf(w, "\nprogram (%v instructions)\n", exe.InstCount())
+		for i := agen.Word(0); i < exe.InstCount(); i ++ {
+			bytes := exe.Program[int(i) * agen.InstSize:int(i + 1) * agen.InstSize]
+
+			entry := ""
+			if i == exe.EntryPoint {
+				entry = "  <- entry"
+			}
+
+			fmt.Fprintf(w, "%08x  %-26v  %6v: %v%v\n", exe.ProgramOffset() + int(i) * agen.InstSize,
+			            hexBytes(bytes), i, formatInst(ops, exe.Inst(i)), entry)
+		}
+
+		if rest := len(exe.Program) % agen.InstSize; rest != 0 {
+			fmt.Fprintf(w, "%08x  %v  (incomplete instruction)\n",
+			            exe.ProgramOffset() + len(exe.Program) - rest,
+			            hexBytes(exe.Program[len(exe.Program) - rest:]))
+		}
+	}
+
+	if end := exe.ProgramOffset() + len(exe.Program); err == nil && exe.SectionsOffset > end {
+		fmt.Fprintf(w, "\n%08x  padding (%v bytes)\n", end, exe.SectionsOffset - end)
+	}
+
+	if len(exe.Sections) > 0 {
+		fmt.Fprintf(w, "\nsections (%v)\n", len(exe.Sections))
+
+		offset := exe.SectionsOffset
+		for _, section := range exe.Sections {
+			fmt.Fprintf(w, "%08x  %-26q  %v bytes\n", offset, section.Tag, len(section.Data))
+			offset += executable.SectionTagSize + agen.WordSize + len(section.Data)
+
+			if section.Tag == executable.MetaTag || section.Tag == executable.StampTag {
+				entries, err := executable.DecodeMeta(section.Data)
+				for _, entry := range entries {
+					fmt.Fprintf(w, "%10v%v = %q\n", "", entry.Key, entry.Value)
+				}
+
+				if err != nil {
+					fmt.Fprintf(w, "%10v(%v)\n", "", err.Error())
+				}
+			} else if section.Tag == executable.AlignTag {
+				fmt.Fprintf(w, "%10valign = %v\n", "", exe.Align)
+			}
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("'%v': %v", path, err.Error())
+	}
+
+	return nil
+}
+
+// Hexdump of the memory a program starts with, each line in one region and the region named in
+// the left column. Dumps stop after max bytes, unless max is 0.
+func DumpMemory(w io.Writer, memory []byte, regions []compiler.Region, max int) {
+	width := len("(padding)")
+	for _, region := range regions {
+		if name := strings.Join(region.Names, ", "); len(name) > width {
+			width = len(name)
+		}
+	}
+
+	fmt.Fprintf(w, "memory (%v bytes)\n", len(memory))
+
+	printed := 0
+	for _, region := range regions {
+		name := strings.Join(region.Names, ", ")
+		if len(region.Names) == 0 {
+			name = "(padding)"
+		}
+
+		for i := int(region.Addr); i < int(region.Addr + region.Size); i += dumpBytesPerLine {
+			if max > 0 && printed >= max {
+				fmt.Fprintf(w, "... %v more bytes\n", len(memory) - printed)
+				return
+			}
+
+			end := i + dumpBytesPerLine
+			if end > int(region.Addr + region.Size) {
+				end = int(region.Addr + region.Size)
+			}
+
+			fmt.Fprintf(w, "%-*v  %08x  %-47v  |%v|\n", width, name, i, hexBytes(memory[i:end]),
+			            printableBytes(memory[i:end]))
+
+			name     = ""
+			printed += end - i
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package disasm
+
+import "testing"
+
+// The opcode map names every opcode like the instruction table does
+func TestOpNames(t *testing.T) {
+	ops := opNames()
+	for op := 0; op < 256; op ++ {
+		want, _, err := InstFromOp(byte(op))
+		if got, ok := ops[byte(op)]; ok != (err == nil) || got != want {
+			t.Errorf("Opcode %v is named '%v', expected '%v'", op, got, want)
+		}
+	}
+}
+
+// Looking opcodes up in the table, as the disassembler did for every instruction, against the map
+// it builds once
+func BenchmarkOpLookup(b *testing.B) {
+	b.Run("table", func(b *testing.B) {
+		for i := 0; i < b.N; i ++ {
+			InstFromOp(byte(i))
+		}
+	})
+
+	b.Run("map", func(b *testing.B) {
+		ops := opNames()
+		for i := 0; i < b.N; i ++ {
+			_ = ops[byte(i)]
+		}
+	})
+}
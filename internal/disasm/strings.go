@@ -0,0 +1,91 @@
+package disasm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/avm-collection/agen"
+
+	"github.com/avm-collection/anasm/internal/compiler"
+	"github.com/avm-collection/anasm/internal/executable"
+	"github.com/avm-collection/anasm/internal/node"
+)
+
+// Printable texts in an executable (-strings), to audit what a binary contains. Runs of printable
+// ASCII characters and tabs are searched in the memory, and optionally in the instruction
+// arguments, where inline data lives.
+
+type StringsOptions struct {
+	MinLen  int  // Shorter runs are skipped
+	Program bool // Also search the instruction arguments
+
+	Symbols []compiler.Import // Variables that texts in the memory are shown as parts of
+}
+
+func isPrintable(b byte) bool {
+	return (b >= ' ' && b <= '~') || b == '\t'
+}
+
+// Calls found with the offset and the text of every long enough run
+func findStrings(data []byte, minLen int, found func(offset int, text string)) {
+	start := -1
+	for i := 0; i <= len(data); i ++ {
+		if i < len(data) && isPrintable(data[i]) {
+			if start == -1 {
+				start = i
+			}
+
+			continue
+		}
+
+		if start != -1 && i - start >= minLen {
+			found(start, string(data[start:i]))
+		}
+
+		start = -1
+	}
+}
+
+// Name of the variable holding the address, with the offset into it
+func owner(symbols []compiler.Import, addr agen.Word) string {
+	for _, sym := range symbols {
+		if sym.Label || addr < sym.Addr || addr >= sym.Addr + sym.Size {
+			continue
+		} else if addr == sym.Addr {
+			return sym.Name
+		}
+
+		return fmt.Sprintf("%v+%v", sym.Name, addr - sym.Addr)
+	}
+
+	return "-"
+}
+
+func Strings(w io.Writer, input []byte, opts StringsOptions) error {
+	exe, err := executable.Parse(input)
+	if err != nil {
+		return err
+	}
+
+	findStrings(exe.Memory, opts.MinLen, func(offset int, text string) {
+		fmt.Fprintf(w, "memory  0x%08X  %-24v %v\n", offset,
+		            owner(opts.Symbols, agen.Word(offset)), node.Quote(text))
+	})
+
+	if !opts.Program {
+		return nil
+	}
+
+	// The arguments are joined, so texts spanning several inline data slots are found whole
+	args := make([]byte, exe.InstCount() * agen.WordSize)
+	for i := agen.Word(0); i < exe.InstCount(); i ++ {
+		binary.BigEndian.PutUint64(args[i * agen.WordSize:], uint64(exe.Inst(i).Arg))
+	}
+
+	findStrings(args, opts.MinLen, func(offset int, text string) {
+		fmt.Fprintf(w, "program %-10v  %-24v %v\n", offset / agen.WordSize, "-", node.Quote(text))
+	})
+
+	return nil
+}
@@ -0,0 +1,201 @@
+package executable
+
+import (
+	"fmt"
+	"encoding/binary"
+
+	"github.com/avm-collection/agen"
+)
+
+const (
+	Magic      = "AVM"
+	HeaderSize = len(Magic) + 3 + agen.WordSize * 3
+)
+
+// Entry point of fragments, code assembled to be joined with other code and not run on its own.
+// Out of range of any program, so the VM refuses to run them.
+const NoEntry = ^agen.Word(0)
+
+type Executable struct {
+	Shebang string // Including the new line, empty if there is none
+
+	Version     [3]byte // Major, minor, patch
+	ProgramSize agen.Word
+	MemorySize  agen.Word
+	EntryPoint  agen.Word
+
+	Memory  []byte
+	Program []byte
+
+	Sections       []Section // Optional sections after the program
+	SectionsOffset int       // Offset of the sections from the start of the file
+
+	// If not 0, the program section starts and ends at a multiple of Align bytes from the start of
+	// the file, padded with zeros. Stored in an ALGN section.
+	Align int
+}
+
+type Inst struct {
+	Op  byte
+	Arg agen.Word
+}
+
+// Parses an AVM executable. If the file is truncated, the parts that were read are returned along
+// with the error
+func Parse(data []byte) (*Executable, error) {
+	e   := &Executable{}
+	pos := 0
+
+	if len(data) > 0 && data[0] == '#' {
+		for pos < len(data) && data[pos] != '\n' {
+			pos ++
+		}
+
+		if pos < len(data) {
+			pos ++
+		}
+
+		e.Shebang = string(data[:pos])
+	}
+
+	sections, end, err := parseSections(data)
+	if err != nil {
+		return e, err
+	}
+
+	// The sections can claim bytes before pos in a broken file
+	left := func() int {
+		if pos > end {
+			return 0
+		}
+
+		return end - pos
+	}
+
+	// Sizes are compared unconverted, as sizes from the header can be too big for an int
+	read := func(size uint64, what string) ([]byte, error) {
+		if size > uint64(left()) {
+			return nil, fmt.Errorf("Truncated %v (expected %v bytes, got %v)",
+			                       what, size, left())
+		}
+
+		bytes := data[pos:pos + int(size)]
+		pos += int(size)
+
+		return bytes, nil
+	}
+
+	magic, err := read(uint64(len(Magic)), "magic")
+	if err != nil {
+		return e, err
+	} else if string(magic) != Magic {
+		return e, fmt.Errorf("Not an AVM executable")
+	}
+
+	version, err := read(3, "version")
+	if err != nil {
+		return e, err
+	}
+	copy(e.Version[:], version)
+
+	fields := []*agen.Word{&e.ProgramSize, &e.MemorySize, &e.EntryPoint}
+	names  := []string{"program size", "memory size", "entry point"}
+	for i, field := range fields {
+		bytes, err := read(agen.WordSize, names[i])
+		if err != nil {
+			return e, err
+		}
+
+		*field = agen.Word(binary.BigEndian.Uint64(bytes))
+	}
+
+	if e.Memory, err = read(uint64(e.MemorySize), "memory"); err != nil {
+		e.Memory = data[pos:pos + left()]
+		return e, err
+	}
+
+	for _, section := range sections {
+		if section.Tag == AlignTag {
+			if e.Align, err = decodeAlign(section.Data); err != nil {
+				return e, err
+			}
+		}
+	}
+
+	if _, err = read(uint64(padding(pos, e.Align)), "program padding"); err != nil {
+		return e, err
+	}
+
+	if uint64(e.ProgramSize) > uint64(left()) / agen.InstSize {
+		e.Program = data[pos:pos + left()]
+		return e, fmt.Errorf("Truncated program (expected %v instructions, got %v bytes)",
+		                     e.ProgramSize, left())
+	}
+
+	e.Program, _ = read(uint64(e.ProgramSize) * agen.InstSize, "program")
+
+	if _, err = read(uint64(padding(pos, e.Align)), "program padding"); err != nil {
+		return e, err
+	}
+
+	e.Sections       = sections
+	e.SectionsOffset = end
+	return e, nil
+}
+
+// Offset of the memory section from the start of the file
+func (e *Executable) MemoryOffset() int {
+	return len(e.Shebang) + HeaderSize
+}
+
+// Offset of the program section from the start of the file
+func (e *Executable) ProgramOffset() int {
+	offset := e.MemoryOffset() + len(e.Memory)
+	return offset + padding(offset, e.Align)
+}
+
+// Zero bytes needed to get from the offset to a multiple of the alignment
+func padding(offset, align int) int {
+	if align == 0 {
+		return 0
+	}
+
+	return (align - offset % align) % align
+}
+
+// Encodes the executable, with the program aligned and the sections
+func (e *Executable) Bytes() []byte {
+	bytes := []byte(e.Shebang)
+	bytes  = append(bytes, Magic...)
+	bytes  = append(bytes, e.Version[:]...)
+
+	for _, field := range []agen.Word{e.ProgramSize, e.MemorySize, e.EntryPoint} {
+		var buf [agen.WordSize]byte
+		binary.BigEndian.PutUint64(buf[:], uint64(field))
+
+		bytes = append(bytes, buf[:]...)
+	}
+
+	bytes = append(bytes, e.Memory...)
+	bytes = append(bytes, make([]byte, padding(len(bytes), e.Align))...)
+	bytes = append(bytes, e.Program...)
+	bytes = append(bytes, make([]byte, padding(len(bytes), e.Align))...)
+
+	return append(bytes, EncodeSections(e.Sections)...)
+}
+
+func (e *Executable) IsFragment() bool {
+	return e.EntryPoint == NoEntry
+}
+
+// Count of complete instructions in the program section. Every instruction is agen.InstSize
+// bytes, with or without an argument: the VM loads the program as an array and code addresses
+// are indices into it, so there is no denser encoding it could run.
+func (e *Executable) InstCount() agen.Word {
+	return agen.Word(len(e.Program) / agen.InstSize)
+}
+
+func (e *Executable) Inst(i agen.Word) Inst {
+	bytes := e.Program[int(i) * agen.InstSize:]
+	return Inst{Op: bytes[0], Arg: agen.Word(binary.BigEndian.Uint64(bytes[1:agen.InstSize]))}
+}
@@ -0,0 +1,72 @@
+package executable
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/avm-collection/agen"
+)
+
+// Header of an executable with the given program size, memory size and entry point
+func header(fields ...uint64) []byte {
+	data := append([]byte(Magic), 1, 0, 0)
+	for _, field := range fields {
+		var buf [agen.WordSize]byte
+		binary.BigEndian.PutUint64(buf[:], field)
+
+		data = append(data, buf[:]...)
+	}
+
+	return data
+}
+
+// Sizes in broken files have to be reported as truncated parts, not overflow the offsets
+var broken = []struct {
+	name string
+	data []byte
+}{
+	{"memory size over int",     header(0, 1 << 63, 0)},
+	{"memory size of all bits",  header(0, ^uint64(0), 0)},
+	{"program size over int",    header(1 << 63, 0, 0)},
+	{"program bytes overflow",   header(^uint64(0) / agen.InstSize + 1, 0, 0)},
+	{"program size of all bits", header(^uint64(0), 0, 0)},
+	{"sections over the header", append(append([]byte("#!\n"), header(0, 0, 0)...),
+	                                    0, 0, 0, 0, 0, 0, 0, 12, 'A', 'V', 'M', 'X')},
+}
+
+func TestParseBroken(t *testing.T) {
+	for _, tt := range broken {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.data); err == nil {
+				t.Errorf("Parsed without an error")
+			}
+		})
+	}
+}
+
+// Broken executables have to be errors, never crash the parser or reading the instructions.
+// Inputs that crashed once are in testdata/fuzz/FuzzParse.
+func FuzzParse(f *testing.F) {
+	f.Add(header(0, 0, 0))
+	f.Add(append(header(1, 2, 0), 0xAB, 0xCD, 0x10, 0, 0, 0, 0, 0, 0, 0, 5))
+	f.Add(append([]byte("#!/usr/bin/avm\n"), header(0, 0, 0)...))
+
+	for _, tt := range broken {
+		f.Add(tt.data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		e, err := Parse(data)
+		if err != nil {
+			return
+		}
+
+		for i := agen.Word(0); i < e.InstCount(); i ++ {
+			e.Inst(i)
+		}
+
+		if _, err := Parse(e.Bytes()); err != nil {
+			t.Errorf("Encoded executable does not parse again: %v", err)
+		}
+	})
+}
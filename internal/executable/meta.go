@@ -0,0 +1,89 @@
+package executable
+
+import (
+	"fmt"
+	"encoding/binary"
+)
+
+// Metadata section data is a list of entries, every entry is the key size as a byte, the key, the
+// value size as 2 bytes and the value
+
+const (
+	MaxMetaKey  = 32
+	MaxMetaSize = 4096 // Of all the entries together
+)
+
+type Meta struct {
+	Key, Value string
+}
+
+func EncodeMeta(entries []Meta) ([]byte, error) {
+	bytes := []byte{}
+	for _, entry := range entries {
+		if len(entry.Key) == 0 {
+			return nil, fmt.Errorf("Metadata key is empty")
+		} else if len(entry.Key) > MaxMetaKey {
+			return nil, fmt.Errorf("Metadata key '%v' is longer than %v bytes",
+			                       entry.Key, MaxMetaKey)
+		}
+
+		bytes = append(bytes, byte(len(entry.Key)))
+		bytes = append(bytes, entry.Key...)
+
+		var size [2]byte
+		binary.BigEndian.PutUint16(size[:], uint16(len(entry.Value)))
+		bytes = append(bytes, size[:]...)
+		bytes = append(bytes, entry.Value...)
+
+		if len(bytes) > MaxMetaSize {
+			return nil, fmt.Errorf("Metadata is bigger than %v bytes (at key '%v')",
+			                       MaxMetaSize, entry.Key)
+		}
+	}
+
+	return bytes, nil
+}
+
+func DecodeMeta(data []byte) ([]Meta, error) {
+	entries := []Meta{}
+	for len(data) > 0 {
+		size := int(data[0])
+		if len(data) < 1 + size + 2 {
+			return entries, fmt.Errorf("Truncated metadata entry")
+		}
+
+		key := string(data[1:1 + size])
+		data = data[1 + size:]
+
+		size = int(binary.BigEndian.Uint16(data))
+		data = data[2:]
+		if len(data) < size {
+			return entries, fmt.Errorf("Truncated metadata value of '%v'", key)
+		}
+
+		entries = append(entries, Meta{Key: key, Value: string(data[:size])})
+		data    = data[size:]
+	}
+
+	return entries, nil
+}
+
+// Metadata entries of the executable, nil if it has no metadata section
+func (e *Executable) Meta() ([]Meta, error) {
+	section, ok := e.Section(MetaTag)
+	if !ok {
+		return nil, nil
+	}
+
+	return DecodeMeta(section.Data)
+}
+
+// Build information entries of the executable, nil if it has no stamp section
+func (e *Executable) Stamp() ([]Meta, error) {
+	section, ok := e.Section(StampTag)
+	if !ok {
+		return nil, nil
+	}
+
+	return DecodeMeta(section.Data)
+}
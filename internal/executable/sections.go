@@ -0,0 +1,154 @@
+package executable
+
+import (
+	"fmt"
+	"encoding/binary"
+
+	"github.com/avm-collection/agen"
+)
+
+// Optional sections after the program, which loaders that do not know about them ignore. Every
+// section is a 4 byte tag, the size of its data as a word and the data. The sections are followed
+// by their total size (without this footer) as a word and SectionsMagic, so they can be found from
+// the end of the file.
+//
+// The alignment section is the exception. The padding it describes is only found through it, so
+// a loader that ignores it reads the padding as the program. Aligned executables need a loader
+// that knows the section.
+
+const (
+	SectionsMagic      = "AVMX"
+	SectionsFooterSize = agen.WordSize + len(SectionsMagic)
+	SectionTagSize     = 4
+
+	MetaTag  = "META" // Key/value metadata
+	StampTag = "STMP" // Build information as key/value entries like the metadata
+	AlignTag = "ALGN" // Alignment of the program section as a word
+
+	MaxAlign = 1 << 24
+)
+
+type Section struct {
+	Tag  string
+	Data []byte
+}
+
+func EncodeSections(sections []Section) []byte {
+	if len(sections) == 0 {
+		return nil
+	}
+
+	var buf [agen.WordSize]byte
+
+	bytes := []byte{}
+	for _, section := range sections {
+		bytes = append(bytes, section.Tag...)
+
+		binary.BigEndian.PutUint64(buf[:], uint64(len(section.Data)))
+		bytes = append(bytes, buf[:]...)
+		bytes = append(bytes, section.Data...)
+	}
+
+	binary.BigEndian.PutUint64(buf[:], uint64(len(bytes)))
+	bytes = append(bytes, buf[:]...)
+	return append(bytes, SectionsMagic...)
+}
+
+// Finds the sections at the end of the data, returns the offset they start at (len(data) if there
+// are none)
+func parseSections(data []byte) ([]Section, int, error) {
+	if len(data) < SectionsFooterSize ||
+	   string(data[len(data) - len(SectionsMagic):]) != SectionsMagic {
+		return nil, len(data), nil
+	}
+
+	footer := data[len(data) - SectionsFooterSize:]
+	size   := binary.BigEndian.Uint64(footer[:agen.WordSize])
+	if size > uint64(len(data) - SectionsFooterSize) {
+		return nil, len(data), fmt.Errorf("Sections size %v is bigger than the file", size)
+	}
+
+	start := len(data) - SectionsFooterSize - int(size)
+	rest  := data[start:len(data) - SectionsFooterSize]
+
+	sections := []Section{}
+	for len(rest) > 0 {
+		if len(rest) < SectionTagSize + agen.WordSize {
+			return nil, start, fmt.Errorf("Truncated section header")
+		}
+
+		tag  := string(rest[:SectionTagSize])
+		size := binary.BigEndian.Uint64(rest[SectionTagSize:SectionTagSize + agen.WordSize])
+		rest  = rest[SectionTagSize + agen.WordSize:]
+
+		if size > uint64(len(rest)) {
+			return nil, start, fmt.Errorf("Truncated section '%v' (expected %v bytes, got %v)",
+			                              tag, size, len(rest))
+		}
+
+		sections = append(sections, Section{Tag: tag, Data: rest[:size]})
+		rest     = rest[size:]
+	}
+
+	return sections, start, nil
+}
+
+func EncodeAlign(align int) []byte {
+	var buf [agen.WordSize]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(align))
+
+	return buf[:]
+}
+
+func decodeAlign(data []byte) (int, error) {
+	if len(data) != agen.WordSize {
+		return 0, fmt.Errorf("Alignment section has %v bytes, expected %v",
+		                     len(data), agen.WordSize)
+	}
+
+	align := binary.BigEndian.Uint64(data)
+	if err := CheckAlign(align); err != nil {
+		return 0, err
+	}
+
+	return int(align), nil
+}
+
+func CheckAlign(align uint64) error {
+	if align == 0 || align & (align - 1) != 0 {
+		return fmt.Errorf("Alignment %v is not a power of two", align)
+	} else if align > MaxAlign {
+		return fmt.Errorf("Alignment %v is bigger than %v", align, MaxAlign)
+	}
+
+	return nil
+}
+
+func (e *Executable) Section(tag string) (Section, bool) {
+	for _, section := range e.Sections {
+		if section.Tag == tag {
+			return section, true
+		}
+	}
+
+	return Section{}, false
+}
+
+// Removes the optional sections, everything before them is kept as is. The alignment section is
+// kept if there is one, the program can not be found without it.
+func Strip(data []byte) ([]byte, error) {
+	exe, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	kept := []Section{}
+	for _, section := range exe.Sections {
+		if section.Tag == AlignTag {
+			kept = append(kept, section)
+		}
+	}
+
+	stripped := append([]byte{}, data[:exe.SectionsOffset]...)
+	return append(stripped, EncodeSections(kept)...), nil
+}
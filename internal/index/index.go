@@ -0,0 +1,196 @@
+package index
+
+import (
+	"github.com/avm-collection/anasm/internal/lexer"
+	"github.com/avm-collection/anasm/internal/node"
+	"github.com/avm-collection/anasm/internal/parser"
+	"github.com/avm-collection/anasm/internal/token"
+)
+
+// Index of symbol definitions and references of a file. Definitions come from the parsed program,
+// so the ones in included files are found too. References are taken from the tokens of the file
+// and resolved like in the parser, so they are found in files that do not compile. Definitions
+// the parser dropped with an error are taken from the tokens too.
+
+type Kind int
+const (
+	Label = Kind(iota)
+	Var
+	Macro
+	Embed
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Label: return "label"
+	case Var:   return "variable"
+	case Macro: return "macro"
+	case Embed: return "embedded file"
+
+	default: panic("Unreachable")
+	}
+}
+
+type Symbol struct {
+	Name  string
+	Kind  Kind
+	Where token.Where
+	File  string // Read for the definition, see parser.Parser.IncludedFile
+}
+
+type Index struct {
+	Tokens []token.Token
+	Defs   map[string]Symbol        // By fully qualified name
+	Refs   map[string][]token.Where // By the fully qualified name they resolve to, in the file
+
+	Errors []token.Token // Lexer errors
+
+	names map[int]string // Offset of a name token -> fully qualified name, see Name
+}
+
+type ref struct {
+	tok   token.Token
+	scope string // Namespace it is used in
+}
+
+// Included files are searched for in the include directories like when assembling
+func Build(input, path string, includeDirs []string) *Index {
+	idx := &Index{
+		Defs:  make(map[string]Symbol),
+		Refs:  make(map[string][]token.Where),
+		names: make(map[int]string),
+	}
+
+	p := parser.New(input, path)
+	p.IncludeDirs  = includeDirs
+	p.Diags.Silent = true
+
+	for _, s := range p.Parse().List {
+		if node.IsNil(s) {
+			continue
+		}
+
+		switch n := s.(type) {
+		case *node.Label: idx.define(n.Name, Label, p)
+		case *node.Let:   idx.define(n.Name, Var,   p)
+		case *node.Macro: idx.define(n.Name, Macro, p)
+		case *node.Embed: idx.define(n.Name, Embed, p)
+		}
+	}
+
+	l := lexer.New(input, path)
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		if tok.Type == token.Error {
+			idx.Errors = append(idx.Errors, tok)
+			continue
+		}
+
+		idx.Tokens = append(idx.Tokens, tok)
+	}
+
+	// Namespaces qualify the names defined in them like in the parser, uses are resolved once
+	// every definition is known
+	namespaces := []string{}
+	prefix     := func() string {
+		if len(namespaces) == 0 {
+			return ""
+		}
+
+		return namespaces[len(namespaces) - 1]
+	}
+
+	refs := []ref{}
+	for i, tok := range idx.Tokens {
+		prev := token.EOF
+		if i > 0 {
+			prev = idx.Tokens[i - 1].Type
+		}
+
+		switch tok.Type {
+		case token.Label:
+			if tok.Data != "data" && tok.Data != "text" { // Section directives
+				idx.defineToken(tok, prefix(), Label, path)
+			}
+
+		case token.End:
+			if len(namespaces) > 0 {
+				namespaces = namespaces[:len(namespaces) - 1]
+			}
+
+		case token.Id:
+			switch prev {
+			case token.Let:       idx.defineToken(tok, prefix(), Var,   path)
+			case token.Macro:     idx.defineToken(tok, prefix(), Macro, path)
+			case token.Embed:     idx.defineToken(tok, prefix(), Embed, path)
+			case token.Namespace: namespaces = append(namespaces, qualify(prefix(), tok.Data))
+
+			default: refs = append(refs, ref{tok: tok, scope: prefix()})
+			}
+		}
+	}
+
+	defined := make(map[string]bool)
+	for name := range idx.Defs {
+		defined[name] = true
+	}
+
+	for _, r := range refs {
+		name := parser.Resolve(r.tok.Data, r.scope, defined)
+
+		idx.names[r.tok.Where.Offset] = name
+		idx.Refs[name] = append(idx.Refs[name], r.tok.Where)
+	}
+
+	return idx
+}
+
+func qualify(prefix, name string) string {
+	if len(prefix) > 0 {
+		return prefix + "." + name
+	}
+
+	return name
+}
+
+// Definitions of the parsed program, in the file or an included one
+func (idx *Index) define(id *node.Id, kind Kind, p *parser.Parser) {
+	if node.IsNil(id) {
+		return
+	} else if _, ok := idx.Defs[id.Value]; ok {
+		return // Only the first definition counts, like in the compiler
+	}
+
+	where := id.Token.Where
+	idx.Defs[id.Value] = Symbol{Name: id.Value, Kind: kind, Where: where,
+	                            File: p.IncludedFile(where.Path)}
+}
+
+// Definitions in the tokens of the file, which are only added if the parser dropped them
+func (idx *Index) defineToken(tok token.Token, prefix string, kind Kind, path string) {
+	name := qualify(prefix, tok.Data)
+	idx.names[tok.Where.Offset] = name
+
+	if _, ok := idx.Defs[name]; !ok {
+		idx.Defs[name] = Symbol{Name: name, Kind: kind, Where: tok.Where, File: path}
+	}
+}
+
+// Fully qualified name the token defines or refers to
+func (idx *Index) Name(tok token.Token) string {
+	if name, ok := idx.names[tok.Where.Offset]; ok {
+		return name
+	}
+
+	return tok.Data
+}
+
+// Token at the row and column, both starting at 1
+func (idx *Index) At(row, col int) (token.Token, bool) {
+	for _, tok := range idx.Tokens {
+		if tok.Where.Row == row && col >= tok.Where.Col && col < tok.Where.Col + tok.Where.Len {
+			return tok, true
+		}
+	}
+
+	return token.Token{}, false
+}
@@ -1,27 +1,65 @@
 package lexer
 
 import (
+	"fmt"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/avm-collection/anasm/internal/token"
 )
 
-const EOF = '\x00'
+const (
+	EOF = '\x00'
+	BOM = "\xEF\xBB\xBF" // Some editors start UTF-8 files with it
+)
 
 type Lexer struct {
 	input string
 	pos   int
 	ch    byte
+	start int // Position after the BOM
 
 	lineStart int
 
 	where token.Where
+	last  token.Where // Of the previous character
+
+	invalid *token.Token // Error in the string just lexed, which is returned before it
+	pending *token.Token // String returned after its error
+
+	continued map[int]bool   // Rows ending with a '\', see Continued
+	comments  map[int]string // Row -> text of its comment, see Comment
+	ordered   []LineComment  // The comments in order, see LineComments
+
+	Comments     []string     // Line comment introducers
+	UnicodeNames bool         // Lex non-ASCII characters into identifiers, see CheckName
+	IncludedFrom *token.Where // Set on every token, for files that are included
+}
+
+var DefaultComments = []string{"#", ";"}
+
+// Checks if the string can introduce a comment without swallowing other tokens
+func CheckComment(comment string) error {
+	if len(comment) == 0 {
+		return fmt.Errorf("Comment introducer is empty")
+	}
+
+	switch ch := comment[0]; {
+	case (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || isDecDigit(ch),
+	     ch == '_', ch == '$', ch == '"', ch == '\'', ch == '.', ch == '(', ch == ')',
+	     ch == ',', ch == '=', ch == '!', isWhitespace(ch):
+		return fmt.Errorf("Comment introducer '%v' can not start with '%v'",
+		                  comment, string(ch))
+	}
+
+	return nil
 }
 
 var Keywords = map[string]token.Type{
 	"let": token.Let,
 	"mac": token.Macro,
 	"emb": token.Embed,
+	"dat": token.Data,
 
 	"byte": token.TypeByte,
 	"char": token.TypeChar,
@@ -31,6 +69,12 @@ var Keywords = map[string]token.Type{
 	"f64":  token.TypeFloat64,
 
 	"sizeof": token.SizeOf,
+	"bits":   token.Bits,
+	"pad":    token.Pad,
+	"strlen": token.StrLen,
+	"strcat": token.StrCat,
+
+	"defined": token.Defined,
 
 	"+": token.Add,
 	"-": token.Sub,
@@ -44,17 +88,42 @@ var Keywords = map[string]token.Type{
 	">>": token.BitSRight,
 	"<<": token.BitSLeft,
 
+	"==": token.CmpEq,
+	"!=": token.CmpNotEq,
+	"<":  token.CmpLess,
+	"<=": token.CmpLessEq,
+	">":  token.CmpGreater,
+	">=": token.CmpGreaterEq,
+
+	"&&": token.LogAnd,
+	"||": token.LogOr,
+	"!":  token.LogNot,
+
 	"include": token.Include,
+	"incstr":  token.IncStr,
+	"bytes":   token.Bytes,
+	"meta":    token.Meta,
+	"assert":  token.Assert,
+	"org":     token.Org,
+
+	"namespace": token.Namespace,
+	"end":       token.End,
 }
 
 func New(input, path string) *Lexer {
-	l := &Lexer{input: input, pos: -1}
-	l.next()
-
+	l := &Lexer{input: input, pos: -1, Comments: DefaultComments}
+	if strings.HasPrefix(input, BOM) {
+		l.start     = len(BOM)
+		l.pos       = l.start - 1
+		l.lineStart = l.start
+	}
+	// Before reading the first character, which moves to the next line if it is a new line
 	l.where.Row  = 1
 	l.where.Path = path
 	l.where.Line = l.getLine()
 
+	l.next()
+
 	return l
 }
 
@@ -69,6 +138,10 @@ func isIdCh(ch byte) bool {
 	}
 }
 
+func (l *Lexer) isIdCh(ch byte) bool {
+	return isIdCh(ch) || (l.UnicodeNames && ch >= utf8.RuneSelf)
+}
+
 func isDecDigit(ch byte) bool {
 	return ch >= '0' && ch <= '9'
 }
@@ -96,22 +169,42 @@ func isWhitespace(ch byte) bool {
 }
 
 func (l *Lexer) NextToken() (tok token.Token) {
+	if l.pending != nil {
+		tok, l.pending = *l.pending, nil
+		return tok
+	}
+
 	for {
 		start := l.where
 
-		switch l.ch {
-		case EOF: return token.NewEOF(l.where)
-
-		case '#':
+		// A '#' at the very start is a shebang line, so sources can be made executable
+		if (l.pos == l.start && l.ch == '#') || l.atComment() {
 			l.skipComment()
 
 			continue
+		}
+
+		switch l.ch {
+		case EOF:
+			where             := l.where
+			where.IncludedFrom = l.IncludedFrom
+			return token.NewEOF(where)
+
+		case '\\':
+			if l.lexContinuation() {
+				continue
+			}
+
+			tok = token.NewError(start, "Expected the end of the line after '\\', which " +
+			                     "continues the line on the next one")
 
 		case '"':  tok = l.lexString()
 		case '\'': tok = l.lexChar()
 
 		case '.':
-			if l.peek() == '.' {
+			if isDecDigit(l.peek()) {
+				tok = l.lexDec()
+			} else if l.peek() == '.' {
 				l.next()
 
 				tok = token.Token{Type: token.Dots, Data: ".."}
@@ -121,7 +214,7 @@ func (l *Lexer) NextToken() (tok token.Token) {
 			}
 
 		case '-':
-			if isDecDigit(l.peek()) {
+			if isDecDigit(l.peek()) || (l.peek() == '.' && isDecDigit(l.peekAt(2))) {
 				tok = l.lexNum()
 			} else {
 				tok = l.lexId()
@@ -140,29 +233,102 @@ func (l *Lexer) NextToken() (tok token.Token) {
 			l.next()
 
 		case '=':
-			tok = token.Token{Type: token.Equals, Data: string(l.ch)}
+			if l.peek() == '=' {
+				l.next()
+
+				tok = token.Token{Type: token.CmpEq, Data: "=="}
+			} else {
+				tok = token.Token{Type: token.Equals, Data: string(l.ch)}
+			}
+			l.next()
+
+		case '!':
+			if l.peek() == '=' {
+				l.next()
+
+				tok = token.Token{Type: token.CmpNotEq, Data: "!="}
+			} else {
+				tok = token.Token{Type: token.LogNot, Data: string(l.ch)}
+			}
 			l.next()
 
 		default:
 			if isDecDigit(l.ch) {
 				tok = l.lexNum()
-			} else if isIdCh(l.ch) {
+			} else if l.isIdCh(l.ch) {
 				tok = l.lexId()
 			} else if isWhitespace(l.ch) {
 				l.next()
 
 				continue
 			} else {
-				l.where.Len = 1
-				return token.NewError(l.where, "Unexpected character '%v'", string(l.ch))
+				// Runs of invalid characters are reported once, binary files would flood the
+				// output otherwise
+				from := l.pos
+				for !l.canStartToken() {
+					l.next()
+				}
+
+				switch run := l.input[from:l.pos]; {
+				case !utf8.ValidString(run): tok = token.NewError(start, "Invalid UTF-8 encoding")
+
+				// Labels of other assemblers
+				case run == ":" && len(l.nameBefore(from)) > 0:
+					tok = token.NewError(start, "Unexpected character ':', labels are written " +
+					                     "as '.%v'", l.nameBefore(from))
+
+				case len(run) == 1:  tok = token.NewError(start, "Unexpected character '%v'", run)
+				case len(run) <= 16: tok = token.NewError(start, "Unexpected characters %q", run)
+
+				default: tok = token.NewError(start, "%v unexpected characters", len(run))
+				}
 			}
 		}
 
-		tok.Where = start
-		if l.where.Row != start.Row {
-			tok.Where.Len = len(start.Line) - start.Col + 1
+		// Skip the rest of a malformed token, so lexing continues after it
+		if tok.Type == token.Error {
+			l.skipWord()
+		}
+
+		// Errors with a length already point at the exact characters inside the token, like a
+		// bad escape sequence in a string
+		if tok.Type == token.Error && tok.Where.Len > 0 {
+			tok.Where.EndRow    = tok.Where.Row
+			tok.Where.EndCol    = tok.Where.Col    + tok.Where.Len
+			tok.Where.EndOffset = tok.Where.Offset + tok.Where.Len
 		} else {
-			tok.Where.Len = l.where.Col - start.Col
+			tok.Where           = start
+			tok.Where.EndRow    = l.last.Row
+			tok.Where.EndCol    = l.last.Col + 1
+			tok.Where.EndOffset = l.pos
+
+			// Only the first line of tokens spanning multiple lines is underlined
+			if tok.Where.EndRow != start.Row {
+				tok.Where.Len = len(start.Line) - start.Col + 1
+			} else {
+				tok.Where.Len = tok.Where.EndCol - start.Col
+			}
+		}
+
+		tok.Where.IncludedFrom = l.IncludedFrom
+
+		// Strings with errors are lexed to their closing quote, and follow their error as if they
+		// were valid, so the parser does not report errors about a missing value after them
+		if l.invalid != nil {
+			err, str := *l.invalid, tok
+			l.invalid = nil
+			l.pending = &str
+
+			if err.Where.Len > 0 {
+				err.Where.EndRow       = err.Where.Row
+				err.Where.EndCol       = err.Where.Col    + err.Where.Len
+				err.Where.EndOffset    = err.Where.Offset + err.Where.Len
+				err.Where.IncludedFrom = l.IncludedFrom
+			} else {
+				err.Where = tok.Where
+			}
+
+			return err
 		}
 
 		break
@@ -190,41 +356,82 @@ func escapedCharToByte(ch byte) (byte, bool) {
 	return 0, false
 }
 
+// Strings span multiple lines when every line but the last ends with a '\\', the new line is not a
+// part of the string. Errors about a missing closing quote point at the opening one.
 func (l *Lexer) lexString() token.Token {
-	str    := ""
+	var str strings.Builder
 	escape := false
+	from   := l.pos
+	row    := l.where.Row
+
+	var err *token.Token // Reported after the closing quote, so lexing continues after it
 
 	for l.next(); !(l.ch == '"' && !escape); l.next() {
 		switch l.ch {
 		case '\\':
 			if escape {
 				escape = false
-				str   += "\\"
+				str.WriteByte('\\')
 			} else {
 				escape = true
 			}
 
-		case '\n': return token.NewError(l.where, "Expected '\"', got 'new line'")
-		case EOF:  return token.NewError(l.where, "Expected '\"', got 'end of file'")
+		case '\n', EOF:
+			if l.ch == '\n' && escape {
+				escape = false
+				continue
+			}
+
+			got := "new line"
+			if l.ch == EOF {
+				got = "end of file"
+			}
+
+			if l.last.Row != row {
+				return token.NewError(l.where, "Expected '\"', got '%v' on line %v of the string",
+				                      got, l.last.Row - row + 1)
+			}
+
+			return token.NewError(l.where, "Expected '\"', got '%v'", got)
 
 		default:
+			// CRLF line endings, the new line comes next
+			if escape && l.ch == '\r' && l.peek() == '\n' {
+				continue
+			}
+
 			if escape {
 				ret, ok := escapedCharToByte(l.ch)
-				if !ok {
-					return token.NewError(l.where, "Unknown escape sequence '\\%v'", string(l.ch))
+				if !ok && err == nil {
+					tok := token.NewError(l.escape(), "Unknown escape sequence '\\%v'",
+					                      string(l.ch))
+					err  = &tok
 				}
 				escape = false
 
-				str += string(ret)
+				str.WriteByte(ret)
 			} else {
-				str += string(l.ch)
+				str.WriteByte(l.ch)
 			}
 		}
 	}
 
 	l.next()
 
-	return token.Token{Type: token.String, Data: str}
+	if err == nil && !utf8.ValidString(l.input[from:l.pos]) {
+		tok := token.NewError(token.Where{}, "Invalid UTF-8 encoding in string")
+		err  = &tok
+	}
+
+	l.invalid = err
+	return token.Token{Type: token.String, Data: str.String()}
+}
+
+// Position of the escape sequence ending at the current character
+func (l *Lexer) escape() token.Where {
+	where    := l.last
+	where.Len = 2
+	return where
 }
 
 func (l *Lexer) lexChar() token.Token {
@@ -234,10 +441,16 @@ func (l *Lexer) lexChar() token.Token {
 		l.next()
 		ret, ok := escapedCharToByte(l.ch)
 		if !ok {
-			return token.NewError(l.where, "Unknown escape sequence '\\%v'", string(l.ch))
+			return token.NewError(l.escape(), "Unknown escape sequence '\\%v'", string(l.ch))
 		}
 
 		str += string(ret)
+	} else if l.ch >= utf8.RuneSelf {
+		if r, _ := utf8.DecodeRuneInString(l.input[l.pos:]); r == utf8.RuneError {
+			return token.NewError(l.where, "Invalid UTF-8 encoding in character")
+		}
+
+		return token.NewError(l.where, "Character literal expected to be exactly 1 byte long")
 	} else {
 		str += string(l.ch)
 	}
@@ -273,19 +486,17 @@ func (l *Lexer) lexNum() token.Token {
 }
 
 func (l *Lexer) lexHex() token.Token {
-	str := ""
+	start := l.pos
 
 	for isHexDigit(l.ch) {
-		str += string(l.ch)
-
 		l.next()
 	}
 
-	return token.Token{Type: token.Hex, Data: str}
+	return token.Token{Type: token.Hex, Data: l.slice(start)}
 }
 
 func (l *Lexer) lexOct() token.Token {
-	str := ""
+	start := l.pos
 
 	for {
 		if !isOctDigit(l.ch) {
@@ -297,16 +508,14 @@ func (l *Lexer) lexOct() token.Token {
 			break
 		}
 
-		str += string(l.ch)
-
 		l.next()
 	}
 
-	return token.Token{Type: token.Oct, Data: str}
+	return token.Token{Type: token.Oct, Data: l.slice(start)}
 }
 
 func (l *Lexer) lexBin() token.Token {
-	str := ""
+	start := l.pos
 
 	for {
 		if !isBinDigit(l.ch) {
@@ -318,53 +527,71 @@ func (l *Lexer) lexBin() token.Token {
 			break
 		}
 
-		str += string(l.ch)
-
 		l.next()
 	}
 
-	return token.Token{Type: token.Bin, Data: str}
+	return token.Token{Type: token.Bin, Data: l.slice(start)}
 }
 
+// Floats have a '.', an exponent or both. Either side of the '.' can be left out (.5 and 2.), a
+// second '.' right after it is a fill (0..4) instead.
 func (l *Lexer) lexDec() token.Token {
-	str     := ""
-	float   := false
-	atStart := true
+	start  := l.pos
+	float  := false
+	digits := false
+
+	if l.ch == '-' {
+		l.next()
+	}
 
 	for !isWhitespace(l.ch) && l.ch != ',' && l.ch != ':' {
-		if l.ch == '.' {
+		if l.ch == '.' && l.peek() != '.' {
 			if float {
 				return token.NewError(l.where, "Unexpected '.' in float number")
 			}
 
 			float = true
-		} else if !isDecDigit(l.ch) && !(atStart && l.ch == '-') {
-			if isHexDigit(l.ch) {
-				return token.NewError(l.where, "Unexpected character '%v' in decimal number",
-				                      string(l.ch))
+		} else if (l.ch == 'e' || l.ch == 'E') && digits {
+			exponent := l.where
+
+			l.next()
+			if l.ch == '+' || l.ch == '-' {
+				l.next()
 			}
 
-			break
-		}
+			if !isDecDigit(l.ch) {
+				return token.NewError(exponent, "Expected digits in the exponent of float number")
+			}
 
-		str += string(l.ch)
+			for isDecDigit(l.ch) {
+				l.next()
+			}
 
-		if atStart {
-			atStart = false
+			float = true
+			break
+		} else if !isDecDigit(l.ch) {
+			break
 		}
 
+		digits = digits || isDecDigit(l.ch)
 		l.next()
 	}
 
-	if float {
-		return token.Token{Type: token.Float, Data: str}
+	if isHexDigit(l.ch) || l.ch == '.' && l.peek() != '.' {
+		return token.NewError(l.where, "Unexpected character '%v' in decimal number",
+		                      string(l.ch))
+	} else if float {
+		return token.Token{Type: token.Float, Data: l.slice(start)}
 	} else {
-		return token.Token{Type: token.Dec, Data: str}
+		return token.Token{Type: token.Dec, Data: l.slice(start)}
 	}
 }
 
 func (l *Lexer) lexLabel() token.Token {
-	if l.next(); !isIdCh(l.ch) {
+	dot := l.where
+	if l.next(); isWhitespace(l.ch) || l.ch == EOF {
+		return token.NewError(dot, "Expected a label name or a float after '.'")
+	} else if !l.isIdCh(l.ch) {
 		return token.NewError(l.where, "Unexpected character '%v' in label name",
 		                      string(l.ch))
 	}
@@ -374,6 +601,13 @@ func (l *Lexer) lexLabel() token.Token {
 
 func (l *Lexer) lexId() token.Token {
 	str := l.readId()
+	// '=' is not a part of names, but of these comparisons
+	if (str == "<" || str == ">") && l.ch == '=' {
+		l.next()
+
+		str += "="
+	}
+
 	type_, ok := Keywords[str]
 	if ok {
 		return token.Token{Type: type_, Data: str}
@@ -382,24 +616,146 @@ func (l *Lexer) lexId() token.Token {
 	return token.Token{Type: token.Id, Data: str}
 }
 
-func (l *Lexer) readId() (str string) {
-	for isIdCh(l.ch) {
-		str += string(l.ch)
+// Dots separate the parts of names qualified with a namespace, two dots are a fill
+func (l *Lexer) readId() string {
+	start := l.pos
 
+	for l.isIdCh(l.ch) || (l.ch == '.' && l.isIdCh(l.peek())) {
 		l.next()
 	}
 
-	return str
+	return l.slice(start)
+}
+
+// Returns the input from start up to the current character, without copying it
+func (l *Lexer) slice(start int) string {
+	if l.pos > len(l.input) {
+		return l.input[start:]
+	}
+
+	return l.input[start:l.pos]
+}
+
+func (l *Lexer) canStartToken() bool {
+	switch l.ch {
+	case EOF, '"', '\'', '.', '(', ')', ',', '=', '!', '\\': return true
+
+	default: return l.isIdCh(l.ch) || isWhitespace(l.ch) || l.atComment()
+	}
+}
+
+// A '\' followed only by whitespace and a comment continues the line on the next one
+func (l *Lexer) lexContinuation() bool {
+	row := l.where.Row
+	l.next()
+	for l.ch != '\n' && isWhitespace(l.ch) {
+		l.next()
+	}
+
+	if l.atComment() {
+		l.skipComment()
+	}
+
+	if l.ch != '\n' && l.ch != EOF {
+		return false
+	}
+
+	if l.continued == nil {
+		l.continued = make(map[int]bool)
+	}
+
+	l.continued[row] = true
+	return true
+}
+
+// If the row ends with a '\', so the tokens on the next row belong to the same line
+func (l *Lexer) Continued(row int) bool {
+	return l.continued[row]
+}
+
+// Name that ends right before the offset, empty if there is none
+func (l *Lexer) nameBefore(offset int) string {
+	start := offset
+	for start > 0 && l.isIdCh(l.input[start - 1]) {
+		start --
+	}
+
+	return l.input[start:offset]
+}
+
+func (l *Lexer) skipWord() {
+	for l.ch != EOF && !isWhitespace(l.ch) && l.ch != ',' && l.ch != '(' && l.ch != ')' {
+		l.next()
+	}
+}
+
+func (l *Lexer) atComment() bool {
+	if l.ch == EOF {
+		return false
+	}
+
+	for _, comment := range l.Comments {
+		if strings.HasPrefix(l.input[l.pos:], comment) {
+			return true
+		}
+	}
+
+	return false
 }
 
 func (l *Lexer) skipComment() {
+	row, start := l.where.Row, l.pos
+	where      := l.where
 	for l.ch != EOF && l.ch != '\n' {
 		l.next()
 	}
+
+	text := l.input[start:l.pos]
+	for _, comment := range l.Comments {
+		if strings.HasPrefix(text, comment) {
+			text = text[len(comment):]
+			break
+		}
+	}
+
+	if len(text) == l.pos - start { // Shebang line
+		text = strings.TrimPrefix(text, "#")
+	}
+
+	if l.comments == nil {
+		l.comments = make(map[int]string)
+	}
+
+	l.comments[row] = strings.TrimSpace(text)
+
+	where.Len, where.EndRow, where.EndCol = l.pos - start, row, where.Col + l.pos - start
+	where.EndOffset    = l.pos
+	where.IncludedFrom = l.IncludedFrom
+	l.ordered          = append(l.ordered, LineComment{Text: l.comments[row], Where: where})
+}
+
+type LineComment struct {
+	Text  string // Like Comment returns it
+	Where token.Where
+}
+
+// Comments lexed so far, in the order they are in the input
+func (l *Lexer) LineComments() []LineComment {
+	return l.ordered
+}
+
+// Text of the comment on the row without the introducer and surrounding whitespace, empty if
+// there is none. Comments that the tools read, like 'fallthrough', are looked up with it.
+func (l *Lexer) Comment(row int) string {
+	return l.comments[row]
 }
 
 func (l *Lexer) next() {
+	l.last = l.where
+
 	l.pos ++
+	l.where.Offset = l.pos
+
 	if l.pos >= len(l.input) {
 		l.ch = EOF
 	} else {
@@ -416,10 +772,15 @@ func (l *Lexer) next() {
 }
 
 func (l *Lexer) peek() byte {
-	if l.pos + 1 >= len(l.input) {
+	return l.peekAt(1)
+}
+
+// Character offset characters after the current one
+func (l *Lexer) peekAt(offset int) byte {
+	if l.pos + offset >= len(l.input) {
 		return EOF
 	} else {
-		return l.input[l.pos + 1]
+		return l.input[l.pos + offset]
 	}
 }
 
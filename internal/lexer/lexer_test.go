@@ -0,0 +1,226 @@
+package lexer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/avm-collection/anasm/internal/token"
+)
+
+type position struct {
+	type_ token.Type
+	data  string
+
+	row, col, endRow, endCol int
+	offset, endOffset, len   int
+}
+
+// Lexes the input, checking the data and position of every token
+func checkPositions(t *testing.T, src string, want []position) {
+	t.Helper()
+
+	l := New(src, "<test>")
+	for i := 0; ; i ++ {
+		tok := l.NextToken()
+		if tok.Type == token.EOF {
+			if i != len(want) {
+				t.Errorf("%q: got %v tokens, expected %v", src, i, len(want))
+			}
+
+			return
+		} else if i >= len(want) {
+			t.Errorf("%q: unexpected token %v", src, tok)
+			continue
+		}
+
+		w   := tok.Where
+		got := position{tok.Type, tok.Data, w.Row, w.Col, w.EndRow, w.EndCol, w.Offset,
+		                w.EndOffset, w.Len}
+		if got != want[i] {
+			t.Errorf("%q: token %v is\n%+v, expected\n%+v", src, i, got, want[i])
+		}
+	}
+}
+
+// Every token has its start, end and byte offsets. A token spanning lines is as long as its part
+// on the first line.
+func TestPositions(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []position
+	}{
+		{".entry\n\tpsh 5\n\thlt # Done\n", []position{
+			{token.Label, "entry", 1, 1, 1, 7,  0,  6,  6},
+			{token.Id,    "psh",   2, 2, 2, 5,  8,  11, 3},
+			{token.Dec,   "5",     2, 6, 2, 7,  12, 13, 1},
+			{token.Id,    "hlt",   3, 2, 3, 5,  15, 18, 3},
+		}},
+		{"let x i64 = (+ 0x1F 0b10), 'a'", []position{
+			{token.Let,       "let", 1, 1,  1, 4,  0,  3,  3},
+			{token.Id,        "x",   1, 5,  1, 6,  4,  5,  1},
+			{token.TypeInt64, "i64", 1, 7,  1, 10, 6,  9,  3},
+			{token.Equals,    "=",   1, 11, 1, 12, 10, 11, 1},
+			{token.LParen,    "(",   1, 13, 1, 14, 12, 13, 1},
+			{token.Add,       "+",   1, 14, 1, 15, 13, 14, 1},
+			{token.Hex,       "1F",  1, 16, 1, 20, 15, 19, 4},
+			{token.Bin,       "10",  1, 21, 1, 25, 20, 24, 4},
+			{token.RParen,    ")",   1, 25, 1, 26, 24, 25, 1},
+			{token.Comma,     ",",   1, 26, 1, 27, 25, 26, 1},
+			{token.Char,      "a",   1, 28, 1, 31, 27, 30, 3},
+		}},
+		// The end of an escaped string is where it ends in the source, not in its data
+		{"let s = \"a\\nb\", x", []position{
+			{token.Let,    "let",  1, 1,  1, 4,  0,  3,  3},
+			{token.Id,     "s",    1, 5,  1, 6,  4,  5,  1},
+			{token.Equals, "=",    1, 7,  1, 8,  6,  7,  1},
+			{token.String, "a\nb", 1, 9,  1, 15, 8,  14, 6},
+			{token.Comma,  ",",    1, 15, 1, 16, 14, 15, 1},
+			{token.Id,     "x",    1, 17, 1, 18, 16, 17, 1},
+		}},
+		{"s \"a\\\nbc\\n\\\nd\" z", []position{
+			{token.Id,     "s",      1, 1, 1, 2, 0,  1,  1},
+			{token.String, "abc\nd", 1, 3, 3, 3, 2,  14, 3},
+			{token.Id,     "z",      3, 4, 3, 5, 15, 16, 1},
+		}},
+	}
+
+	for _, tt := range tests {
+		checkPositions(t, tt.src, tt.want)
+	}
+}
+
+// A string with an error is still a string after the error, so the parser sees its value
+func TestStringErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []position
+	}{
+		{"\"Caf\\q\" 1", []position{
+			{token.Error,  "Unknown escape sequence '\\q'", 1, 5, 1, 7,  4, 6, 2},
+			{token.String, "Caf\x00",                      1, 1, 1, 8,  0, 7, 7},
+			{token.Dec,    "1",                            1, 9, 1, 10, 8, 9, 1},
+		}},
+		{"\"Caf\xe9\" 1", []position{
+			{token.Error,  "Invalid UTF-8 encoding in string", 1, 1, 1, 7, 0, 6, 6},
+			{token.String, "Caf\xe9",                          1, 1, 1, 7, 0, 6, 6},
+			{token.Dec,    "1",                                1, 8, 1, 9, 7, 8, 1},
+		}},
+	}
+
+	for _, tt := range tests {
+		checkPositions(t, tt.src, tt.want)
+	}
+}
+
+// Columns count bytes: a tab is one column, a multi-byte character as many as it has bytes, and
+// escape sequences as long as they are in the source
+func TestColumns(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []position
+	}{
+		{"\tpsh\t'\\t'\t1", []position{
+			{token.Id,   "psh", 1, 2,  1, 5,  1,  4,  3},
+			{token.Char, "\t",  1, 6,  1, 10, 5,  9,  4},
+			{token.Dec,  "1",   1, 11, 1, 12, 10, 11, 1},
+		}},
+		// The third element after a string with escapes
+		{"let s char = \"\\n\\n\\t\", 1, x", []position{
+			{token.Let,      "let",      1, 1,  1, 4,  0,  3,  3},
+			{token.Id,       "s",        1, 5,  1, 6,  4,  5,  1},
+			{token.TypeChar, "char",     1, 7,  1, 11, 6,  10, 4},
+			{token.Equals,   "=",        1, 12, 1, 13, 11, 12, 1},
+			{token.String,   "\n\n\t", 1, 14, 1, 22, 13, 21, 8},
+			{token.Comma,    ",",        1, 22, 1, 23, 21, 22, 1},
+			{token.Dec,      "1",        1, 24, 1, 25, 23, 24, 1},
+			{token.Comma,    ",",        1, 25, 1, 26, 24, 25, 1},
+			{token.Id,       "x",        1, 27, 1, 28, 26, 27, 1},
+		}},
+		// Characters of 3 and 4 bytes
+		{"s \"€😀\", y", []position{
+			{token.Id,     "s",  1, 1,  1, 2,  0,  1,  1},
+			{token.String, "€😀", 1, 3,  1, 12, 2,  11, 9},
+			{token.Comma,  ",",  1, 12, 1, 13, 11, 12, 1},
+			{token.Id,     "y",  1, 14, 1, 15, 13, 14, 1},
+		}},
+		{"x # é\n\ty", []position{
+			{token.Id, "x", 1, 1, 1, 2, 0, 1, 1},
+			{token.Id, "y", 2, 2, 2, 3, 8, 9, 1},
+		}},
+		// The carriage return ends the line with the new line
+		{"psh 1\r\npsh 2\r\n", []position{
+			{token.Id,  "psh", 1, 1, 1, 4, 0,  3,  3},
+			{token.Dec, "1",   1, 5, 1, 6, 4,  5,  1},
+			{token.Id,  "psh", 2, 1, 2, 4, 7,  10, 3},
+			{token.Dec, "2",   2, 5, 2, 6, 11, 12, 1},
+		}},
+	}
+
+	for _, tt := range tests {
+		checkPositions(t, tt.src, tt.want)
+	}
+}
+
+// Floats may leave out the digits on one side of the dot, but a dot alone is not a float and a
+// dot before a name is a label
+func TestFloats(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []position
+	}{
+		{"psh .5 2. .5e3 2.e-3", []position{
+			{token.Id,    "psh",   1, 1,  1, 4,  0,  3,  3},
+			{token.Float, ".5",    1, 5,  1, 7,  4,  6,  2},
+			{token.Float, "2.",    1, 8,  1, 10, 7,  9,  2},
+			{token.Float, ".5e3",  1, 11, 1, 15, 10, 14, 4},
+			{token.Float, "2.e-3", 1, 16, 1, 21, 15, 20, 5},
+		}},
+		{"psh . 5", []position{
+			{token.Id,    "psh", 1, 1, 1, 4, 0, 3, 3},
+			{token.Error, "Expected a label name or a float after '.'", 1, 5, 1, 6, 4, 5, 1},
+			{token.Dec,   "5",   1, 7, 1, 8, 6, 7, 1},
+		}},
+		{".loop\n\tjmp loop", []position{
+			{token.Label, "loop", 1, 1, 1, 6,  0,  5,  5},
+			{token.Id,    "jmp",  2, 2, 2, 5,  7,  10, 3},
+			{token.Id,    "loop", 2, 6, 2, 10, 11, 15, 4},
+		}},
+	}
+
+	for _, tt := range tests {
+		checkPositions(t, tt.src, tt.want)
+	}
+}
+
+// Synthetic program of the given number of blocks, using every kind of token
+func program(blocks int) string {
+	var b strings.Builder
+	for i := 0; i < blocks; i ++ {
+		fmt.Fprintf(&b, "let msg_%v char = \"Hello, world %v!\\n\", 0 # Message\n", i, i)
+		fmt.Fprintf(&b, "let table_%v i64 = 0x%X, 0b1011, 0o17, 3.25, 'a', 0 .. 4\n", i, i)
+		fmt.Fprintf(&b, ".loop_%v\n\tpsh msg_%v\n\tpsh (+ %v (* 2 sizeof_i64))\n", i, i, i)
+		fmt.Fprintf(&b, "\tjnz loop_%v\n\thlt\n\n", i)
+	}
+
+	return b.String()
+}
+
+// Tokens are sliced out of the input, so lexing allocates little besides the strings with escape
+// sequences. Run with -benchmem to see the allocations per token.
+func BenchmarkLex(b *testing.B) {
+	src := program(20000)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(src)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i ++ {
+		l := New(src, "<bench>")
+		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+			if tok.Type == token.Error {
+				b.Fatalf("%v: %v", tok.Where, tok.Data)
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package lexer
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Names of labels, variables, macros and namespaces are parts separated by dots, for names
+// qualified with a namespace. A part starts with a letter, '_' or '$' and goes on with letters,
+// digits, '_' and '$'. Other identifier characters lex too, since the operators are identifiers,
+// but names can not be defined with them.
+//
+// With unicode names, letters and digits can be any Unicode ones, for generated code that mangles
+// names. Identifiers then lex with every non-ASCII character, and definitions are checked here.
+
+// Regular expression of the ASCII names, for tooling
+const NamePattern = `[A-Za-z_$][A-Za-z0-9_$]*(\.[A-Za-z_$][A-Za-z0-9_$]*)*`
+
+func isNameStart(r rune, unicodeNames bool) bool {
+	if r >= utf8.RuneSelf {
+		return unicodeNames && unicode.IsLetter(r)
+	}
+
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || r == '_' || r == '$'
+}
+
+func isNamePart(r rune, unicodeNames bool) bool {
+	if r >= utf8.RuneSelf {
+		return unicodeNames && (unicode.IsLetter(r) || unicode.IsDigit(r))
+	}
+
+	return isNameStart(r, false) || isDecDigit(byte(r))
+}
+
+// Checks if a name can be defined
+func CheckName(name string, unicodeNames bool) error {
+	start := true
+	for _, r := range name {
+		switch {
+		case r == utf8.RuneError:
+			return fmt.Errorf("Name '%v' is not valid UTF-8", name)
+
+		case r == '.':
+			if start {
+				return fmt.Errorf("Name '%v' has an empty part", name)
+			}
+
+			start = true
+			continue
+
+		case start && r < utf8.RuneSelf && isDecDigit(byte(r)):
+			return fmt.Errorf("Name '%v' can not start with a digit", name)
+
+		case start && !isNameStart(r, unicodeNames):
+			return fmt.Errorf("Name '%v' can not start with '%v'", name, string(r))
+
+		case !isNamePart(r, unicodeNames):
+			return fmt.Errorf("Name '%v' can not contain '%v'", name, string(r))
+		}
+
+		start = false
+	}
+
+	if start {
+		return fmt.Errorf("Name '%v' has an empty part", name)
+	}
+
+	return nil
+}
+
+// Names given outside of the source, like in options and symbol files, are normalized here and
+// nowhere else, so they match the names in the source or fail. The whitespace around the name and
+// the '.' of a label definition are removed, anything else has to be a valid name.
+func NormalizeName(name string, unicodeNames bool) (string, error) {
+	normalized := strings.TrimPrefix(strings.TrimSpace(name), ".")
+	if len(normalized) == 0 {
+		return "", fmt.Errorf("Name '%v' is empty", name)
+	} else if err := CheckName(normalized, unicodeNames); err != nil {
+		return "", err
+	}
+
+	return normalized, nil
+}
@@ -0,0 +1,472 @@
+package lsp
+
+import (
+	"io"
+	"fmt"
+	"bufio"
+	"strings"
+	"strconv"
+	"net/url"
+	"path/filepath"
+	"encoding/json"
+
+	"github.com/avm-collection/anasm/internal/compiler"
+	"github.com/avm-collection/anasm/internal/config"
+	"github.com/avm-collection/anasm/internal/diag"
+	"github.com/avm-collection/anasm/internal/index"
+	"github.com/avm-collection/anasm/internal/lexer"
+	"github.com/avm-collection/anasm/internal/token"
+)
+
+// Language server speaking the language server protocol (JSON-RPC) over a reader and writer.
+// Documents are fully reindexed on every change.
+
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	Id      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *responseError   `json:"error,omitempty"`
+}
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type rangeLSP struct {
+	Start position `json:"start"`
+	End   position `json:"end"`
+}
+
+type location struct {
+	URI   string   `json:"uri"`
+	Range rangeLSP `json:"range"`
+}
+
+type textDocumentPosition struct {
+	TextDocument struct {
+		URI string `json:"uri"`
+	} `json:"textDocument"`
+	Position position `json:"position"`
+}
+
+type document struct {
+	uri, path string
+	idx       *index.Index
+	lines     []string
+}
+
+type Server struct {
+	r *bufio.Reader
+	w io.Writer
+
+	docs     map[string]*document
+	shutdown bool
+}
+
+func New(r io.Reader, w io.Writer) *Server {
+	return &Server{r: bufio.NewReader(r), w: w, docs: make(map[string]*document)}
+}
+
+// Serves requests until the client exits, returns the exit code
+func (s *Server) Serve() int {
+	for {
+		msg, err := s.read()
+		if err != nil {
+			return 1
+		}
+
+		if msg.Method == "exit" {
+			if s.shutdown {
+				return 0
+			}
+
+			return 1
+		}
+
+		result, err := s.handle(msg)
+		if msg.Id == nil {
+			continue // Notification
+		}
+
+		response := message{JSONRPC: "2.0", Id: msg.Id, Result: result}
+		if err != nil {
+			response.Error = &responseError{Code: -32603, Message: err.Error()}
+		} else if result == nil {
+			response.Result = json.RawMessage("null")
+		}
+
+		s.write(response)
+	}
+}
+
+func (s *Server) read() (*message, error) {
+	length := -1
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			break
+		}
+
+		if strings.HasPrefix(line, "Content-Length:") {
+			length, err = strconv.Atoi(strings.TrimSpace(line[len("Content-Length:"):]))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if length < 0 {
+		return nil, fmt.Errorf("Missing content length")
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(s.r, data); err != nil {
+		return nil, err
+	}
+
+	msg := &message{}
+	return msg, json.Unmarshal(data, msg)
+}
+
+func (s *Server) write(msg message) {
+	data, _ := json.Marshal(msg)
+	fmt.Fprintf(s.w, "Content-Length: %v\r\n\r\n%s", len(data), data)
+}
+
+func (s *Server) notify(method string, params interface{}) {
+	data, _ := json.Marshal(params)
+	s.write(message{JSONRPC: "2.0", Method: method, Params: data})
+}
+
+func (s *Server) handle(msg *message) (interface{}, error) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":   1, // Full documents
+				"definitionProvider": true,
+				"hoverProvider":      true,
+				"completionProvider": map[string]interface{}{},
+			},
+			"serverInfo": map[string]string{"name": "anasm"},
+		}, nil
+
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var params struct {
+			TextDocument struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			} `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+
+		s.update(params.TextDocument.URI, params.TextDocument.Text)
+
+	case "textDocument/didChange":
+		var params struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+
+		if len(params.ContentChanges) > 0 {
+			changes := params.ContentChanges
+			s.update(params.TextDocument.URI, changes[len(changes) - 1].Text)
+		}
+
+	case "textDocument/didClose":
+		var params struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+
+		delete(s.docs, params.TextDocument.URI)
+
+	case "textDocument/definition": return s.definition(msg.Params)
+	case "textDocument/hover":      return s.hover(msg.Params)
+	case "textDocument/completion": return s.completion(msg.Params)
+
+	default:
+		if msg.Id != nil {
+			return nil, fmt.Errorf("Unsupported method '%v'", msg.Method)
+		}
+	}
+
+	return nil, nil
+}
+
+func pathFromURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+
+	return u.Path
+}
+
+func uriFromPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
+
+func (doc *document) line(row int) string {
+	if row < 1 || row > len(doc.lines) {
+		return ""
+	}
+
+	return doc.lines[row - 1]
+}
+
+// Positions count UTF-16 code units of the line, columns count bytes
+func (doc *document) position(row, col int) position {
+	line := doc.line(row)
+	if col - 1 > len(line) {
+		col = len(line) + 1
+	}
+
+	units := 0
+	for _, r := range line[:col - 1] {
+		if r >= 0x10000 {
+			units += 2 // Surrogate pair
+		} else {
+			units ++
+		}
+	}
+
+	return position{Line: row - 1, Character: units}
+}
+
+// Column of the position, from 1
+func (doc *document) column(pos position) int {
+	line  := doc.line(pos.Line + 1)
+	units := 0
+	for i, r := range line {
+		if units >= pos.Character {
+			return i + 1
+		}
+
+		if r >= 0x10000 {
+			units += 2
+		} else {
+			units ++
+		}
+	}
+
+	return len(line) + 1
+}
+
+func (doc *document) toRange(where token.Where) rangeLSP {
+	if where.EndRow == 0 { // Not from the lexer
+		where.EndRow = where.Row
+		where.EndCol = where.Col + where.Len
+	}
+
+	return rangeLSP{Start: doc.position(where.Row, where.Col),
+	                End:   doc.position(where.EndRow, where.EndCol)}
+}
+
+// LSP diagnostic severities
+const (
+	severityError   = 1
+	severityWarning = 2
+)
+
+func (s *Server) update(uri, text string) {
+	doc := &document{uri: uri, path: pathFromURI(uri), lines: strings.Split(text, "\n")}
+	doc.idx = index.Build(text, doc.path, config.LibDirs())
+	s.docs[uri] = doc
+
+	// Checked with the defaults of the command line, lexer errors are reported by the parser
+	c := compiler.New(text, doc.path, compiler.Options{IncludeDirs: config.LibDirs()})
+
+	diagnostics := []interface{}{}
+	for _, d := range c.Check() {
+		diagnostics = append(diagnostics, doc.toDiagnostic(d))
+	}
+
+	s.notify("textDocument/publishDiagnostics", map[string]interface{}{
+		"uri": uri, "diagnostics": diagnostics,
+	})
+}
+
+func (doc *document) toDiagnostic(d diag.Diagnostic) map[string]interface{} {
+	severity := severityError
+	if d.Warning {
+		severity = severityWarning
+	}
+
+	// Diagnostics in included files are shown at the include directive in the document
+	where, msg := d.Where, d.Msg
+	for where.IncludedFrom != nil {
+		where = *where.IncludedFrom
+	}
+
+	if d.Where.Path != doc.path && !d.Simple {
+		msg = fmt.Sprintf("%v: %v", d.Where, msg)
+	}
+
+	rng := rangeLSP{} // Start of the document for the others
+	if !d.Simple && where.Path == doc.path {
+		rng = doc.toRange(where)
+	}
+
+	related := []interface{}{}
+	for _, n := range d.Notes {
+		loc := location{URI: uriFromPath(n.Where.Path)}
+		if n.Where.Path == doc.path {
+			loc = location{URI: doc.uri, Range: doc.toRange(n.Where)}
+		}
+
+		related = append(related, map[string]interface{}{"location": loc, "message": n.Msg})
+	}
+
+	return map[string]interface{}{
+		"range":              rng,
+		"severity":           severity,
+		"source":             "anasm",
+		"message":            msg,
+		"relatedInformation": related,
+	}
+}
+
+func (s *Server) tokenAt(params json.RawMessage) (*document, token.Token, bool, error) {
+	var pos textDocumentPosition
+	if err := json.Unmarshal(params, &pos); err != nil {
+		return nil, token.Token{}, false, err
+	}
+
+	doc, ok := s.docs[pos.TextDocument.URI]
+	if !ok {
+		return nil, token.Token{}, false, nil
+	}
+
+	tok, ok := doc.idx.At(pos.Position.Line + 1, doc.column(pos.Position))
+	return doc, tok, ok, nil
+}
+
+func (s *Server) definition(params json.RawMessage) (interface{}, error) {
+	doc, tok, ok, err := s.tokenAt(params)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	sym, ok := doc.idx.Defs[doc.idx.Name(tok)]
+	if !ok {
+		return nil, nil
+	} else if sym.Where.IncludedFrom != nil {
+		// The text of included files is not known to convert the column
+		line := position{Line: sym.Where.Row - 1}
+		return location{URI: uriFromPath(sym.File), Range: rangeLSP{Start: line, End: line}}, nil
+	}
+
+	return location{URI: doc.uri, Range: doc.toRange(sym.Where)}, nil
+}
+
+func (s *Server) hover(params json.RawMessage) (interface{}, error) {
+	doc, tok, ok, err := s.tokenAt(params)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	text := ""
+	if inst, ok := compiler.Insts[tok.Data]; ok && tok.Type == token.Id {
+		text = fmt.Sprintf("instruction `%v` (opcode 0x%02x)", tok.Data, inst.Op)
+		if inst.HasArg {
+			text += fmt.Sprintf(", takes an argument (%v)", inst.Arg)
+		} else {
+			text += ", takes no argument"
+		}
+
+		text += "\n\n" + inst.Desc
+	} else if sym, ok := doc.idx.Defs[doc.idx.Name(tok)]; ok && sym.Where.IncludedFrom != nil {
+		text = fmt.Sprintf("%v `%v`, defined in %v at line %v", sym.Kind, sym.Name, sym.File,
+		                   sym.Where.Row)
+	} else if ok {
+		text = fmt.Sprintf("%v `%v`, defined at line %v", sym.Kind, sym.Name, sym.Where.Row)
+	} else {
+		return nil, nil
+	}
+
+	return map[string]interface{}{
+		"contents": map[string]string{"kind": "markdown", "value": text},
+		"range":    doc.toRange(tok.Where),
+	}, nil
+}
+
+// LSP completion item kinds
+const (
+	completionKeyword  = 14
+	completionVariable = 6
+	completionConstant = 21
+	completionFunction = 3
+)
+
+func (s *Server) completion(params json.RawMessage) (interface{}, error) {
+	var pos textDocumentPosition
+	if err := json.Unmarshal(params, &pos); err != nil {
+		return nil, err
+	}
+
+	items := []interface{}{}
+	for name, inst := range compiler.Insts {
+		detail := fmt.Sprintf("opcode 0x%02x", inst.Op)
+		items = append(items, map[string]interface{}{
+			"label": name, "kind": completionFunction, "detail": detail,
+		})
+	}
+
+	for keyword := range lexer.Keywords {
+		if len(keyword) > 0 && (keyword[0] < 'a' || keyword[0] > 'z') {
+			continue // Operators
+		}
+
+		items = append(items, map[string]interface{}{"label": keyword, "kind": completionKeyword})
+	}
+
+	if doc, ok := s.docs[pos.TextDocument.URI]; ok {
+		for name, sym := range doc.idx.Defs {
+			kind := completionVariable
+			if sym.Kind == index.Macro {
+				kind = completionConstant
+			}
+
+			items = append(items, map[string]interface{}{
+				"label": name, "kind": kind, "detail": sym.Kind.String(),
+			})
+		}
+	}
+
+	return items, nil
+}
@@ -0,0 +1,119 @@
+package lsp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Runs the server on the requests and returns the messages it wrote
+func serve(t *testing.T, requests ...string) []map[string]interface{} {
+	t.Helper()
+
+	in := &bytes.Buffer{}
+	for _, req := range requests {
+		fmt.Fprintf(in, "Content-Length: %v\r\n\r\n%v", len(req), req)
+	}
+
+	out := &bytes.Buffer{}
+	New(in, out).Serve()
+
+	msgs := []map[string]interface{}{}
+	for _, part := range strings.Split(out.String(), "Content-Length: ")[1:] {
+		msg := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(part[strings.Index(part, "{"):]), &msg); err != nil {
+			t.Fatal(err)
+		}
+
+		msgs = append(msgs, msg)
+	}
+
+	return msgs
+}
+
+func open(text string) string {
+	data, _ := json.Marshal(text)
+	return fmt.Sprintf(`{"jsonrpc": "2.0", "method": "textDocument/didOpen", "params": ` +
+	                   `{"textDocument": {"uri": "file:///test.anasm", "text": %s}}}`, data)
+}
+
+func at(method string, line, char int) string {
+	return fmt.Sprintf(`{"jsonrpc": "2.0", "id": 1, "method": "textDocument/%v", "params": ` +
+	                   `{"textDocument": {"uri": "file:///test.anasm"}, ` +
+	                   `"position": {"line": %v, "character": %v}}}`, method, line, char)
+}
+
+func TestDiagnostics(t *testing.T) {
+	// The undefined name comes after a character of 3 bytes and 1 UTF-16 code unit, and one of
+	// 4 bytes and 2 code units
+	msgs := serve(t, open("let s char = \"€😀\", undefined\n.entry\n\thlt\n"))
+
+	params := msgs[0]["params"].(map[string]interface{})
+	diags  := params["diagnostics"].([]interface{})
+	if len(diags) != 1 {
+		t.Fatalf("Got %v diagnostics, expected 1: %v", len(diags), diags)
+	}
+
+	d     := diags[0].(map[string]interface{})
+	start := d["range"].(map[string]interface{})["start"].(map[string]interface{})
+	if start["line"] != 0.0 || start["character"] != 20.0 {
+		t.Errorf("Diagnostic starts at %v, expected line 0, character 20", start)
+	} else if !strings.Contains(d["message"].(string), "undefined") {
+		t.Errorf("Unexpected diagnostic message '%v'", d["message"])
+	}
+}
+
+func TestNamespaceDefinition(t *testing.T) {
+	src := "namespace io\n\t.write\n\t\tret\n\n\t.flush\n\t\tcal write\n\t\tret\nend\n\n" +
+	       ".write\n\tret\n\n.entry\n\tcal io.write\n\tcal write\n\thlt\n"
+
+	tests := []struct {
+		line, char, want int
+	}{
+		{5, 7,  1},  // 'write' in the namespace is 'io.write'
+		{13, 6, 1},  // Qualified
+		{14, 6, 9},  // Global
+	}
+
+	for _, tt := range tests {
+		msgs := serve(t, open(src), at("definition", tt.line, tt.char))
+
+		result, ok := msgs[1]["result"].(map[string]interface{})
+		if !ok {
+			t.Errorf("No definition at %v:%v", tt.line, tt.char)
+			continue
+		}
+
+		start := result["range"].(map[string]interface{})["start"].(map[string]interface{})
+		if start["line"] != float64(tt.want) {
+			t.Errorf("Definition of %v:%v is on line %v, expected %v",
+			         tt.line, tt.char, start["line"], tt.want)
+		}
+	}
+}
+
+// Definitions in included files are found
+func TestIncludeDefinition(t *testing.T) {
+	lib := filepath.Join(t.TempDir(), "lib.anasm")
+	if err := os.WriteFile(lib, []byte("\n.helper\n\tret\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	src  := fmt.Sprintf("include %q\n\n.entry\n\tcal helper\n\thlt\n", lib)
+	msgs := serve(t, open(src), at("definition", 3, 6))
+
+	result, ok := msgs[1]["result"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("No definition of 'helper': %v", msgs[1])
+	}
+
+	start := result["range"].(map[string]interface{})["start"].(map[string]interface{})
+	if result["uri"] != uriFromPath(lib) || start["line"] != 1.0 {
+		t.Errorf("Definition of 'helper' is at %v line %v, expected %v line 1", result["uri"],
+		         start["line"], uriFromPath(lib))
+	}
+}
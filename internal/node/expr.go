@@ -12,11 +12,18 @@ type Int struct {
 	Token token.Token
 
 	Value int64
+	Bool  bool // Written as true or false, which is 1 or 0
 }
 
 func (n *Int) expr() {}
 func (n *Int) GetToken() token.Token {return n.Token}
-func (n *Int) String()   string      {return fmt.Sprintf("%v", n.Value)}
+func (n *Int) String()   string {
+	if n.Bool {
+		return fmt.Sprintf("%v", n.Value != 0)
+	}
+
+	return fmt.Sprintf("%v", n.Value)
+}
 
 type Float struct {
 	Token token.Token
@@ -32,6 +39,8 @@ type String struct {
 	Token token.Token
 
 	Value string
+	File  string // Path of the file read with incstr, empty for literals
+	Raw   bool   // Written as UTF-8 bytes into elements of any size (bytes "...")
 }
 
 func (n *String) expr() {}
@@ -42,21 +51,35 @@ type Id struct {
 	Token token.Token
 
 	Value string
+	Scope string // Namespace the identifier is used in, resolved by the parser
 }
 
 func (n *Id) expr() {}
 func (n *Id) GetToken() token.Token {return n.Token}
 func (n *Id) String()   string      {return n.Value}
 
+// Qualifiers of element types, which decide the range values are checked against
+const (
+	Signed   = "signed"
+	Unsigned = "unsigned"
+)
+
 type Type struct {
 	Token token.Token
 
 	Type agen.Type
+	Sign string // Signed, Unsigned or empty for either
 }
 
 func (n *Type) expr() {}
 func (n *Type) GetToken() token.Token {return n.Token}
-func (n *Type) String()   string      {return n.Token.Data}
+func (n *Type) String()   string      {
+	if len(n.Sign) > 0 {
+		return n.Sign + " " + n.Token.Data
+	}
+
+	return n.Token.Data
+}
 
 type BinOp struct {
 	Token token.Token
@@ -94,6 +117,90 @@ func (n *SizeOf) String()   string {
 	}
 }
 
+// Reinterprets the value as an integer, so a float can be used where an integer is expected
+type Bits struct {
+	Token token.Token
+
+	Value Expr
+}
+
+func (n *Bits) expr() {}
+func (n *Bits) GetToken() token.Token {return n.Token}
+func (n *Bits) String()   string      {return fmt.Sprintf("(bits %v)", n.Value)}
+
+// Value of a list padded with zero elements to a fixed length, for fields of records
+type Pad struct {
+	Token token.Token
+
+	Length Expr
+	Value  Expr
+}
+
+func (n *Pad) expr() {}
+func (n *Pad) GetToken() token.Token {return n.Token}
+func (n *Pad) String()   string {
+	return fmt.Sprintf("(pad %v %v)", n.Length, n.Value)
+}
+
+// Length of a string in bytes of UTF-8, which is its count of elements in char data
+type StrLen struct {
+	Token token.Token
+
+	Value Expr
+}
+
+func (n *StrLen) expr() {}
+func (n *StrLen) GetToken() token.Token {return n.Token}
+func (n *StrLen) String()   string      {return fmt.Sprintf("(strlen %v)", n.Value)}
+
+// 1 if the name is a macro or a symbol given to the assembler, 0 otherwise. The name is not a use
+// of it, so it does not have to be defined.
+type Defined struct {
+	Token token.Token
+
+	Id *Id
+}
+
+func (n *Defined) expr() {}
+func (n *Defined) GetToken() token.Token {return n.Token}
+func (n *Defined) String()   string      {return fmt.Sprintf("(defined %v)", n.Id)}
+
+// Address of the element of a let being written (self), or of the let (selfbase)
+type Self struct {
+	Token token.Token
+
+	Base bool
+}
+
+func (n *Self) expr() {}
+func (n *Self) GetToken() token.Token {return n.Token}
+func (n *Self) String()   string {
+	if n.Base {
+		return "selfbase"
+	}
+
+	return "self"
+}
+
+// Strings joined into one
+type StrCat struct {
+	Token token.Token
+
+	Args []Expr
+}
+
+func (n *StrCat) expr() {}
+func (n *StrCat) GetToken() token.Token {return n.Token}
+func (n *StrCat) String()   (s string) {
+	s += "(strcat"
+	for _, arg := range n.Args {
+		s += fmt.Sprintf(" %v", arg)
+	}
+	s += ")"
+
+	return
+}
+
 type Fill struct {
 	Token token.Token
 
@@ -0,0 +1,153 @@
+package node
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Statements and expressions that failed to parse are nil pointers
+func IsNil(n Node) bool {
+	return n == nil || reflect.ValueOf(n).IsNil()
+}
+
+// Renders nodes back as assembly source. Implicit pushes are kept implicit, so unknown
+// instructions (which parse as implicit pushes of an identifier) come out unchanged.
+func Source(n Node) string {
+	if IsNil(n) {
+		return "<error>"
+	}
+
+	switch n := n.(type) {
+	case *Inst:
+		if n.Name == "psh" && n.Token.Data != "psh" {
+			return Source(n.Arg)
+		} else if n.Arg == nil {
+			return n.Name
+		}
+
+		return n.Name + " " + Source(n.Arg)
+
+	case *Label: return "." + Source(n.Name)
+	case *Embed: return fmt.Sprintf("emb %v %v", Source(n.Name), Source(n.Path))
+	case *Meta:  return fmt.Sprintf("meta %v %v", n.Key, Source(n.Value))
+	case *Org:   return "org " + Source(n.Addr)
+	case *Macro: return fmt.Sprintf("mac %v = %v", Source(n.Name), Source(n.Value))
+
+	case *Assert:
+		if n.Message == nil {
+			return "assert " + Source(n.Cond)
+		}
+
+		return fmt.Sprintf("assert %v, %v", Source(n.Cond), Source(n.Message))
+
+	case *Let:
+		return fmt.Sprintf("let %v %v = %v", Source(n.Name), Source(n.Type), sourceList(n.Values))
+
+	case *Data: return fmt.Sprintf("dat %v = %v", Source(n.Type), sourceList(n.Values))
+
+	case *Int:  return n.String()
+	case *Id:   return n.Value
+	case *Type: return n.String()
+
+	case *String:
+		s := Quote(n.Value)
+		if len(n.File) > 0 {
+			s = "incstr " + Quote(n.File)
+		}
+
+		if n.Raw {
+			return "bytes " + s
+		}
+
+		return s
+
+	case *Float: return FormatFloat(n.Value)
+
+	case *BinOp:
+		s := "(" + n.Op
+		for _, arg := range n.Args {
+			s += " " + Source(arg)
+		}
+
+		return s + ")"
+
+	case *StrCat:
+		s := "(strcat"
+		for _, arg := range n.Args {
+			s += " " + Source(arg)
+		}
+
+		return s + ")"
+
+	case *SizeOf:
+		if n.Id == nil {
+			return fmt.Sprintf("(sizeof %v)", Source(n.Type))
+		}
+
+		return fmt.Sprintf("(sizeof %v)", Source(n.Id))
+
+	case *Defined: return fmt.Sprintf("(defined %v)", Source(n.Id))
+
+	case *Bits:   return fmt.Sprintf("(bits %v)", Source(n.Value))
+	case *StrLen: return fmt.Sprintf("(strlen %v)", Source(n.Value))
+	case *Pad:    return fmt.Sprintf("(pad %v %v)", Source(n.Length), Source(n.Value))
+	case *Fill:   return fmt.Sprintf("%v .. %v", Source(n.Value), Source(n.Count))
+
+	default: return n.String()
+	}
+}
+
+func sourceList(values []Expr) string {
+	s := ""
+	for i, val := range values {
+		if i > 0 {
+			s += ", "
+		}
+
+		s += Source(val)
+	}
+
+	return s
+}
+
+// Formats a float as a literal, or the name of infinities and NaN, which have none
+func FormatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):  return "inf"
+	case math.IsInf(f, -1): return "-inf"
+	case math.IsNaN(f):     return "nan"
+	}
+
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+
+	return s
+}
+
+// Quotes a string with the escape sequences of the language
+func Quote(s string) string {
+	var q strings.Builder
+	q.WriteByte('"')
+
+	for i := 0; i < len(s); i ++ {
+		switch ch := s[i]; ch {
+		case 0:    q.WriteString("\\0")
+		case 27:   q.WriteString("\\e")
+		case '\n': q.WriteString("\\n")
+		case '\r': q.WriteString("\\r")
+		case '\t': q.WriteString("\\t")
+		case '\\': q.WriteString("\\\\")
+		case '"':  q.WriteString("\\\"")
+
+		default: q.WriteByte(ch)
+		}
+	}
+
+	q.WriteByte('"')
+	return q.String()
+}
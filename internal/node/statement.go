@@ -43,6 +43,8 @@ type Label struct {
 	Token token.Token
 
 	Name *Id
+
+	Fallthrough bool // Code falls through into it on purpose, marked with a comment
 }
 
 func (n *Label) statement() {}
@@ -60,6 +62,44 @@ func (n *Embed) statement() {}
 func (n *Embed) GetToken() token.Token {return n.Token}
 func (n *Embed) String()   string      {return fmt.Sprintf("(embed %v %v)", n.Name, n.Path)}
 
+type Meta struct {
+	Token token.Token
+
+	Key   string
+	Value *String
+}
+
+func (n *Meta) statement() {}
+func (n *Meta) GetToken() token.Token {return n.Token}
+func (n *Meta) String()   string      {return fmt.Sprintf("(meta %v %v)", n.Key, n.Value)}
+
+type Org struct {
+	Token token.Token
+
+	Addr Expr
+}
+
+func (n *Org) statement() {}
+func (n *Org) GetToken() token.Token {return n.Token}
+func (n *Org) String()   string      {return fmt.Sprintf("(org %v)", n.Addr)}
+
+type Assert struct {
+	Token token.Token
+
+	Cond    Expr
+	Message *String // Optional
+}
+
+func (n *Assert) statement() {}
+func (n *Assert) GetToken() token.Token {return n.Token}
+func (n *Assert) String()   string {
+	if n.Message == nil {
+		return fmt.Sprintf("(assert %v)", n.Cond)
+	} else {
+		return fmt.Sprintf("(assert %v %v)", n.Cond, n.Message)
+	}
+}
+
 type Macro struct {
 	Token token.Token
 
@@ -71,6 +111,25 @@ func (n *Macro) statement() {}
 func (n *Macro) GetToken() token.Token {return n.Token}
 func (n *Macro) String()   string      {return fmt.Sprintf("(macro %v %v)", n.Name, n.Value)}
 
+type Data struct {
+	Token token.Token
+
+	Type  *Type
+	Values []Expr
+}
+
+func (n *Data) statement() {}
+func (n *Data) GetToken() token.Token {return n.Token}
+func (n *Data) String()   (s string) {
+	s += fmt.Sprintf("(data %v", n.Type)
+	for _, val := range n.Values {
+		s += fmt.Sprintf(" %v", val)
+	}
+	s += ")"
+
+	return
+}
+
 type Let struct {
 	Token token.Token
 
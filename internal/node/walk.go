@@ -0,0 +1,74 @@
+package node
+
+// Calls f for every identifier the expression uses, nodes that failed to parse are skipped. The
+// name in a '(defined X)' is not a use, see WalkNames.
+func WalkIds(e Expr, f func(*Id)) {
+	walkIds(e, f, false)
+}
+
+// Like WalkIds, but includes the names in '(defined X)', for resolving them in namespaces
+func WalkNames(e Expr, f func(*Id)) {
+	walkIds(e, f, true)
+}
+
+func walkIds(e Expr, f func(*Id), names bool) {
+	if IsNil(e) {
+		return
+	}
+
+	switch n := e.(type) {
+	case *Id: f(n)
+
+	case *BinOp:
+		for _, arg := range n.Args {
+			walkIds(arg, f, names)
+		}
+
+	case *SizeOf:
+		if n.Id != nil {
+			f(n.Id)
+		}
+
+	case *Defined:
+		if names {
+			f(n.Id)
+		}
+
+	case *Bits:   walkIds(n.Value, f, names)
+	case *StrLen: walkIds(n.Value, f, names)
+
+	case *StrCat:
+		for _, arg := range n.Args {
+			walkIds(arg, f, names)
+		}
+
+	case *Pad:
+		walkIds(n.Length, f, names)
+		walkIds(n.Value,  f, names)
+
+	case *Fill:
+		walkIds(n.Value, f, names)
+		walkIds(n.Count, f, names)
+	}
+}
+
+// If the expression uses self or selfbase, which have a different value in every element
+func UsesSelf(e Expr) (uses bool) {
+	if IsNil(e) {
+		return false
+	}
+
+	switch n := e.(type) {
+	case *Self: return true
+	case *Bits: return UsesSelf(n.Value)
+	case *Pad:  return UsesSelf(n.Length) || UsesSelf(n.Value)
+	case *Fill: return UsesSelf(n.Value)  || UsesSelf(n.Count)
+
+	case *BinOp:
+		for _, arg := range n.Args {
+			uses = uses || UsesSelf(arg)
+		}
+	}
+
+	return uses
+}
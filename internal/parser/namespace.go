@@ -0,0 +1,160 @@
+package parser
+
+import (
+	"strings"
+
+	"github.com/avm-collection/anasm/internal/lexer"
+	"github.com/avm-collection/anasm/internal/node"
+	"github.com/avm-collection/anasm/internal/token"
+)
+
+// Namespaces prefix the names of labels, variables and macros defined inside them with the
+// namespace name and a dot, nested namespaces compose their prefixes. Names used inside a
+// namespace are looked up from the innermost namespace outwards once the whole program is parsed,
+// falling back to the global name.
+
+type namespace struct {
+	name string // Fully qualified
+	tok  token.Token
+}
+
+func (p *Parser) prefix() string {
+	if len(p.namespaces) == 0 {
+		return ""
+	}
+
+	return p.namespaces[len(p.namespaces) - 1].name
+}
+
+func (p *Parser) qualify(name string) string {
+	if prefix := p.prefix(); len(prefix) > 0 {
+		return prefix + "." + name
+	}
+
+	return name
+}
+
+// Turns a parsed identifier into a definition in the current namespace
+func (p *Parser) define(id *node.Id) {
+	if id != nil {
+		p.checkName(id.Token)
+
+		id.Value = p.qualify(id.Value)
+		id.Scope = ""
+	}
+}
+
+// Names are checked where they are defined, uses of invalid names are undefined. Uses of the
+// names of builtin constants are always the constant, so those can not be defined.
+func (p *Parser) checkName(tok token.Token) {
+	if err := lexer.CheckName(tok.Data, p.UnicodeNames); err != nil {
+		p.Diags.Error(tok.Where, "%v", err)
+	} else if predefined(tok) != nil {
+		p.Diags.Error(tok.Where, "'%v' is a builtin constant, it can not be redefined", tok.Data)
+	}
+}
+
+func (p *Parser) parseNamespace() {
+	start := p.tok
+	p.next()
+
+	if p.tok.Type != token.Id {
+		p.expected("namespace name")
+		p.next()
+		return
+	}
+
+	p.checkName(p.tok)
+
+	p.namespaces = append(p.namespaces, namespace{name: p.qualify(p.tok.Data), tok: start})
+	p.next()
+}
+
+func (p *Parser) parseEnd() {
+	if len(p.namespaces) <= p.fileNamespaces {
+		p.Diags.Error(p.tok.Where, "Unexpected '%v' outside of a namespace", token.End)
+	} else {
+		p.namespaces = p.namespaces[:len(p.namespaces) - 1]
+	}
+
+	p.next()
+}
+
+// Namespaces can not span files
+func (p *Parser) closeNamespaces(depth int) {
+	for len(p.namespaces) > depth {
+		open := p.namespaces[len(p.namespaces) - 1]
+		p.Diags.Error(open.tok.Where, "Namespace '%v' is not closed with '%v'",
+		              open.name, token.End)
+
+		p.namespaces = p.namespaces[:len(p.namespaces) - 1]
+	}
+}
+
+func (p *Parser) resolveNames() {
+	defined := make(map[string]bool)
+	for _, s := range p.statements.List {
+		if node.IsNil(s) {
+			continue
+		}
+
+		var name *node.Id
+		switch n := s.(type) {
+		case *node.Label: name = n.Name
+		case *node.Let:   name = n.Name
+		case *node.Macro: name = n.Name
+		case *node.Embed: name = n.Name
+		}
+
+		if !node.IsNil(name) {
+			defined[name.Value] = true
+		}
+	}
+
+	resolve := func(id *node.Id) {
+		id.Value = Resolve(id.Value, id.Scope, defined)
+		id.Scope = ""
+	}
+
+	for _, s := range p.statements.List {
+		if node.IsNil(s) {
+			continue
+		}
+
+		switch n := s.(type) {
+		case *node.Inst:   node.WalkNames(n.Arg,   resolve)
+		case *node.Macro:  node.WalkNames(n.Value, resolve)
+		case *node.Assert: node.WalkNames(n.Cond,  resolve)
+		case *node.Org:    node.WalkNames(n.Addr,  resolve)
+
+		case *node.Let:
+			for _, val := range n.Values {
+				node.WalkNames(val, resolve)
+			}
+
+		case *node.Data:
+			for _, val := range n.Values {
+				node.WalkNames(val, resolve)
+			}
+		}
+	}
+}
+
+// Fully qualified name that a name used in the namespace scope refers to, looked up among the
+// defined names from the innermost namespace outwards, falling back to the global name
+func Resolve(name, scope string, defined map[string]bool) string {
+	for len(scope) > 0 {
+		if qualified := scope + "." + name; defined[qualified] {
+			return qualified
+		}
+
+		i := strings.LastIndexByte(scope, '.')
+		if i == -1 {
+			break
+		}
+
+		scope = scope[:i]
+	}
+
+	return name
+}
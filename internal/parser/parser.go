@@ -2,103 +2,381 @@ package parser
 
 import (
 	"os"
+	"fmt"
+	"math"
+	"errors"
 	"strconv"
+	"strings"
 	"path/filepath"
 
-	"github.com/avm-collection/goerror"
 	"github.com/avm-collection/agen"
 
+	"github.com/avm-collection/anasm/internal/diag"
+	"github.com/avm-collection/anasm/internal/config"
 	"github.com/avm-collection/anasm/internal/lexer"
 	"github.com/avm-collection/anasm/internal/token"
 	"github.com/avm-collection/anasm/internal/node"
 )
 
+// Section directives are written like labels. Before the first one in a file, data and code can
+// be mixed freely.
+type section int
+const (
+	noSection = section(iota)
+	dataSection
+	textSection
+)
+
+func (s section) String() string {
+	switch s {
+	case dataSection: return ".data"
+	case textSection: return ".text"
+
+	default: panic("Unreachable")
+	}
+}
+
 type Parser struct {
 	statements *node.Statements
+	section    section // Of the current file
+
+	namespaces     []namespace // Open namespaces, innermost last
+	fileNamespaces int         // Count of the namespaces opened before the current file
 
-	tok token.Token
-	l  *lexer.Lexer
+	includes []string // Files being included, innermost last
+	files    []string // Included and incstr files read
+	missing  []string // See Missing
+
+	included map[string]string // Paths of includes as written -> the files read, see IncludedFile
+
+	suppressions []Suppression // Of the warning pragmas, see Suppressions
+
+	tok, prev token.Token
+	l        *lexer.Lexer
+
+	errorRow int // Row of the first lexer error skipped right before the current token, 0 if none
 
 	input, path string
+
+	AllowInstNames bool     // Allow labels, variables and macros named like instructions
+	IncludeDirs    []string // Searched for included files not found in the current directory
+	Comments       []string // Line comment introducers, lexer.DefaultComments if nil
+	UnicodeNames   bool     // Allow Unicode letters and digits in names, see lexer.CheckName
+	ExplicitTypes  bool     // Require the element type of lets instead of inferring it
+
+	// Take the arguments of instructions from the next lines too, instead of only from their own
+	// line and the lines it is continued on
+	ArgsAcrossLines bool
+
+	NormalizeNewlines bool // Convert CRLF line endings of incstr files to LF
+	MaxIncludeDepth   int  // DefaultMaxIncludeDepth if 0
+
+	Diags *diag.Log // Held back until flushed by the user of the parser
 }
 
+const DefaultMaxIncludeDepth = 64
+
 func New(input, path string) *Parser {
-	return &Parser{input: input, path: path}
+	return &Parser{input: input, path: path, Diags: &diag.Log{}}
 }
 
 func (p *Parser) Parse() *node.Statements {
 	p.statements = &node.Statements{}
-	p.parseFile(p.input, p.path)
+	p.files      = nil
+	p.missing    = nil
+	p.included   = make(map[string]string)
+
+	p.suppressions = nil
+	p.parseFile(p.input, p.path, nil)
+	p.resolveNames()
 
 	return p.statements
 }
 
+// Included and text files read by Parse, in the order they were read
+func (p *Parser) Files() []string {
+	return p.files
+}
+
+// File read for tokens of the path. Tokens of included files have the path of the include as it
+// is written, not the path of the file it was found at.
+func (p *Parser) IncludedFile(path string) string {
+	if file, ok := p.included[path]; ok {
+		return file
+	}
+
+	return path
+}
+
+// Paths where Parse looked for included and text files that were not there. A file created at one
+// of them would be read instead of the one found after it.
+func (p *Parser) Missing() []string {
+	return p.missing
+}
+
 func (p *Parser) next() {
 	if p.tok.Type == token.EOF {
 		return
 	}
 
-	if p.tok = p.l.NextToken(); p.tok.Type == token.Error {
-		goerror.Error(p.tok.Where, p.tok.Data)
-		os.Exit(1)
+	p.prev = p.tok
+	p.tok  = p.nextToken()
+}
+
+// Reports lexer errors and continues with the next valid token, so all of them are reported
+func (p *Parser) nextToken() token.Token {
+	p.errorRow = 0
+	for {
+		tok := p.l.NextToken()
+		if tok.Type != token.Error {
+			return tok
+		}
+
+		p.Diags.Error(tok.Where, tok.Data)
+		if p.errorRow == 0 {
+			p.errorRow = tok.Where.Row
+		}
+	}
+}
+
+// Reports that something else was expected, at the end of file the error points to the last token
+// instead of the end of file. Identifiers there are named, like the instruction missing its
+// argument.
+func (p *Parser) expected(what string) {
+	if p.tok.Type == token.EOF {
+		after := p.prev.String()
+		if p.prev.Type == token.Id {
+			after = fmt.Sprintf("'%v'", p.prev.Data)
+		}
+
+		p.Diags.Error(p.prev.Where, "Expected %v after %v, reached end of file", what, after)
+	} else {
+		p.Diags.Error(p.tok.Where, "Expected %v, got %v", what, p.tok)
 	}
 }
 
-func (p *Parser) parseFile(input, path string) {
-	prevLexer := p.l
-	prevTok   := p.tok
+func (p *Parser) parseFile(input, path string, includedFrom *token.Where) {
+	prevLexer   := p.l
+	prevTok     := p.tok
+	prevSection := p.section
+	prevDepth   := p.fileNamespaces
 
-	p.l = lexer.New(input, path)
+	p.l              = lexer.New(input, path)
+	p.section        = noSection
+	p.fileNamespaces = len(p.namespaces)
 
-	if p.tok = p.l.NextToken(); p.tok.Type == token.Error {
-		goerror.Error(p.tok.Where, p.tok.Data)
-		os.Exit(1)
+	if p.Comments != nil {
+		p.l.Comments = p.Comments
 	}
 
+	p.l.IncludedFrom = includedFrom
+	p.l.UnicodeNames = p.UnicodeNames
+
+	p.tok = p.nextToken()
 	for p.tok.Type != token.EOF {
 		var s node.Statement
 
 		switch p.tok.Type {
-		case token.Id:    s = p.parseInst()
-		case token.Label: s = p.parseLabel()
-		case token.Let:   s = p.parseLet()
-		case token.Embed: s = p.parseEmbed()
+		case token.Label:
+			if p.parseSection() {
+				continue
+			}
+
+			p.inSection(textSection, "Label")
+			s = p.parseLabel()
+
+		case token.Let:   p.inSection(dataSection, "Variable");      s = p.parseLet()
+		case token.Embed: p.inSection(dataSection, "Embedded file"); s = p.parseEmbed()
+		case token.Data:  p.inSection(textSection, "Inline data");   s = p.parseData()
+		case token.Id:    p.inSection(textSection, "Instruction");   s = p.parseInst()
+
 		case token.Macro: s = p.parseMacro()
+		case token.Meta:  s = p.parseMeta()
+
+		case token.Assert: s = p.parseAssert()
+		case token.Org:    s = p.parseOrg()
 
 		case token.Include:
 			p.evalInclude()
 			continue
 
-		default: s = p.parseImplicitPush()
+		case token.Namespace:
+			p.parseNamespace()
+			continue
+
+		case token.End:
+			p.parseEnd()
+			continue
+
+		default:
+			p.inSection(textSection, "Instruction")
+			s = p.parseImplicitPush()
+		}
+
+		// Failed statements are reported already, and would be typed nil for every later pass
+		if node.IsNil(s) {
+			continue
 		}
 
 		p.statements.List = append(p.statements.List, s)
 	}
 
-	p.l   = prevLexer
-	p.tok = prevTok
+	p.closeNamespaces(p.fileNamespaces)
+	p.readPragmas(path, strings.Count(input, "\n") + 1)
+
+	p.l              = prevLexer
+	p.tok            = prevTok
+	p.section        = prevSection
+	p.fileNamespaces = prevDepth
+}
+
+// Parses a section directive if the label is one
+func (p *Parser) parseSection() bool {
+	switch p.tok.Data {
+	case "data": p.section = dataSection
+	case "text": p.section = textSection
+
+	default: return false
+	}
+
+	p.next()
+	return true
+}
+
+func (p *Parser) inSection(want section, what string) {
+	if p.section != noSection && p.section != want {
+		p.Diags.Error(p.tok.Where, "%v in a %v section, it belongs in a %v section",
+		              what, p.section, want)
+	}
 }
 
 func (p *Parser) evalInclude() {
 	p.next()
 	path := p.parseString()
-
-	toInclude := path.Value
-	if toInclude[0] == '.' {
-		toInclude = filepath.Dir(p.path) + toInclude[1:]
+	if path == nil {
+		return
+	} else if len(path.Value) == 0 {
+		p.Diags.Error(path.Token.Where, "Include path is empty")
+		return
 	}
 
+	toInclude := p.findFile(path.Value)
 	data, err := os.ReadFile(toInclude)
 	if err != nil {
-		goerror.Error(path.GetToken().Where, "Could not open file '%v'", toInclude)
+		p.Diags.Error(path.GetToken().Where, "Could not open file '%v'", toInclude)
+		return
+	}
+
+	p.files = append(p.files, toInclude)
+	p.included[path.Value] = toInclude
+
+	max := p.MaxIncludeDepth
+	if max == 0 {
+		max = DefaultMaxIncludeDepth
+	}
+
+	// Without conditionals a file including itself never stops
+	files := append([]string{p.path}, p.includes...)
+	for _, file := range files {
+		if filepath.Clean(file) == filepath.Clean(toInclude) {
+			p.Diags.Error(path.Token.Where, "File '%v' includes itself", toInclude)
+			return
+		}
+	}
+
+	if len(p.includes) >= max {
+		p.Diags.Error(path.Token.Where, "Includes nested deeper than %v", max)
 		return
 	}
 
-	p.parseFile(string(data), path.Value)
+	where     := path.Token.Where
+	p.includes = append(p.includes, toInclude)
+	p.parseFile(string(data), path.Value, &where)
+	p.includes = p.includes[:len(p.includes) - 1]
 }
 
+// Paths starting with '.' are relative to the input file, others are searched in the current
+// directory and then the include directories
+func (p *Parser) findFile(path string) string {
+	if path[0] == '.' {
+		return filepath.Dir(p.path) + path[1:]
+	} else if _, err := os.Stat(path); err != nil && !filepath.IsAbs(path) {
+		p.missing = append(p.missing, path)
+		for _, dir := range p.IncludeDirs {
+			file := filepath.Join(dir, path)
+			if _, err := os.Stat(file); err == nil {
+				return file
+			}
+
+			p.missing = append(p.missing, file)
+		}
+	}
+
+	return path
+}
+
+// Text files are read into a string, found like included files
+func (p *Parser) parseIncStr() node.Expr {
+	start := p.tok
+	p.next()
+
+	path := p.parseString()
+	if path == nil {
+		return nil
+	} else if len(path.Value) == 0 {
+		p.Diags.Error(path.Token.Where, "Text file path is empty")
+		return nil
+	}
+
+	file      := p.findFile(path.Value)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		p.Diags.Error(path.Token.Where, "Could not open file '%v'", file)
+		return nil
+	}
+
+	p.files = append(p.files, file)
+
+	text := string(data)
+	if p.NormalizeNewlines {
+		text = strings.ReplaceAll(text, "\r\n", "\n")
+	}
+
+	return &node.String{Token: start, Value: text, File: file}
+}
+
+// Strings written as their UTF-8 bytes, whatever the element size
+func (p *Parser) parseBytes() node.Expr {
+	start := p.tok
+	p.next()
+
+	var e node.Expr
+	switch p.tok.Type {
+	case token.String: e = p.parseString()
+	case token.IncStr: e = p.parseIncStr()
+
+	default:
+		p.expected("a string or incstr")
+		p.next()
+		return nil
+	}
+
+	if node.IsNil(e) {
+		return nil
+	}
+
+	n      := e.(*node.String)
+	n.Token = start
+	n.Raw   = true
+	return n
+}
+
+// The token is taken before the argument is parsed, reading it in the same composite literal as
+// the call could see the token after the argument
 func (p *Parser) parseImplicitPush() *node.Inst {
-	return &node.Inst{Token: p.tok, Name: "psh", Arg: p.parseExpr()}
+	n    := &node.Inst{Token: p.tok, Name: "psh"}
+	n.Arg = p.parseExpr()
+	return n
 }
 
 func (p *Parser) parseMacro() *node.Macro {
@@ -106,9 +384,9 @@ func (p *Parser) parseMacro() *node.Macro {
 	p.next()
 
 	n.Name = p.parseId()
+	p.define(n.Name)
 	if p.tok.Type != token.Equals {
-		goerror.Error(p.tok.Where, "Expected assignment with '%v', got %v",
-		              token.Equals, p.tok)
+		p.expected(fmt.Sprintf("assignment with '%v'", token.Equals))
 		p.next()
 		return nil
 	}
@@ -118,44 +396,293 @@ func (p *Parser) parseMacro() *node.Macro {
 	return n
 }
 
+func (p *Parser) parseMeta() node.Statement {
+	n := &node.Meta{Token: p.tok}
+	p.next()
+
+	// Keys are not symbols, so they can be named like instructions
+	if p.tok.Type != token.Id {
+		p.expected("metadata key")
+		p.next()
+		return nil
+	}
+	n.Key = p.tok.Data
+	p.next()
+
+	if n.Value = p.parseString(); n.Value == nil {
+		return nil
+	}
+
+	return n
+}
+
+func (p *Parser) parseOrg() node.Statement {
+	n := &node.Org{Token: p.tok}
+	p.next()
+
+	if n.Addr = p.parseExpr(); n.Addr == nil {
+		return nil
+	}
+
+	return n
+}
+
+func (p *Parser) parseAssert() node.Statement {
+	n := &node.Assert{Token: p.tok}
+	p.next()
+
+	if n.Cond = p.parseExpr(); n.Cond == nil {
+		return nil
+	}
+
+	if p.tok.Type == token.Comma {
+		p.next()
+
+		if n.Message = p.parseString(); n.Message == nil {
+			return nil
+		}
+	}
+
+	return n
+}
+
 func (p *Parser) parseLet() node.Statement {
 	n := &node.Let{Token: p.tok}
 	p.next()
 
 	n.Name = p.parseId()
-	n.Type = p.parseType()
+	p.define(n.Name)
+
+	sign := p.parseSign()
+
+	// The values are still parsed without a type, so they are not reported as garbage
+	infer := p.tok.Type == token.Equals
+	if infer && p.ExplicitTypes {
+		p.Diags.Error(p.tok.Where, "Missing the element type of '%v' (let %v TYPE = ...)",
+		              n.Name, n.Name)
+	} else if !infer {
+		n.Type = p.parseType()
+	}
+
+	if p.tok.Type != token.Equals {
+		p.expected(fmt.Sprintf("assignment with '%v'", token.Equals))
+		p.next()
+		return nil
+	}
+
+	p.next()
+
+	n.Values = p.parseValues(fmt.Sprintf("'%v'", n.Name))
+	if infer {
+		n.Type = p.inferType(n)
+	}
+
+	p.applySign(n.Type, sign)
+	return n
+}
+
+// Signed and unsigned qualifiers of element types are not keywords, so they can still be used as
+// names. Nil if there is none.
+func (p *Parser) parseSign() *token.Token {
+	if p.tok.Type != token.Id || (p.tok.Data != node.Signed && p.tok.Data != node.Unsigned) {
+		return nil
+	}
+
+	sign := p.tok
+	p.next()
+	return &sign
+}
+
+func (p *Parser) applySign(type_ *node.Type, sign *token.Token) {
+	if sign == nil || type_ == nil {
+		return
+	} else if type_.Token.Type == token.TypeFloat64 {
+		p.Diags.Error(sign.Where, "'%v' only applies to integer elements, not '%v'", sign.Data,
+		              type_.Token.Data)
+		return
+	}
+
+	type_.Sign = sign.Data
+}
+
+// Lets without a type get char elements if their first value is a string (like "hi", 0), f64
+// ones if it is a float and i64 ones otherwise. Strings after a first value that is not one are
+// ambiguous, their characters would silently become 8 bytes each.
+func (p *Parser) inferType(n *node.Let) *node.Type {
+	keyword := func(e node.Expr) string {
+		if fill, ok := e.(*node.Fill); ok && !node.IsNil(fill) {
+			e = fill.Value
+		}
+
+		if pad, ok := e.(*node.Pad); ok && !node.IsNil(pad) {
+			e = pad.Value
+		}
+
+		switch e.(type) {
+		case *node.String, *node.StrCat: return "char"
+		case *node.Float:                return "f64"
+
+		default: return "i64"
+		}
+	}
+
+	inferred := keyword(n.Values[0])
+	for _, val := range n.Values[1:] {
+		if node.IsNil(n.Values[0]) || p.ExplicitTypes {
+			break
+		} else if !node.IsNil(val) && keyword(val) == "char" && inferred != "char" {
+			p.Diags.Error(val.GetToken().Where, "String in '%v', which is inferred as %v, write " +
+			              "the type (let %v TYPE = ...)", n.Name, inferred, n.Name)
+			p.Diags.Note(n.Values[0].GetToken().Where, "Inferred from the first value")
+			break
+		}
+	}
 
+	tok  := token.Token{Type: lexer.Keywords[inferred], Data: inferred, Where: n.Token.Where}
+	t, _ := TypeOf(tok.Type)
+	return &node.Type{Token: tok, Type: t}
+}
+
+func (p *Parser) parseData() node.Statement {
+	n := &node.Data{Token: p.tok}
+	p.next()
+
+	sign := p.parseSign()
+
+	n.Type = p.parseType()
+	p.applySign(n.Type, sign)
 	if p.tok.Type != token.Equals {
-		goerror.Error(p.tok.Where, "Expected assignment with '%v' or size with '%v', got %v",
-		              token.Equals, token.Dots, p.tok)
+		p.expected(fmt.Sprintf("assignment with '%v'", token.Equals))
 		p.next()
 		return nil
 	}
 
 	p.next()
 
+	n.Values = p.parseValues("inline data")
+	return n
+}
+
+// Whether the token can start a value in a list, instead of the next statement
+func (p *Parser) startsValue() bool {
+	switch p.tok.Type {
+	case token.Id:
+		_, ok := agen.Insts[p.tok.Data]
+		return !ok
+
+	case token.LParen, token.String, token.IncStr, token.Bytes, token.Float: return true
+
+	default: return p.tok.Type.IsInt()
+	}
+}
+
+// Whether the token can only be the start of a statement, never a part of an expression
+func (p *Parser) startsStatement() bool {
+	switch p.tok.Type {
+	case token.EOF, token.Label, token.Let, token.Macro, token.Data, token.Embed, token.Include,
+	     token.Assert, token.Org, token.Meta, token.Namespace, token.End:
+		return true
+
+	case token.Id:
+		_, ok := agen.Insts[p.tok.Data]
+		return ok
+
+	default: return false
+	}
+}
+
+// Whether parsing the arguments of a function has to stop at the token, so a missing ')' is
+// reported once instead of for every token after it
+func (p *Parser) endsArgs() bool {
+	return p.tok.Type == token.Comma || p.tok.Type == token.Equals || p.startsStatement()
+}
+
+// Skips the rest of a value that failed to parse, up to the comma before the next value, the next
+// statement or the end of the line, so every mistake in a list is reported once
+func (p *Parser) skipValue() {
+	row := p.prev.Where.EndRow
+	for p.tok.Type != token.Comma && !p.startsStatement() && p.tok.Where.Row == row {
+		p.next()
+	}
+}
+
+// Values of a let or dat list. A trailing comma is allowed when the list is followed by an
+// instruction, a label or another statement. Values that fail to parse are skipped, and the list
+// continues after them.
+func (p *Parser) parseValues(of string) (values []node.Expr) {
+	multiline := false
 	for {
-		val := p.parseExpr()
-		if p.tok.Type == token.Dots {
-			fill := &node.Fill{Token: p.tok}
+		// A value the lexer failed on was reported already, and nothing took its place
+		if p.errorRow == 0 || p.startsValue() {
+			values = append(values, p.parseValue(of))
+		}
+
+		// Anything else left on the line is a mistake after the value, which can be a string
+		// spanning lines
+		if p.tok.Type != token.Comma && !p.startsValue() && !p.startsStatement() &&
+		   p.tok.Where.Row == p.prev.Where.EndRow {
+			p.Diags.Error(p.tok.Where, "Unexpected %v after a value of %v", p.tok, of)
 			p.next()
+			p.skipValue()
+		}
 
-			fill.Value = val
-			fill.Count = p.parseExpr()
+		if p.tok.Type != token.Comma {
+			if !p.startsValue() {
+				break
+			}
+
+			// Values on the same line are surely a missing comma, on the next line it could be
+			// an implicit push
+			if p.tok.Where.Row == p.prev.Where.EndRow {
+				p.Diags.Error(p.tok.Where, "Missing ',' between the values of %v", of)
+				continue
+			} else if multiline {
+				p.Diags.Warning(p.tok.Where, "Possibly missing ',' after the values of %v, " +
+				                "this is an implicit push", of)
+			}
 
-			n.Values = append(n.Values, fill)
-		} else {
-			n.Values = append(n.Values, val)
+			break
 		}
 
-		if p.tok.Type != token.Comma {
+		comma := p.tok
+		if p.next(); p.tok.Where.Row != comma.Where.Row {
+			multiline = true
+		}
+
+		// A trailing comma, unless what follows is neither a value nor a statement. The file
+		// can not end after one, unless a value that failed to lex was there.
+		if p.tok.Type == token.EOF {
+			if p.errorRow == 0 {
+				p.expected("a value")
+			}
+
+			break
+		} else if !p.startsValue() && p.startsStatement() {
 			break
-		} else {
-			p.next()
 		}
 	}
 
-	return n
+	return
+}
+
+// A value of a list, or a fill of it
+func (p *Parser) parseValue(of string) node.Expr {
+	val := p.parseExpr()
+	if node.IsNil(val) {
+		p.Diags.Note(p.prev.Where, "In the values of %v", of)
+		p.skipValue()
+	}
+
+	if p.tok.Type != token.Dots {
+		return val
+	}
+
+	fill := &node.Fill{Token: p.tok}
+	p.next()
+
+	fill.Value = val
+	fill.Count = p.parseExpr()
+	return fill
 }
 
 func (p *Parser) parseEmbed() *node.Embed {
@@ -163,6 +690,7 @@ func (p *Parser) parseEmbed() *node.Embed {
 	p.next()
 
 	n.Name = p.parseId()
+	p.define(n.Name)
 	n.Path = p.parseString()
 	return n
 }
@@ -170,11 +698,40 @@ func (p *Parser) parseEmbed() *node.Embed {
 func (p *Parser) parseLabel() *node.Label {
 	n := &node.Label{Token: p.tok}
 
-	n.Name = &node.Id{Token: p.tok, Value: p.tok.Data}
+	if _, ok := agen.Insts[p.tok.Data]; ok && !p.AllowInstNames {
+		p.Diags.Error(p.tok.Where, "Label '%v' has the same name as an instruction, rename it",
+		              p.tok.Data)
+		p.next()
+		return nil
+	}
+
+	p.checkName(p.tok)
+
+	n.Name        = &node.Id{Token: p.tok, Value: p.qualify(p.tok.Data)}
+	n.Fallthrough = p.markedFallthrough()
 	p.next()
 	return n
 }
 
+// Comment marking that code falls through into the next label on purpose
+const FallthroughComment = "fallthrough"
+
+// If there is a fallthrough comment on the label's line or between it and the previous token
+func (p *Parser) markedFallthrough() bool {
+	from := p.tok.Where.Row
+	if p.prev.Where.Path == p.tok.Where.Path && p.prev.Where.Row > 0 {
+		from = p.prev.Where.Row
+	}
+
+	for row := from; row <= p.tok.Where.Row; row ++ {
+		if p.l.Comment(row) == FallthroughComment {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (p *Parser) parseInst() *node.Inst {
 	n := &node.Inst{Token: p.tok}
 
@@ -185,18 +742,53 @@ func (p *Parser) parseInst() *node.Inst {
 	n.Name = p.tok.Data
 
 	p.next()
-	if inst.HasArg {
-		n.Arg = p.parseExpr()
+	if !inst.HasArg {
+		return n
+	}
+
+	// A value on the next line is likely meant for something else, like the first element of a
+	// table, and taking it would silently shift everything after it. It is left to be parsed as
+	// its own statement.
+	row := n.Token.Where.Row
+	if p.tok.Type != token.EOF && !p.ArgsAcrossLines && !p.sameLine(row, p.tok.Where.Row) {
+		// Unless the argument was malformed and already reported
+		if p.errorRow == 0 || !p.sameLine(row, p.errorRow) {
+			p.Diags.Error(p.tok.Where, "Argument of '%v' is not on its line, end the line with " +
+			              "'\\' to continue it", n.Name)
+			p.Diags.Note(n.Token.Where, "Instruction here")
+		}
+
+		return n
 	}
 
+	n.Arg = p.parseExpr()
 	return n
 }
 
+// If the rows of the current file are the same line, counting continued lines as one
+func (p *Parser) sameLine(from, to int) bool {
+	for row := from; row < to; row ++ {
+		if !p.l.Continued(row) {
+			return false
+		}
+	}
+
+	return true
+}
+
 func (p *Parser) parseExpr() node.Expr {
 	switch p.tok.Type {
-	case token.Id:     return p.parseId()
+	case token.Id:
+		if e := p.parsePredefined(); e != nil {
+			return e
+		}
+
+		return p.parseId()
+
 	case token.LParen: return p.parseFunc()
 	case token.String: return p.parseString()
+	case token.IncStr: return p.parseIncStr()
+	case token.Bytes:  return p.parseBytes()
 	case token.Float:  return p.parseFloat()
 
 	default:
@@ -204,30 +796,80 @@ func (p *Parser) parseExpr() node.Expr {
 			return p.parseInt()
 		} else if p.tok.Type.IsType() {
 			return p.parseType()
+		} else if p.tok.Type == token.EOF {
+			p.expected("an expression")
+			return nil
 		} else {
-			goerror.Error(p.tok.Where, "Unexpected %v in expression", p.tok)
+			p.Diags.Error(p.tok.Where, "Unexpected %v in expression", p.tok)
 			p.next()
 			return nil
 		}
 	}
 }
 
+// Version of the assembler as a single comparable number, 1.2.3 is 1002003
+const anasmVersion = config.VersionMajor * 1000000 + config.VersionMinor * 1000 +
+                     config.VersionPatch
+
+// Names replaced with a value depending on where they are used, nil if the token is not one
+func predefined(tok token.Token) node.Expr {
+	switch tok.Data {
+	case "__FILE__": return &node.String{Token: tok, Value: tok.Where.Path}
+	case "__LINE__": return &node.Int{Token: tok, Value: int64(tok.Where.Row)}
+
+	case "__ANASM_VERSION__": return &node.Int{Token: tok, Value: anasmVersion}
+
+	case "true":  return &node.Int{Token: tok, Value: 1, Bool: true}
+	case "false": return &node.Int{Token: tok, Value: 0, Bool: true}
+
+	// Addresses in the let being written
+	case "self":     return &node.Self{Token: tok}
+	case "selfbase": return &node.Self{Token: tok, Base: true}
+
+	// Floats without a literal
+	case "inf":  return &node.Float{Token: tok, Value: math.Inf(1)}
+	case "-inf": return &node.Float{Token: tok, Value: math.Inf(-1)}
+	case "nan":  return &node.Float{Token: tok, Value: math.NaN()}
+
+	default: return nil
+	}
+}
+
+func (p *Parser) parsePredefined() node.Expr {
+	e := predefined(p.tok)
+	if e != nil {
+		p.next()
+	}
+
+	return e
+}
+
 func (p *Parser) parseId() *node.Id {
 	n := &node.Id{Token: p.tok}
 
 	if p.tok.Type != token.Id {
-		goerror.Error(p.tok.Where, "Expected identifier, got %v", p.tok)
+		p.expected("identifier")
+		p.next()
+		return nil
+	}
+
+	if _, ok := agen.Insts[p.tok.Data]; ok && !p.AllowInstNames {
+		p.Diags.Error(p.tok.Where, "Expected identifier, got instruction '%v' (if this is a " +
+		              "name, rename it)", p.tok.Data)
 		p.next()
 		return nil
 	}
 
-	if _, ok := agen.Insts[p.tok.Data]; ok {
-		goerror.Error(p.tok.Where, "Expected identifier, got instruction '%v'", p.tok.Data)
+	// Operator characters lex into identifiers, so 'foo+1' would be a name that can never be
+	// defined
+	if err := lexer.CheckName(p.tok.Data, p.UnicodeNames); err != nil {
+		p.Diags.Error(p.tok.Where, "%v", err)
 		p.next()
 		return nil
 	}
 
 	n.Value = p.tok.Data
+	n.Scope = p.prefix()
 	p.next()
 	return n
 }
@@ -236,7 +878,7 @@ func (p *Parser) parseString() *node.String {
 	n := &node.String{Token: p.tok}
 
 	if p.tok.Type != token.String {
-		goerror.Error(p.tok.Where, "Expected string, got %v", p.tok)
+		p.expected("string")
 		p.next()
 		return nil
 	}
@@ -247,50 +889,106 @@ func (p *Parser) parseString() *node.String {
 }
 
 func (p *Parser) parseInt() *node.Int {
-	n := &node.Int{Token: p.tok}
-
 	switch p.tok.Type {
-	case token.Dec:  n.Value, _ = strconv.ParseInt(p.tok.Data, 10, 64)
-	case token.Hex:  n.Value, _ = strconv.ParseInt(p.tok.Data, 16, 64)
-	case token.Oct:  n.Value, _ = strconv.ParseInt(p.tok.Data, 8,  64)
-	case token.Bin:  n.Value, _ = strconv.ParseInt(p.tok.Data, 2,  64)
-	case token.Char: n.Value    = int64(p.tok.Data[0])
+	case token.Dec, token.Hex, token.Oct, token.Bin, token.Char:
 
 	default:
-		goerror.Error(p.tok.Where, "Expected an integer or a character, got %v", p.tok)
+		p.Diags.Error(p.tok.Where, "Expected an integer or a character, got %v", p.tok)
 		p.next()
 		return nil
 	}
 
+	n := &node.Int{Token: p.tok}
+
+	var err error
+	if n.Value, err = intValue(p.tok); err != nil {
+		p.Diags.Error(p.tok.Where, "%v", err)
+	}
+
 	p.next()
 	return n
 }
 
+// Parses an integer or a character literal given outside of a program, like on the command line
+func ParseInt(s string) (int64, error) {
+	l   := lexer.New(s, "")
+	tok := l.NextToken()
+	if tok.Type == token.Error {
+		return 0, errors.New(tok.Data)
+	} else if next := l.NextToken(); next.Type != token.EOF {
+		return 0, fmt.Errorf("Unexpected %v after the integer", next)
+	}
+
+	return intValue(tok)
+}
+
+func intValue(tok token.Token) (int64, error) {
+	switch tok.Type {
+	case token.Dec:
+		value, err := strconv.ParseInt(tok.Data, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("Decimal integer '%v' is out of range (%v to %v)",
+			                     tok.Data, int64(math.MinInt64), int64(math.MaxInt64))
+		}
+
+		return value, nil
+
+	// Any 64 bit pattern can be written in these, so they are unsigned
+	case token.Hex: return uintValue(tok.Data, 16, "0x")
+	case token.Oct: return uintValue(tok.Data, 8,  "0o")
+	case token.Bin: return uintValue(tok.Data, 2,  "0b")
+
+	case token.Char: return int64(tok.Data[0]), nil
+
+	default: return 0, fmt.Errorf("Expected an integer or a character, got %v", tok)
+	}
+}
+
+func uintValue(digits string, base int, prefix string) (int64, error) {
+	if len(digits) == 0 {
+		return 0, fmt.Errorf("Expected digits after '%v'", prefix)
+	}
+
+	value, err := strconv.ParseUint(digits, base, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Integer '%v%v' does not fit into 64 bits", prefix, digits)
+	}
+
+	return int64(value), nil
+}
+
 func (p *Parser) parseFloat() *node.Float {
 	n := &node.Float{Token: p.tok}
 
 	if p.tok.Type != token.Float {
-		goerror.Error(p.tok.Where, "Expected a float, got %v", p.tok)
+		p.Diags.Error(p.tok.Where, "Expected a float, got %v", p.tok)
 		p.next()
 		return nil
 	}
 
-	n.Value, _ = strconv.ParseFloat(p.tok.Data, 8)
+	n.Value, _ = strconv.ParseFloat(p.tok.Data, 64)
 	p.next()
 	return n
 }
 
+// Element type of a type keyword
+func TypeOf(type_ token.Type) (agen.Type, bool) {
+	switch type_ {
+	case token.TypeByte, token.TypeChar:     return agen.I8,  true
+	case token.TypeInt16:                    return agen.I16, true
+	case token.TypeInt32:                    return agen.I32, true
+	case token.TypeInt64, token.TypeFloat64: return agen.I64, true
+
+	default: return agen.I8, false
+	}
+}
+
 func (p *Parser) parseType() *node.Type {
 	n := &node.Type{Token: p.tok}
 
-	switch p.tok.Type {
-	case token.TypeByte, token.TypeChar:     n.Type = agen.I8
-	case token.TypeInt16:                    n.Type = agen.I16
-	case token.TypeInt32:                    n.Type = agen.I32
-	case token.TypeInt64, token.TypeFloat64: n.Type = agen.I64
-
-	default:
-		goerror.Error(p.tok.Where, "Expected a type (byte/char/i16/i32/i64/f164), got %v", p.tok)
+	var ok bool
+	if n.Type, ok = TypeOf(p.tok.Type); !ok {
+		p.expected("a type (byte/char/i16/i32/i64/f64)")
 		p.next()
 		return nil
 	}
@@ -305,10 +1003,20 @@ func (p *Parser) parseFunc() node.Expr {
 
 	if p.tok.Type == token.SizeOf {
 		return p.parseSizeOf(start)
+	} else if p.tok.Type == token.Bits {
+		return p.parseBits(start)
+	} else if p.tok.Type == token.Pad {
+		return p.parsePad(start)
+	} else if p.tok.Type == token.StrLen {
+		return p.parseStrLen(start)
+	} else if p.tok.Type == token.StrCat {
+		return p.parseStrCat(start)
+	} else if p.tok.Type == token.Defined {
+		return p.parseDefined(start)
 	} else if p.tok.Type.IsBinOp() {
 		return p.parseBinOp(start)
 	} else {
-		goerror.Error(p.tok.Where, "Expected function, got %v", p.tok)
+		p.expected("function")
 		p.next()
 		return nil
 	}
@@ -323,14 +1031,111 @@ func (p *Parser) parseSizeOf(start token.Token) *node.SizeOf {
 	} else if p.tok.Type.IsType() {
 		n.Type = p.parseType()
 	} else {
-		goerror.Error(p.tok.Where, "Expected an identifier or a type, got %v", p.tok)
+		p.expected("an identifier or a type")
 		p.next()
 		return nil
 	}
 
 	if p.tok.Type != token.RParen {
-		goerror.Error(p.tok.Where, "Expected matching '%v', got %v", token.RParen, p.tok)
-		goerror.Note(start.Where, "Opened here")
+		p.Diags.Error(p.tok.Where, "Expected matching '%v', got %v", token.RParen, p.tok)
+		p.Diags.Note(start.Where, "Opened here")
+		return nil
+	}
+	p.next()
+
+	return n
+}
+
+func (p *Parser) parseDefined(start token.Token) *node.Defined {
+	n := &node.Defined{Token: start}
+
+	p.next()
+	if n.Id = p.parseId(); n.Id == nil {
+		return nil
+	}
+
+	if p.tok.Type != token.RParen {
+		p.Diags.Error(p.tok.Where, "Expected matching '%v', got %v", token.RParen, p.tok)
+		p.Diags.Note(start.Where, "Opened here")
+		return nil
+	}
+	p.next()
+
+	return n
+}
+
+func (p *Parser) parseBits(start token.Token) *node.Bits {
+	n := &node.Bits{Token: start}
+
+	p.next()
+	if n.Value = p.parseExpr(); n.Value == nil {
+		return nil
+	}
+
+	if p.tok.Type != token.RParen {
+		p.Diags.Error(p.tok.Where, "Expected matching '%v', got %v", token.RParen, p.tok)
+		p.Diags.Note(start.Where, "Opened here")
+		return nil
+	}
+	p.next()
+
+	return n
+}
+
+func (p *Parser) parsePad(start token.Token) *node.Pad {
+	n := &node.Pad{Token: start}
+
+	p.next()
+	if n.Length = p.parseExpr(); n.Length == nil {
+		return nil
+	} else if n.Value = p.parseExpr(); n.Value == nil {
+		return nil
+	}
+
+	if p.tok.Type != token.RParen {
+		p.Diags.Error(p.tok.Where, "Expected matching '%v', got %v", token.RParen, p.tok)
+		p.Diags.Note(start.Where, "Opened here")
+		return nil
+	}
+	p.next()
+
+	return n
+}
+
+func (p *Parser) parseStrLen(start token.Token) *node.StrLen {
+	n := &node.StrLen{Token: start}
+
+	p.next()
+	if n.Value = p.parseExpr(); n.Value == nil {
+		return nil
+	}
+
+	if p.tok.Type != token.RParen {
+		p.Diags.Error(p.tok.Where, "Expected matching '%v', got %v", token.RParen, p.tok)
+		p.Diags.Note(start.Where, "Opened here")
+		return nil
+	}
+	p.next()
+
+	return n
+}
+
+func (p *Parser) parseStrCat(start token.Token) *node.StrCat {
+	n := &node.StrCat{Token: start}
+
+	p.next()
+	for p.tok.Type != token.RParen && !p.endsArgs() {
+		arg := p.parseExpr()
+		if node.IsNil(arg) {
+			return nil
+		}
+
+		n.Args = append(n.Args, arg)
+	}
+
+	if p.tok.Type != token.RParen {
+		p.Diags.Error(p.tok.Where, "Expected matching '%v', got %v", token.RParen, p.tok)
+		p.Diags.Note(start.Where, "Opened here")
 		return nil
 	}
 	p.next()
@@ -341,18 +1146,33 @@ func (p *Parser) parseSizeOf(start token.Token) *node.SizeOf {
 func (p *Parser) parseBinOp(start token.Token) *node.BinOp {
 	n := &node.BinOp{Token: start}
 	n.Op = p.tok.Data
+	op  := p.tok.Type
 
 	p.next()
-	for p.tok.Type != token.RParen {
+	for p.tok.Type != token.RParen && !p.endsArgs() {
 		n.Args = append(n.Args, p.parseExpr())
 	}
 
 	if p.tok.Type != token.RParen {
-		goerror.Error(p.tok.Where, "Expected matching '%v', got %v", token.RParen, p.tok)
-		goerror.Note(start.Where, "Opened here")
+		p.Diags.Error(p.tok.Where, "Expected matching '%v', got %v", token.RParen, p.tok)
+		p.Diags.Note(start.Where, "Opened here")
 		return nil
 	}
 	p.next()
 
+	switch {
+	case op == token.LogNot && len(n.Args) != 1:
+		p.Diags.Error(start.Where, "Expected 1 argument to '%v', got %v", n.Op, len(n.Args))
+		return nil
+
+	case op.IsCmp() && len(n.Args) != 2:
+		p.Diags.Error(start.Where, "Expected 2 arguments to '%v', got %v", n.Op, len(n.Args))
+		return nil
+
+	case len(n.Args) == 0:
+		p.Diags.Error(start.Where, "Expected at least 1 argument to '%v'", n.Op)
+		return nil
+	}
+
 	return n
 }
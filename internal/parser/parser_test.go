@@ -0,0 +1,89 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/avm-collection/anasm/internal/node"
+)
+
+// Malformed input has to be reported, not crash the parser or the statements printed by -E.
+// Parts of statements that fail to parse are typed nil, so every pass over them has to check.
+var malformed = []struct {
+	name, src string
+}{
+	{"macro with two names",    "mac X Y = 1\n.entry\n\thlt\n"},
+	{"label of an instruction", ".entry\n.hlt\n\thlt\nhlt:\n\thlt\n"},
+	{"names of instructions",   "let psh byte = 1\nemb hlt \"x\"\nmac jmp = 1\n"},
+	{"invalid names",           "let bad-name i64 = 0\n.entry\n\tpsh (sizeof psh)\n\thlt\n"},
+	{"defined instruction",     ".entry\n\tpsh (defined hlt)\n\thlt\n"},
+	{"unclosed pad",            "let x = (pad 4 1\n.entry\n\thlt\n"},
+	{"fill without a count",    "let x = 1 ..\n.entry\n\thlt\n"},
+}
+
+func TestMalformed(t *testing.T) {
+	for _, tt := range malformed {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(tt.src, "<test>")
+			for _, s := range p.Parse().List {
+				node.Source(s)
+			}
+		})
+	}
+}
+
+// Reaching the end of the file names the token before it, an instruction missing its argument by
+// its mnemonic
+func TestEndOfFile(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{".entry\n\tpsh",      "Expected an expression after 'psh', reached end of file"},
+		{"mac N",              "Expected assignment with '=' after 'N', reached end of file"},
+		{"let x",              "Expected a type (byte/char/i16/i32/i64/f64) after 'x', reached " +
+		                       "end of file"},
+		{"let x byte",         "Expected assignment with '=' after 'byte', reached end of file"},
+		{"let x byte = 1,",    "Expected a value after ',', reached end of file"},
+		{"let x byte = 1, 2,", "Expected a value after ',', reached end of file"},
+	}
+
+	for _, tt := range tests {
+		p := New(tt.src, "<test>")
+		p.Parse()
+
+		p.Diags.Silent = true
+		p.Diags.Flush()
+
+		if diags := p.Diags.Take(); len(diags) == 0 || diags[0].Msg != tt.want {
+			t.Errorf("%q: got %+v, expected '%v'", tt.src, diags, tt.want)
+		}
+	}
+}
+
+// A value the lexer fails on is reported once, the list continues after it without an error about
+// what follows
+func TestValueErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want int // Diagnostics
+	}{
+		{"let a i64 = ], 1, 2\n",     1},
+		{"let a i64 = 1, ], 2\n",     1},
+		{"let a i64 = 1, 2, ]\n",     1},
+		{"let a i64 = ]\n.entry\n",   1},
+		{"let a i64 = 1, ] 2\n",      1},
+		{"let a i64 = ], ], 2\n",     2},
+		{"let a i64 = ], 1 .. 2, ]\n", 2},
+	}
+
+	for _, tt := range tests {
+		p := New(tt.src, "<test>")
+		p.Parse()
+
+		p.Diags.Silent = true
+		p.Diags.Flush()
+
+		if diags := p.Diags.Take(); len(diags) != tt.want {
+			t.Errorf("%q: got %+v, expected %v diagnostics", tt.src, diags, tt.want)
+		}
+	}
+}
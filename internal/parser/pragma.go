@@ -0,0 +1,146 @@
+package parser
+
+import (
+	"strings"
+)
+
+// Warning pragmas, comments suppressing warnings of the compiler in a part of a file:
+//
+//     # anasm: disable truncation, mixed-data
+//     # anasm: enable truncation
+//     # anasm: disable-next-line truncation
+//
+// 'disable' suppresses the warnings from its line to the matching 'enable' or the end of the
+// file, 'enable' without names ends all of them. The scope is the text of the file, so pragmas
+// in an included file do not apply to the file including it, or the other way around.
+
+const PragmaPrefix = "anasm:"
+
+// Names of the warnings that pragmas can suppress, see Compiler.warn
+var Warnings = []string{
+	"bounds", "code-addr", "fallthrough", "meta", "mixed-data", "operand-kind", "operand-width",
+	"stack", "truncation",
+}
+
+// Rows of a file where a warning is suppressed
+type Suppression struct {
+	Warning  string
+	Path     string
+	From, To int // Rows, including both
+}
+
+// Suppressions of the pragmas in the files parsed
+func (p *Parser) Suppressions() []Suppression {
+	return p.suppressions
+}
+
+// Whether the warning is suppressed at the row of the file
+func Suppressed(suppressions []Suppression, warning, path string, row int) bool {
+	for _, s := range suppressions {
+		if s.Warning == warning && s.Path == path && row >= s.From && row <= s.To {
+			return true
+		}
+	}
+
+	return false
+}
+
+func knownWarning(name string) bool {
+	for _, warning := range Warnings {
+		if warning == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reads the pragmas of the file that was just parsed
+func (p *Parser) readPragmas(path string, rows int) {
+	open := make(map[string]int) // Disabled warning -> row of the pragma
+
+	for _, comment := range p.l.LineComments() {
+		if !strings.HasPrefix(comment.Text, PragmaPrefix) {
+			continue
+		}
+
+		row    := comment.Where.Row
+		fields := strings.Fields(strings.ReplaceAll(comment.Text[len(PragmaPrefix):], ",", " "))
+		if len(fields) == 0 {
+			p.Diags.Warning(comment.Where, "Empty pragma, expected 'disable', 'enable' or " +
+			                "'disable-next-line'")
+			continue
+		} else if fields[0] != "disable" && fields[0] != "enable" &&
+		          fields[0] != "disable-next-line" {
+			p.Diags.Warning(comment.Where, "Unknown pragma '%v', expected 'disable', 'enable' or " +
+			                "'disable-next-line'", fields[0])
+			continue
+		}
+
+		names := fields[1:]
+		if len(names) == 0 && fields[0] != "enable" {
+			p.Diags.Warning(comment.Where, "Expected the names of warnings after '%v'", fields[0])
+			continue
+		}
+
+		for _, name := range names {
+			if !knownWarning(name) {
+				p.Diags.Warning(comment.Where, "Unknown warning '%v' in pragma", name)
+				p.Diags.Note(comment.Where, "Known warnings are %v", strings.Join(Warnings, ", "))
+			}
+		}
+
+		switch fields[0] {
+		case "disable":
+			for _, name := range names {
+				if _, ok := open[name]; !ok && knownWarning(name) {
+					open[name] = row
+				}
+			}
+
+		case "disable-next-line":
+			for _, name := range names {
+				if knownWarning(name) {
+					p.suppressions = append(p.suppressions, Suppression{
+						Warning: name, Path: path, From: row + 1, To: row + 1,
+					})
+				}
+			}
+
+		case "enable":
+			if len(names) == 0 {
+				for _, name := range Warnings {
+					if _, ok := open[name]; ok {
+						names = append(names, name)
+					}
+				}
+			}
+
+			for _, name := range names {
+				from, ok := open[name]
+				if !ok {
+					if knownWarning(name) {
+						p.Diags.Warning(comment.Where, "Warning '%v' is not disabled", name)
+					}
+
+					continue
+				}
+
+				p.suppressions = append(p.suppressions, Suppression{
+					Warning: name, Path: path, From: from, To: row,
+				})
+				delete(open, name)
+			}
+
+		}
+	}
+
+	// Disabled until the end of the file
+	for _, name := range Warnings {
+		if from, ok := open[name]; ok {
+			p.suppressions = append(p.suppressions, Suppression{
+				Warning: name, Path: path, From: from, To: rows,
+			})
+		}
+	}
+}
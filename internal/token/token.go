@@ -2,9 +2,17 @@ package token
 
 import "fmt"
 
+// Columns and lengths count bytes of the source, so a tab is one column and escape sequences
+// count as written, not as what they stand for
 type Where struct {
 	Row,  Col, Len  int
 	Path, Line      string
+
+	// End of the token, the column and offset are right after its last character
+	EndRow, EndCol    int
+	Offset, EndOffset int // Byte offsets in the file
+
+	IncludedFrom *Where // The include directive of the file, nil in the main file
 }
 
 func (w Where) AtRow()   int    {return w.Row}
@@ -34,6 +42,7 @@ const (
 
 	Let
 	Macro
+	Data
 	Equals
 
 	TypeByte
@@ -55,7 +64,23 @@ const (
 	BitSRight
 	BitSLeft
 
+	CmpEq
+	CmpNotEq
+	CmpLess
+	CmpLessEq
+	CmpGreater
+	CmpGreaterEq
+
+	LogAnd
+	LogOr
+	LogNot
+
 	SizeOf
+	Bits
+	Pad
+	StrLen
+	StrCat
+	Defined
 
 	Dots
 
@@ -63,7 +88,14 @@ const (
 	RParen
 
 	Include
+	IncStr
+	Bytes
 	Embed
+	Meta
+	Assert
+	Org
+	Namespace
+	End
 
 	Error
 	count // Count of all token types
@@ -71,7 +103,7 @@ const (
 
 // TODO: Somehow make this compile-time
 func AllTokensCoveredTest() {
-	if count != 37 {
+	if count != 59 {
 		panic("Cover all token types")
 	}
 }
@@ -94,6 +126,7 @@ func (t Type) String() string {
 
 	case Let:    return "let"
 	case Macro:  return "mac"
+	case Data:   return "dat"
 	case Equals: return "="
 
 	case TypeByte:    return "byte"
@@ -115,7 +148,24 @@ func (t Type) String() string {
 	case BitSRight: return ">>"
 	case BitSLeft:  return "<<"
 
+	case CmpEq:        return "=="
+	case CmpNotEq:     return "!="
+	case CmpLess:      return "<"
+	case CmpLessEq:    return "<="
+	case CmpGreater:   return ">"
+	case CmpGreaterEq: return ">="
+
+	case LogAnd: return "&&"
+	case LogOr:  return "||"
+	case LogNot: return "!"
+
 	case SizeOf: return "sizeof"
+	case Bits:   return "bits"
+	case Pad:    return "pad"
+	case StrLen: return "strlen"
+	case StrCat: return "strcat"
+
+	case Defined: return "defined"
 
 	case Dots: return ".."
 
@@ -123,7 +173,15 @@ func (t Type) String() string {
 	case RParen: return ")"
 
 	case Include: return "include"
+	case IncStr:  return "incstr"
+	case Bytes:   return "bytes"
 	case Embed:   return "embed"
+	case Meta:    return "meta"
+	case Assert:  return "assert"
+	case Org:     return "org"
+
+	case Namespace: return "namespace"
+	case End:       return "end"
 
 	case Error: return "error"
 
@@ -149,7 +207,24 @@ func (type_ Type) IsType() bool {
 
 func (type_ Type) IsBinOp() bool {
 	switch type_ {
-	case Add, Sub, Mult, Div, Mod, Pow: return true
+	case Add, Sub, Mult, Div, Mod, Pow, BitAnd, BitOr, BitSRight, BitSLeft: return true
+
+	default: return type_.IsCmp() || type_.IsLogical()
+	}
+}
+
+// Comparisons and logical operators yield 1 for true and 0 for false
+func (type_ Type) IsCmp() bool {
+	switch type_ {
+	case CmpEq, CmpNotEq, CmpLess, CmpLessEq, CmpGreater, CmpGreaterEq: return true
+
+	default: return false
+	}
+}
+
+func (type_ Type) IsLogical() bool {
+	switch type_ {
+	case LogAnd, LogOr, LogNot: return true
 
 	default: return false
 	}